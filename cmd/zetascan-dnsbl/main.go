@@ -0,0 +1,183 @@
+// Command zetascan-dnsbl serves a local DNSBL zone answered from zetascan, for
+// software that only speaks DNSBL. It uses the same config file, cache and
+// failover as zetascan-query.
+//
+//	zetascan-dnsbl -zone zs.local -listen 127.0.0.1:5353
+//
+//	dig -p 5353 @127.0.0.1 1.9.9.127.zs.local A
+//
+// Add to Postfix main.cf, with the zone forwarded by the local resolver:
+//
+//	smtpd_client_restrictions = reject_rbl_client zs.local=127.0.0.3
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/config"
+	"github.com/zetascan/go-zetascan/zetascan/diskcache"
+	"github.com/zetascan/go-zetascan/zetascan/dnsbl"
+	"github.com/zetascan/go-zetascan/zetascan/prommetrics"
+	"github.com/zetascan/go-zetascan/zetascan/rediscache"
+)
+
+// Result cache backends for -cache and the config file's cache setting
+func init() {
+	config.RegisterCache("", diskcache.OpenDefault)
+	config.RegisterCache("redis", rediscache.OpenDefault)
+	config.RegisterCache("rediss", rediscache.OpenDefault)
+}
+
+func main() {
+
+	configPath := flag.String("config", "", "Config file (default $"+config.EnvConfig+" or "+config.DefaultPath()+")")
+	profile := flag.String("profile", "", "Config profile, e.g prod or staging (default $"+config.EnvProfile+" or the file's profile)")
+	apiKey := flag.String("apikey", "", "Specify API key, preferably via $"+config.EnvAPIKey+" or the config file")
+	ipAuth := flag.Bool("ipauth", false, "Toggle to bypass API key and use IP authentication")
+	format := flag.String("format", "", "Specify the query format (text, http, json, jsonx, dns), or a comma separated fallback chain (default json)")
+	endpoint := flag.String("endpoint", "", "Query another end-point, host[:port] or URL, e.g on-prem or the emulator")
+	cache := flag.String("cache", "", "Cache results in this file, or a redis:// URL to share them between instances (default in memory)")
+
+	listen := flag.String("listen", "127.0.0.1:5353", "Serve DNS over UDP and TCP on this address")
+	zone := flag.String("zone", dnsbl.DefaultConfig.Zone, "The DNSBL zone served")
+	codes := flag.String("codes", formatCodes(dnsbl.DefaultCodes), "Comma separated codes answered for matching items, e.g 127.0.0.4=shXBL|shCBL+score:0.5 or 127.0.1.1=whitelisted")
+	ttl := flag.Duration("ttl", dnsbl.DefaultConfig.TTL, "TTL of listed answers")
+	negativeTTL := flag.Duration("negative-ttl", dnsbl.DefaultConfig.NegativeTTL, "TTL of NXDOMAIN answers")
+	timeout := flag.Duration("timeout", dnsbl.DefaultConfig.Timeout, "Maximum time for a lookup, answering SERVFAIL after")
+
+	verbose := flag.Bool("verbose", false, "Log each answer, failed lookups, requests, retries and DNS answers")
+	metrics := flag.String("metrics", "", "Serve Prometheus metrics on this address at /metrics, e.g 127.0.0.1:9140")
+
+	flag.Parse()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	settings, err := config.Load(*configPath, *profile)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flags := config.Settings{
+		APIKey:   zetascan.Secret(*apiKey),
+		Method:   *format,
+		Endpoint: *endpoint,
+		Cache:    *cache,
+	}
+
+	if set["ipauth"] {
+		flags.IPAuth = ipAuth
+	}
+
+	settings = settings.Merge(flags)
+
+	myzetascan, closer, err := settings.Api()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer closer.Close()
+
+	logger := slog.New(slog.DiscardHandler)
+
+	if *verbose {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		myzetascan.Logger = logger
+	}
+
+	if *metrics != "" {
+		collector := prommetrics.New()
+		myzetascan.Metrics = collector
+
+		go func() {
+			log.Fatal(collector.ListenAndServe(*metrics))
+		}()
+	}
+
+	// Cache in memory unless the config has a cache
+	clientConfig := zetascan.DefaultClientConfig
+	clientConfig.CacheTTL = zetascan.DefaultCacheTTL
+
+	client := zetascan.NewClient(myzetascan, clientConfig)
+
+	s := dnsbl.NewServer(client)
+	s.Logger = logger
+
+	s.Config.Zone = strings.TrimSuffix(*zone, ".")
+	s.Config.TTL = *ttl
+	s.Config.NegativeTTL = *negativeTTL
+	s.Config.Timeout = *timeout
+
+	if s.Config.Zone == "" {
+		log.Fatal("Empty -zone")
+	}
+
+	if s.Config.Codes, err = dnsbl.ParseCodes(*codes); err != nil {
+		log.Fatal(err)
+	}
+
+	// Listen before serving, so address errors are reported at once
+	pc, err := net.ListenPacket("udp", *listen)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+
+	log.Println("zetascan-dnsbl serving", s.Config.Zone, "on", pc.LocalAddr(), "with codes", formatCodes(s.Config.Codes))
+
+	go func() { errs <- s.ServePacket(pc) }()
+	go func() { errs <- s.Serve(l) }()
+
+	// Shutdown cleanly on SIGINT/SIGTERM
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case <-sig:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := s.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+
+	client.Close()
+}
+
+// formatCodes return codes as parsed by dnsbl.ParseCodes
+func formatCodes(codes []dnsbl.Code) string {
+
+	var fields []string
+
+	for _, code := range codes {
+		fields = append(fields, code.String())
+	}
+
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,58 @@
+// Command zetascan-emulator serves a local zetascan compatible API for offline
+// testing, over HTTP and DNS, answering from a fixture file.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+func main() {
+
+	httpAddr := flag.String("http", "127.0.0.1:8080", "Listen address for HTTP queries (empty to disable)")
+	dnsAddr := flag.String("dns", "127.0.0.1:5353", "Listen address for DNS queries, UDP and TCP (empty to disable)")
+	fixtures := flag.String("fixtures", "", "JSON fixture file, defaults to the documented test items")
+	apiKey := flag.String("apikey", "", "Require this API key on HTTP queries")
+
+	flag.Parse()
+
+	items := zetascantest.DefaultFixtures()
+
+	if *fixtures != "" {
+
+		var err error
+		items, err = zetascantest.LoadFixtures(*fixtures)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	server := zetascantest.NewUnstartedServer(items)
+	server.Key = *apiKey
+
+	if err := server.Start(*httpAddr, *dnsAddr); err != nil {
+		log.Fatal(err)
+	}
+
+	if server.URL != "" {
+		log.Println("Serving HTTP queries on", server.URL)
+	}
+
+	if server.DNSAddr != "" {
+		log.Println("Serving DNS queries on", server.DNSAddr)
+	}
+
+	log.Println("Loaded", len(items), "items")
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	server.Close()
+}
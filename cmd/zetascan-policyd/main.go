@@ -0,0 +1,153 @@
+// Command zetascan-policyd is a Postfix SMTP access policy delegation server
+// backed by zetascan.
+//
+// Add to Postfix main.cf:
+//
+//	smtpd_recipient_restrictions =
+//	    ...
+//	    reject_unauth_destination
+//	    check_policy_service inet:127.0.0.1:10040
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/policyd"
+	"github.com/zetascan/go-zetascan/zetascan/prommetrics"
+)
+
+func main() {
+
+	apiKey := flag.String("apikey", "", "Specify API key")
+	ipAuth := flag.Bool("ipauth", false, "Toggle to bypass API key and use IP authentication")
+	format := flag.String("format", "json", "Specify the query format (text, http, json, jsonx, dns)")
+
+	listen := flag.String("listen", "tcp:127.0.0.1:10040", "Listen address, tcp:host:port or unix:/path/to/socket")
+
+	reject := flag.Float64("reject", policyd.DefaultConfig.RejectScore, "REJECT at or above this score (0 to disable)")
+	deferScore := flag.Float64("defer", policyd.DefaultConfig.DeferScore, "DEFER_IF_PERMIT at or above this score (0 to disable)")
+	prepend := flag.Bool("prepend", true, "PREPEND a X-Zetascan-Score header for listed items below the thresholds")
+	fail := flag.String("fail", "dunno", "Action when zetascan is unavailable (dunno, defer, reject)")
+	timeout := flag.Duration("timeout", policyd.DefaultConfig.Timeout, "Maximum time to answer a policy request")
+
+	checks := flag.String("check", "client,helo,sender,recipient", "Comma separated attributes to check")
+	verbose := flag.Bool("verbose", false, "Log lookup errors, requests, retries and DNS answers")
+	metrics := flag.String("metrics", "", "Serve Prometheus metrics on this address at /metrics, e.g 127.0.0.1:9140")
+
+	flag.Parse()
+
+	var err error
+	var myzetascan zetascan.Api
+
+	// Init with our API key
+	myzetascan, err = myzetascan.Init(*apiKey, *ipAuth)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	myzetascan.ApiMethod = *format
+
+	if *metrics != "" {
+		collector := prommetrics.New()
+		myzetascan.Metrics = collector
+
+		go func() {
+			log.Fatal(collector.ListenAndServe(*metrics))
+		}()
+	}
+
+	if *verbose {
+		myzetascan.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	server := policyd.NewServer(myzetascan)
+
+	server.Config.RejectScore = *reject
+	server.Config.DeferScore = *deferScore
+	server.Config.Prepend = *prepend
+	server.Config.Timeout = *timeout
+
+	switch strings.ToLower(*fail) {
+	case "dunno":
+		server.Config.FailAction = policyd.ActionDunno
+	case "defer":
+		server.Config.FailAction = policyd.ActionDeferIfPermit + " 4.7.1 Zetascan lookup failed, try again later"
+	case "reject":
+		server.Config.FailAction = policyd.ActionReject + " 5.7.1 Zetascan lookup failed"
+	default:
+		log.Fatal("Unknown -fail action: ", *fail)
+	}
+
+	server.Config.CheckClient = false
+	server.Config.CheckHelo = false
+	server.Config.CheckSender = false
+	server.Config.CheckRecipient = false
+
+	for _, check := range strings.Split(*checks, ",") {
+		switch strings.TrimSpace(check) {
+		case "client":
+			server.Config.CheckClient = true
+		case "helo":
+			server.Config.CheckHelo = true
+		case "sender":
+			server.Config.CheckSender = true
+		case "recipient":
+			server.Config.CheckRecipient = true
+		default:
+			log.Fatal("Unknown -check attribute: ", check)
+		}
+	}
+
+	if *verbose {
+		server.ErrorLog = func(err error) { log.Println(err) }
+	}
+
+	network, address, ok := strings.Cut(*listen, ":")
+
+	if !ok || (network != "tcp" && network != "unix") {
+		log.Fatal("Invalid -listen address, expected tcp:host:port or unix:/path")
+	}
+
+	// Remove a stale socket from a previous run
+	if network == "unix" {
+		if err := policyd.RemoveSocket(address); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Shutdown cleanly on SIGINT/SIGTERM, answering in-flight requests
+	stopped := make(chan struct{})
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+
+		close(stopped)
+	}()
+
+	log.Println("zetascan-policyd listening on", *listen)
+
+	if err := server.ListenAndServe(network, address); err != nil {
+		log.Fatal(err)
+	}
+
+	<-stopped
+}
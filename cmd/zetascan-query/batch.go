@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// runBatch queries many items concurrently, writing results as they complete
+func runBatch(args []string) int {
+
+	fs := newFlagSet("batch", "[item...]",
+		"Query items concurrently, from the arguments, an -input file or one per line on stdin.\n"+
+			"Blank lines and lines starting with # are skipped. Results are written as they complete.\n"+
+			"The exit status is 1 if any item is blacklisted, 2 if any query failed.")
+
+	f := addApiFlags(fs, "json")
+	input := fs.String("input", "", "Read items from this file, - for stdin (default the arguments, or stdin)")
+	column := fs.String("column", "", "Read items from this CSV column of the input, a header name or 1-based number")
+	concurrency := fs.Int("concurrency", zetascan.DefaultConcurrency, "Number of parallel queries")
+	rate := fs.Float64("rate", 0, "Maximum queries per second (0 for no limit)")
+	timeout := fs.Duration("timeout", 0, "Maximum time for the whole batch (0 for no limit)")
+	checkpointPath := fs.String("checkpoint", "", "Record completed items in this file, and skip them when run again")
+	progress := fs.Bool("progress", isTerminal(os.Stderr), "Show progress on stderr")
+	output := addOutputFlags(fs)
+
+	if status, ok := parse(fs, args); !ok {
+		return status
+	}
+
+	myzetascan, closer, err := f.api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	items, err := batchItems(fs.Args(), *input, *column)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	var cp *checkpoint
+	skipped := 0
+
+	if *checkpointPath != "" {
+
+		if cp, err = openCheckpoint(*checkpointPath); err != nil {
+			return fail(err)
+		}
+		defer cp.Close()
+
+		todo := cp.remaining(items)
+		skipped = len(items) - len(todo)
+		items = todo
+
+		// Resuming, the header was written by the previous run
+		output.noHeader = skipped > 0
+	}
+
+	w, err := output.writer(os.Stdout)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// Stop starting queries on SIGINT/SIGTERM, the checkpoint allows the run to resume
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	p := newProgress(len(items), skipped)
+
+	if *progress {
+		stop := p.start()
+		defer stop()
+	}
+
+	status := exitClean
+	var writeErr error
+
+	err = myzetascan.QueryEach(ctx, items, zetascan.BatchOptions{Concurrency: *concurrency, Rate: *rate}, func(i int, result zetascan.BatchResult) {
+
+		// A query cancelled by the interrupt is left for the next run
+		if writeErr != nil || (result.Err != nil && ctx.Err() != nil) {
+			return
+		}
+
+		o := newOutput(myzetascan, result.Item, result.Record, result.Err)
+		p.add(o)
+
+		if writeErr = w.Write(o); writeErr != nil {
+			cancel()
+			return
+		}
+
+		status = max(status, o.exitStatus())
+
+		if cp != nil && result.Err == nil {
+			if writeErr = cp.complete(result.Item); writeErr != nil {
+				cancel()
+			}
+		}
+	})
+
+	if closeErr := w.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+
+	if writeErr != nil {
+		return fail(writeErr)
+	}
+
+	if errors.Is(err, context.Canceled) {
+		err = errors.New("Interrupted")
+	}
+
+	if err != nil {
+		if cp != nil {
+			return fail(fmt.Errorf("%w, run again with -checkpoint %s to resume", err, *checkpointPath))
+		}
+		return fail(err)
+	}
+
+	return status
+}
+
+// batchItems return the items from the arguments, or the input file or stdin
+func batchItems(args []string, input, column string) ([]string, error) {
+
+	if len(args) > 0 {
+
+		if input != "" {
+			return nil, errors.New("Specify items as arguments or with -input, not both")
+		}
+
+		return args, nil
+	}
+
+	if input == "" {
+		input = "-"
+	}
+
+	r, err := openInput(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	if column != "" {
+		return readColumn(r, column)
+	}
+
+	return readItems(r)
+}
+
+// progress counts the completed items of a batch
+type progress struct {
+	mu      sync.Mutex
+	total   int
+	skipped int
+	done    int
+	listed  int
+	errors  int
+	started time.Time
+}
+
+func newProgress(total, skipped int) *progress {
+	return &progress{total: total, skipped: skipped, started: time.Now()}
+}
+
+func (p *progress) add(o Output) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+
+	switch o.Verdict {
+	case "listed":
+		p.listed++
+	case "error":
+		p.errors++
+	}
+}
+
+// start writes the progress to stderr until the returned func is called
+func (p *progress) start() (stop func()) {
+
+	if p.skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming, %d items already completed\n", p.skipped)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.print("\r")
+			case <-done:
+				p.print("\r")
+				fmt.Fprintln(os.Stderr)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (p *progress) print(prefix string) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	elapsed := time.Since(p.started).Seconds()
+	rate := 0.0
+
+	if elapsed > 0 {
+		rate = float64(p.done) / elapsed
+	}
+
+	fmt.Fprintf(os.Stderr, "%s%d/%d queried, %d listed, %d errors, %.1f/s ", prefix, p.done, p.total, p.listed, p.errors, rate)
+}
+
+// isTerminal return if f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
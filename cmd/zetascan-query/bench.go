@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// sample is the result of one benchmark query
+type sample struct {
+	Endpoint    string  `json:"endpoint"`
+	Method      string  `json:"method"`
+	Concurrency int     `json:"concurrency"`
+	Item        string  `json:"item"`
+	StartMs     float64 `json:"startMs"` // Since the run started
+	LatencyMs   float64 `json:"latencyMs"`
+	Verdict     string  `json:"verdict"`
+	Error       string  `json:"error,omitempty"` // The error type, see zetascan.ErrorType
+}
+
+// benchRun is the summary of one endpoint, method and concurrency level
+type benchRun struct {
+	Endpoint    string         `json:"endpoint"`
+	Method      string         `json:"method"`
+	Concurrency int            `json:"concurrency"`
+	Queries     int            `json:"queries"`
+	Errors      int            `json:"errors"`
+	ErrorTypes  map[string]int `json:"errorTypes,omitempty"`
+	DurationS   float64        `json:"durationS"`
+	QPS         float64        `json:"qps"`
+
+	// Latency of successful queries
+	P50Ms     float64        `json:"p50Ms"`
+	P90Ms     float64        `json:"p90Ms"`
+	P99Ms     float64        `json:"p99Ms"`
+	MaxMs     float64        `json:"maxMs"`
+	Histogram []histogramBar `json:"histogram"`
+
+	samples []sample
+}
+
+// histogramBar counts the latencies below an upper bound, the last bar has no bound
+type histogramBar struct {
+	UpToMs float64 `json:"upToMs,omitempty"`
+	Count  int     `json:"count"`
+}
+
+// Histogram bucket upper bounds in milliseconds
+var histogramBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// runBench measures throughput and latency across methods, end-points and concurrency levels
+func runBench(args []string) int {
+
+	fs := newFlagSet("bench", "[item...]",
+		"Benchmark queries of the items (default the documented test items) for every combination\n"+
+			"of -format, -endpoints and -concurrency, for a -duration or a -count of queries each.")
+
+	f := addApiFlags(fs, "")
+	methods := fs.String("format", "json", "Comma separated query formats to benchmark")
+	endpoints := fs.String("endpoints", "", "Comma separated end-points to benchmark (default the configured end-point)")
+	levels := fs.String("concurrency", "1,8", "Comma separated concurrency levels")
+	duration := fs.Duration("duration", 10*time.Second, "Duration of each run")
+	count := fs.Int("count", 0, "Number of queries in each run, instead of -duration")
+	timeout := fs.Duration("timeout", 10*time.Second, "Maximum time for each query")
+	input := fs.String("input", "", "Read items from this file, - for stdin")
+	column := fs.String("column", "", "Read items from this CSV column of the input, a header name or 1-based number")
+	report := fs.String("output", "text", "Report format (text, json)")
+	samplesPath := fs.String("samples", "", "Export every query to this file, as JSON if it ends in .json, otherwise CSV")
+
+	if status, ok := parse(fs, args); !ok {
+		return status
+	}
+
+	myzetascan, closer, err := f.api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	methodList := splitList(*methods)
+
+	if err := checkMethods(methodList...); err != nil {
+		return fail(err)
+	}
+
+	var concurrency []int
+
+	for _, level := range splitList(*levels) {
+
+		n, err := strconv.Atoi(level)
+
+		if err != nil || n < 1 {
+			return fail(errors.New("Invalid concurrency level: " + level))
+		}
+
+		concurrency = append(concurrency, n)
+	}
+
+	if len(concurrency) == 0 {
+		return fail(errors.New("No concurrency levels"))
+	}
+
+	if *report != "text" && *report != "json" {
+		return fail(errors.New("Unknown report format: " + *report))
+	}
+
+	var items []string
+
+	if len(fs.Args()) > 0 || *input != "" {
+		if items, err = batchItems(fs.Args(), *input, *column); err != nil {
+			return fail(err)
+		}
+	} else {
+		for _, test := range zetascan.TestItems {
+			items = append(items, test.Item)
+		}
+	}
+
+	if len(items) == 0 {
+		return fail(errors.New("No items to query"))
+	}
+
+	// A client per end-point, pooling a connection per worker, DNS queries always use the nameserver
+	clientConfig := zetascan.DefaultClientConfig
+	clientConfig.MaxIdleConnsPerHost = slices.Max(concurrency)
+
+	clients := map[string]*zetascan.Client{}
+	endpointList := splitList(*endpoints)
+
+	if len(endpointList) == 0 {
+		endpointList = []string{myzetascan.GetEndpoint()}
+	}
+
+	// Measure each method alone, without the configured fallback, enrichment or cache
+	myzetascan.MethodFallback = nil
+	myzetascan.EnrichOnHit = false
+	myzetascan.Cache = nil
+
+	for _, endpoint := range endpointList {
+		if _, ok := clients[endpoint]; !ok {
+
+			api, err := myzetascan.SetEndpoint(endpoint)
+
+			if err != nil {
+				return fail(err)
+			}
+
+			clients[endpoint] = zetascan.NewClient(api, clientConfig)
+		}
+	}
+
+	var runs []*benchRun
+
+	for _, method := range methodList {
+
+		targets := endpointList
+		if method == "dns" {
+			targets = endpointList[:1]
+		}
+
+		for _, endpoint := range targets {
+
+			client := clients[endpoint]
+
+			label := endpoint
+			if method == "dns" {
+				label = "dns://" + client.Api().DnsServer
+			}
+
+			for _, n := range concurrency {
+
+				fmt.Fprintf(os.Stderr, "Benchmarking %s via %s, concurrency %d\n", method, label, n)
+
+				run := bench(client, method, label, items, n, *duration, *count, *timeout)
+				runs = append(runs, run)
+			}
+		}
+	}
+
+	if *samplesPath != "" {
+		if err := writeSamples(*samplesPath, runs); err != nil {
+			return fail(err)
+		}
+	}
+
+	if *report == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(runs)
+	} else {
+		err = writeBenchText(os.Stdout, runs)
+	}
+
+	if err != nil {
+		return fail(err)
+	}
+
+	return exitClean
+}
+
+// bench runs one benchmark, stopping after the duration or count of queries
+func bench(client *zetascan.Client, method string, endpoint string, items []string, concurrency int, duration time.Duration, count int, timeout time.Duration) *benchRun {
+
+	run := &benchRun{Endpoint: endpoint, Method: method, Concurrency: concurrency, ErrorTypes: map[string]int{}}
+	api := client.Api(zetascan.WithMethod(method))
+
+	var issued int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	started := time.Now()
+	deadline := started.Add(duration)
+
+	for w := 0; w < concurrency; w++ {
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				n := int(atomic.AddInt64(&issued, 1))
+
+				if (count > 0 && n > count) || (count <= 0 && time.Now().After(deadline)) {
+					return
+				}
+
+				item := items[(n-1)%len(items)]
+
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				start := time.Now()
+				m, err := client.Query(ctx, item, zetascan.WithMethod(method))
+				latency := time.Since(start)
+				cancel()
+
+				s := sample{
+					Endpoint:    endpoint,
+					Method:      method,
+					Concurrency: concurrency,
+					Item:        item,
+					StartMs:     millis(start.Sub(started)),
+					LatencyMs:   millis(latency),
+					Verdict:     newOutput(api, item, m, err).Verdict,
+				}
+
+				if err != nil {
+					s.Error = zetascan.ErrorType(err)
+				}
+
+				mu.Lock()
+				run.samples = append(run.samples, s)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	run.summarise(time.Since(started))
+
+	return run
+}
+
+// summarise calculates the throughput, latency percentiles and histogram
+func (run *benchRun) summarise(elapsed time.Duration) {
+
+	run.Queries = len(run.samples)
+	run.DurationS = elapsed.Seconds()
+
+	if run.DurationS > 0 {
+		run.QPS = float64(run.Queries) / run.DurationS
+	}
+
+	var latencies []float64
+
+	for _, s := range run.samples {
+
+		if s.Error != "" {
+			run.Errors++
+			run.ErrorTypes[s.Error]++
+			continue
+		}
+
+		latencies = append(latencies, s.LatencyMs)
+	}
+
+	sort.Float64s(latencies)
+
+	run.P50Ms = percentile(latencies, 50)
+	run.P90Ms = percentile(latencies, 90)
+	run.P99Ms = percentile(latencies, 99)
+	run.MaxMs = percentile(latencies, 100)
+
+	run.Histogram = make([]histogramBar, len(histogramBounds)+1)
+
+	for i, bound := range histogramBounds {
+		run.Histogram[i].UpToMs = bound
+	}
+
+	for _, latency := range latencies {
+		i := sort.SearchFloat64s(histogramBounds, latency)
+		run.Histogram[i].Count++
+	}
+}
+
+// percentile return the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// writeBenchText renders the runs for people, with a latency histogram each
+func writeBenchText(w io.Writer, runs []*benchRun) error {
+
+	for _, run := range runs {
+
+		errorRate := 0.0
+		if run.Queries > 0 {
+			errorRate = 100 * float64(run.Errors) / float64(run.Queries)
+		}
+
+		fmt.Fprintf(w, "%s via %s, concurrency %d\n", run.Method, run.Endpoint, run.Concurrency)
+		fmt.Fprintf(w, "  %d queries in %.1fs, %.1f/s, %d errors (%.1f%%)\n", run.Queries, run.DurationS, run.QPS, run.Errors, errorRate)
+		fmt.Fprintf(w, "  latency p50 %.1fms, p90 %.1fms, p99 %.1fms, max %.1fms\n", run.P50Ms, run.P90Ms, run.P99Ms, run.MaxMs)
+
+		var types []string
+		for errType := range run.ErrorTypes {
+			types = append(types, errType)
+		}
+		sort.Strings(types)
+
+		for _, errType := range types {
+			n := run.ErrorTypes[errType]
+			fmt.Fprintf(w, "  %-13s %d (%.1f%%)\n", errType, n, 100*float64(n)/float64(run.Queries))
+		}
+
+		writeHistogram(w, run.Histogram)
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+// writeHistogram draws the bars from the first to the last non-empty bucket
+func writeHistogram(w io.Writer, bars []histogramBar) {
+
+	first, last, most := -1, -1, 0
+
+	for i, bar := range bars {
+		if bar.Count > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+			most = max(most, bar.Count)
+		}
+	}
+
+	if first < 0 {
+		return
+	}
+
+	const width = 40
+
+	for _, bar := range bars[first : last+1] {
+
+		label := "> " + formatFloat(histogramBounds[len(histogramBounds)-1]) + "ms"
+		if bar.UpToMs > 0 {
+			label = "<= " + formatFloat(bar.UpToMs) + "ms"
+		}
+
+		n := bar.Count * width / most
+		if bar.Count > 0 && n == 0 {
+			n = 1
+		}
+
+		fmt.Fprintf(w, "  %9s |%-*s %d\n", label, width, strings.Repeat("#", n), bar.Count)
+	}
+}
+
+// writeSamples exports every query, as JSON or CSV depending on the file extension
+func writeSamples(path string, runs []*benchRun) (err error) {
+
+	var samples []sample
+
+	for _, run := range runs {
+		samples = append(samples, run.samples...)
+	}
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return json.NewEncoder(f).Encode(samples)
+	}
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"endpoint", "method", "concurrency", "item", "startMs", "latencyMs", "verdict", "error"})
+
+	for _, s := range samples {
+		w.Write([]string{
+			s.Endpoint,
+			s.Method,
+			strconv.Itoa(s.Concurrency),
+			s.Item,
+			strconv.FormatFloat(s.StartMs, 'f', 3, 64),
+			strconv.FormatFloat(s.LatencyMs, 'f', 3, 64),
+			s.Verdict,
+			s.Error,
+		})
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/config"
+	"github.com/zetascan/go-zetascan/zetascan/diskcache"
+)
+
+// cacheActions are the cache subcommands
+var cacheActions = map[string]func(c *diskcache.Cache, f *apiFlags, fs *cacheFlags) int{
+	"inspect": inspectCache,
+	"export":  exportCache,
+	"purge":   purgeCache,
+	"compact": compactCache,
+	"warm":    warmCache,
+}
+
+// cacheFlags are the flags of the cache actions
+type cacheFlags struct {
+	args        []string
+	expired     bool
+	report      string
+	input       string
+	column      string
+	concurrency int
+	rate        float64
+}
+
+// runCache manages the persistent result cache
+func runCache(args []string) int {
+
+	fs := newFlagSet("cache", "inspect|export|purge|compact|warm [item...]",
+		"Manage the persistent result cache, the -cache file or "+diskcache.DefaultPath()+".\n\n"+
+			"  inspect  Summarise the cache, or show the entries of the items\n"+
+			"  export   Write every entry, or the entries of the items, as JSON lines\n"+
+			"  purge    Remove every entry, the entries of the items, or with -expired the expired entries\n"+
+			"  compact  Remove expired and excess entries, and shrink the file\n"+
+			"  warm     Query the items, from the arguments or -input, that are not cached")
+
+	f := addApiFlags(fs, "json")
+	cf := &cacheFlags{}
+	fs.BoolVar(&cf.expired, "expired", false, "purge: only remove expired entries")
+	fs.StringVar(&cf.report, "output", "text", "inspect: output format, text or json")
+	fs.StringVar(&cf.input, "input", "", "warm: read items from this file, - for stdin (default the arguments, or stdin)")
+	fs.StringVar(&cf.column, "column", "", "warm: read items from this CSV column of the input, a header name or 1-based number")
+	fs.IntVar(&cf.concurrency, "concurrency", zetascan.DefaultConcurrency, "warm: number of parallel queries")
+	fs.Float64Var(&cf.rate, "rate", 0, "warm: maximum queries per second (0 for no limit)")
+
+	// The action comes first, e.g cache purge -expired
+	if len(args) == 0 || cacheActions[args[0]] == nil {
+
+		if status, ok := parse(fs, args); !ok {
+			return status
+		}
+
+		fs.Usage()
+		return exitError
+	}
+
+	action := args[0]
+
+	if status, ok := parse(fs, args[1:]); !ok {
+		return status
+	}
+
+	cf.args = fs.Args()
+
+	settings, err := f.settings()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	path := settings.Cache
+	if path == "" {
+		path = diskcache.DefaultPath()
+	}
+
+	if config.IsRedisURL(path) {
+		return fail(errors.New("The cache subcommands manage a cache file, not a Redis-protocol store"))
+	}
+
+	c, err := diskcache.Open(path, diskcache.DefaultConfig)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer c.Close()
+
+	return cacheActions[action](c, f, cf)
+}
+
+// inspectCache summarises the cache, or shows the entries of items
+func inspectCache(c *diskcache.Cache, f *apiFlags, cf *cacheFlags) int {
+
+	if cf.report != "text" && cf.report != "json" {
+		return fail(errors.New("Unknown output format: " + cf.report))
+	}
+
+	if len(cf.args) > 0 {
+		return showEntries(c, cf)
+	}
+
+	stats, err := c.Stats()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if cf.report == "json" {
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(stats); err != nil {
+			return fail(err)
+		}
+
+		return exitClean
+	}
+
+	var methods []string
+	for method, n := range stats.Methods {
+		methods = append(methods, fmt.Sprintf("%s %d", method, n))
+	}
+	sort.Strings(methods)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "cache file\t%s\n", stats.Path)
+	fmt.Fprintf(tw, "size\t%.1f MB\n", float64(stats.Bytes)/(1<<20))
+	fmt.Fprintf(tw, "entries\t%d (%d expired)\n", stats.Entries, stats.Expired)
+	fmt.Fprintf(tw, "methods\t%s\n", strings.Join(methods, ", "))
+
+	if stats.Entries > 0 {
+		fmt.Fprintf(tw, "oldest\t%s\n", stats.Oldest.Format(time.RFC3339))
+		fmt.Fprintf(tw, "newest\t%s\n", stats.Newest.Format(time.RFC3339))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fail(err)
+	}
+
+	return exitClean
+}
+
+// showEntries shows the cached entries of items, via any method
+func showEntries(c *diskcache.Cache, cf *cacheFlags) int {
+
+	match, err := matchItems(cf.args)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	type entry struct {
+		Key string `json:"key"`
+		zetascan.CacheEntry
+	}
+
+	var entries []entry
+
+	err = c.Each(func(key string, e zetascan.CacheEntry) error {
+		if match(key) {
+			entries = append(entries, entry{Key: key, CacheEntry: e})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if cf.report == "json" {
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(entries); err != nil {
+			return fail(err)
+		}
+
+		return exitClean
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "KEY\tMETHOD\tSTORED\tEXPIRES\tVERDICT\tSCORE\t")
+
+	for _, e := range entries {
+
+		o := newOutput(zetascan.Api{}, e.Key, e.Record, nil)
+
+		expires := e.Expires.Format(time.RFC3339)
+		if e.Expired(now) {
+			expires += " (expired)"
+		}
+
+		score := 0.0
+		if len(e.Record.Results) > 0 {
+			score = e.Record.Results[0].Score
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%g\t\n", e.Key, e.Method, e.Stored.Format(time.RFC3339), expires, o.Verdict, score)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fail(err)
+	}
+
+	return exitClean
+}
+
+// exportCache writes entries as JSON lines, with their key
+func exportCache(c *diskcache.Cache, f *apiFlags, cf *cacheFlags) int {
+
+	match, err := matchItems(cf.args)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	err = c.Each(func(key string, e zetascan.CacheEntry) error {
+
+		if !match(key) {
+			return nil
+		}
+
+		return enc.Encode(struct {
+			Key string `json:"key"`
+			zetascan.CacheEntry
+		}{key, e})
+	})
+
+	if err != nil {
+		return fail(err)
+	}
+
+	return exitClean
+}
+
+// purgeCache removes every entry, the entries of items, or the expired entries
+func purgeCache(c *diskcache.Cache, f *apiFlags, cf *cacheFlags) int {
+
+	match, err := matchItems(cf.args)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	now := time.Now()
+	var fn func(key string, e zetascan.CacheEntry) bool
+
+	if cf.expired || len(cf.args) > 0 {
+		fn = func(key string, e zetascan.CacheEntry) bool {
+			return match(key) && (!cf.expired || e.Expired(now))
+		}
+	}
+
+	removed, err := c.Purge(fn)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Removed %d entries from %s\n", removed, c.Path())
+
+	return exitClean
+}
+
+// compactCache removes expired and excess entries, and shrinks the file
+func compactCache(c *diskcache.Cache, f *apiFlags, cf *cacheFlags) int {
+
+	before, err := os.Stat(c.Path())
+
+	if err != nil {
+		return fail(err)
+	}
+
+	removed, err := c.Compact()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	after, err := os.Stat(c.Path())
+
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Removed %d entries from %s, %.1f MB to %.1f MB\n", removed, c.Path(), float64(before.Size())/(1<<20), float64(after.Size())/(1<<20))
+
+	return exitClean
+}
+
+// warmCache queries the items that are not cached, via the -format method
+func warmCache(c *diskcache.Cache, f *apiFlags, cf *cacheFlags) int {
+
+	s, err := f.settings()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	// Query through the cache already open, rather than opening it again
+	s.Cache = ""
+
+	myzetascan, closer, err := s.Api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	myzetascan.Logger = f.logger()
+	myzetascan.Cache = c
+	myzetascan.CacheTTL = s.CacheTTL
+	myzetascan.CacheStale = s.CacheStale
+
+	items, err := batchItems(cf.args, cf.input, cf.column)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	// Only query the items without an unexpired entry for the method
+	var missing []string
+	now := time.Now()
+
+	for _, item := range items {
+
+		parsed, err := zetascan.ParseItem(item)
+
+		if err != nil {
+			missing = append(missing, item) // Reported by the query
+			continue
+		}
+
+		entry, ok, err := c.Get(context.Background(), myzetascan.CacheKey(parsed.String()))
+
+		if err != nil {
+			return fail(err)
+		}
+
+		if !ok || entry.Expired(now) {
+			missing = append(missing, item)
+		}
+	}
+
+	var failed int
+
+	err = myzetascan.QueryEach(context.Background(), missing, zetascan.BatchOptions{Concurrency: cf.concurrency, Rate: cf.rate}, func(i int, result zetascan.BatchResult) {
+		if result.Err != nil {
+			failed++
+			fmt.Fprintln(os.Stderr, "zetascan-query:", result.Item+":", result.Err)
+		}
+	})
+
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Warmed %s: %d queried, %d already cached, %d failed\n", c.Path(), len(missing)-failed, len(items)-len(missing), failed)
+
+	if failed > 0 {
+		return exitError
+	}
+
+	return exitClean
+}
+
+// matchItems return a match of the cache keys of items, via any method, or of every key if none
+func matchItems(items []string) (func(key string) bool, error) {
+
+	suffixes := make([]string, len(items))
+
+	for i, item := range items {
+
+		parsed, err := zetascan.ParseItem(item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		suffixes[i] = "/" + parsed.String()
+	}
+
+	return func(key string) bool {
+
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(key, suffix) {
+				return true
+			}
+		}
+
+		return len(suffixes) == 0
+	}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// checkpoint records the items completed by a batch, one per line, so an
+// interrupted run can be resumed. Items that failed are not recorded.
+type checkpoint struct {
+	f    *os.File
+	done map[string]bool
+}
+
+// openCheckpoint loads the items completed by a previous run, appending to the file
+func openCheckpoint(path string) (*checkpoint, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &checkpoint{f: f, done: make(map[string]bool)}
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		if item := strings.TrimSpace(scanner.Text()); item != "" {
+			cp.done[item] = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// remaining return the items not yet completed
+func (cp *checkpoint) remaining(items []string) (todo []string) {
+
+	for _, item := range items {
+		if !cp.done[item] {
+			todo = append(todo, item)
+		}
+	}
+
+	return todo
+}
+
+// complete records an item as done
+func (cp *checkpoint) complete(item string) error {
+
+	cp.done[item] = true
+
+	_, err := cp.f.WriteString(item + "\n")
+	return err
+}
+
+func (cp *checkpoint) Close() error {
+	return cp.f.Close()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/config"
+)
+
+// runConfig shows the configuration the other commands would use
+func runConfig(args []string) int {
+
+	fs := newFlagSet("config", "",
+		"Show the effective configuration for the given flags, environment and config file.\n"+
+			"Precedence is flag > environment > profile > config file. The API key is never shown.")
+
+	f := addApiFlags(fs, "json")
+
+	if status, ok := parse(fs, args); !ok {
+		return status
+	}
+
+	file, err := config.LoadFile(f.config)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	settings, err := f.settings()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	myzetascan, closer, err := settings.Api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	path := f.config
+	if path == "" {
+		path = os.Getenv(config.EnvConfig)
+	}
+	if path == "" {
+		path = config.DefaultPath()
+	}
+
+	profile := f.profile
+	if profile == "" {
+		profile = os.Getenv(config.EnvProfile)
+	}
+	if profile == "" {
+		profile = file.Profile
+	}
+
+	auth := "ip"
+	if settings.KeyFile != "" {
+		auth = "key file " + settings.KeyFile
+	} else if settings.SecondaryKey != "" {
+		auth = "api key, secondary key"
+	} else if settings.APIKey != "" {
+		auth = "api key"
+	}
+
+	if auth != "ip" && myzetascan.HeaderAuth {
+		auth += " (" + zetascan.KeyHeader + " header)"
+	}
+
+	// A redis:// URL may hold a password
+	cache := settings.Cache
+	if u, err := url.Parse(cache); err == nil && config.IsRedisURL(cache) {
+		cache = u.Redacted()
+	}
+
+	if cache != "" {
+
+		ttl := myzetascan.CacheTTL
+		if ttl <= 0 {
+			ttl = zetascan.DefaultCacheTTL
+		}
+
+		cache += fmt.Sprintf(" (ttl %s, stale %s)", ttl, myzetascan.CacheStale)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "config file\t%s\n", path)
+	fmt.Fprintf(tw, "profile\t%s\n", profile)
+	fmt.Fprintf(tw, "profiles\t%s\n", strings.Join(file.ProfileNames(), ", "))
+	fmt.Fprintf(tw, "auth\t%s\n", auth)
+	fmt.Fprintf(tw, "endpoint\t%s\n", myzetascan.GetEndpoint())
+	fmt.Fprintf(tw, "fallback\t%s\n", strings.Join(settings.Fallback, ", "))
+	fmt.Fprintf(tw, "format\t%s\n", strings.Join(append([]string{myzetascan.ApiMethod}, myzetascan.MethodFallback...), ", then "))
+	fmt.Fprintf(tw, "enrich\t%t\n", myzetascan.EnrichOnHit)
+	fmt.Fprintf(tw, "dns server\t%s\n", myzetascan.DnsServer)
+	fmt.Fprintf(tw, "cache\t%s\n", cache)
+
+	for _, score := range []struct {
+		name  string
+		value *float64
+	}{
+		{"reject", settings.RejectScore},
+		{"defer", settings.DeferScore},
+		{"quarantine", settings.QuarantineScore},
+	} {
+		if score.value != nil {
+			fmt.Fprintf(tw, "%s\t%g\n", score.name, *score.value)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fail(err)
+	}
+
+	return exitClean
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/config"
+	"github.com/zetascan/go-zetascan/zetascan/diskcache"
+)
+
+// newFlagSet return the flags of a command, with help text describing its arguments
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: zetascan-query %s [flags] %s\n\n%s\n\nFlags:\n", name, arguments, description)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parse the flags of a command, return false with the exit status if the command should stop
+func parse(fs *flag.FlagSet, args []string) (int, bool) {
+
+	err := fs.Parse(args)
+
+	if err == flag.ErrHelp {
+		return exitClean, false
+	}
+
+	if err != nil {
+		return exitError, false
+	}
+
+	return exitClean, true
+}
+
+// isSet return if a flag was given on the command line
+func isSet(fs *flag.FlagSet, name string) (set bool) {
+
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+
+	return set
+}
+
+// apiFlags are the authentication and end-point flags shared by every command,
+// overriding the config file and environment
+type apiFlags struct {
+	fs            *flag.FlagSet
+	defaultMethod string
+
+	config    string
+	profile   string
+	apiKey    string
+	keyFile   string
+	ipAuth    bool
+	header    bool
+	method    string
+	endpoint  string
+	fallback  string
+	dnsServer string
+	cache     string
+	cacheTTL  time.Duration
+	stale     time.Duration
+	verbose   bool
+	enrich    bool
+}
+
+// addApiFlags registers the shared flags, and -format if method is not empty
+func addApiFlags(fs *flag.FlagSet, method string) *apiFlags {
+
+	f := &apiFlags{fs: fs, defaultMethod: method}
+
+	fs.StringVar(&f.config, "config", "", "Config file (default $"+config.EnvConfig+" or "+config.DefaultPath()+")")
+	fs.StringVar(&f.profile, "profile", "", "Config profile, e.g prod or staging (default $"+config.EnvProfile+" or the file's profile)")
+	fs.StringVar(&f.apiKey, "apikey", "", "Specify API key, preferably via $"+config.EnvAPIKey+" or the config file")
+	fs.StringVar(&f.keyFile, "key-file", "", "File of API keys, primary first, watched for rotation (see zetascan.FileKeys)")
+	fs.BoolVar(&f.ipAuth, "ipauth", false, "Toggle to bypass API key and use IP authentication")
+	fs.BoolVar(&f.header, "header-auth", false, "Send the API key in the "+zetascan.KeyHeader+" header rather than the URL, if the end-point supports it")
+	fs.StringVar(&f.endpoint, "endpoint", "", "Query another end-point, host[:port] or URL, e.g on-prem or the emulator")
+	fs.StringVar(&f.fallback, "fallback", "", "Comma separated end-points to fail over to")
+	fs.StringVar(&f.dnsServer, "dns-server", "", "Nameserver for dns queries, host:port")
+	fs.StringVar(&f.cache, "cache", "", "Cache results in this file, shared with other runs (e.g "+diskcache.DefaultPath()+"), or a redis:// URL")
+	fs.DurationVar(&f.cacheTTL, "cache-ttl", 0, "How long results are cached (default "+zetascan.DefaultCacheTTL.String()+")")
+	fs.DurationVar(&f.stale, "cache-stale", 0, "Serve expired results this much longer, while refreshing them in the background")
+	fs.BoolVar(&f.verbose, "verbose", false, "Log requests, retries, failovers and DNS answers to stderr")
+
+	if method != "" {
+		fs.StringVar(&f.method, "format", "", "Specify the query format (text, http, json, jsonx, dns), or a comma separated fallback chain, e.g dns,jsonx (default \""+method+"\")")
+		fs.BoolVar(&f.enrich, "enrich", false, "Look up listed items via jsonx for the extended data")
+	}
+
+	return f
+}
+
+// settings return the config file and environment settings, overridden by the flags
+func (f *apiFlags) settings() (config.Settings, error) {
+
+	s, err := config.Load(f.config, f.profile)
+
+	if err != nil {
+		return s, err
+	}
+
+	flags := config.Settings{
+		APIKey:     zetascan.Secret(f.apiKey),
+		KeyFile:    f.keyFile,
+		Endpoint:   f.endpoint,
+		Fallback:   splitList(f.fallback),
+		Method:     f.method,
+		DNSServer:  f.dnsServer,
+		Cache:      f.cache,
+		CacheTTL:   f.cacheTTL,
+		CacheStale: f.stale,
+	}
+
+	if isSet(f.fs, "ipauth") {
+		flags.IPAuth = &f.ipAuth
+	}
+
+	if isSet(f.fs, "header-auth") {
+		flags.HeaderAuth = &f.header
+	}
+
+	if isSet(f.fs, "enrich") {
+		flags.Enrich = &f.enrich
+	}
+
+	s = s.Merge(flags)
+
+	if s.Method == "" {
+		s.Method = f.defaultMethod
+	}
+
+	return s, nil
+}
+
+// api return an Api configured from the flags, environment and config file,
+// and the key file and cache to close when done with it
+func (f *apiFlags) api() (zetascan.Api, io.Closer, error) {
+
+	s, err := f.settings()
+
+	if err != nil {
+		return zetascan.Api{}, nil, err
+	}
+
+	myapi, closer, err := s.Api()
+	myapi.Logger = f.logger()
+
+	return myapi, closer, err
+}
+
+// logger return a debug logger to stderr if -verbose is set, otherwise nil
+func (f *apiFlags) logger() *slog.Logger {
+
+	if !f.verbose {
+		return nil
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// checkMethods return an error if any of the query methods is unknown
+func checkMethods(methods ...string) error {
+
+	for _, method := range methods {
+
+		known := false
+		for _, m := range zetascan.Methods {
+			known = known || m == method
+		}
+
+		if !known {
+			return errors.New("Unknown query format: " + method)
+		}
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated flag, ignoring empty entries
+func splitList(s string) (list []string) {
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// fail reports an error, return the error exit status
+func fail(err error) int {
+
+	fmt.Fprintln(os.Stderr, "zetascan-query:", err)
+
+	return exitError
+}
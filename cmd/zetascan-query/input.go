@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// openInput opens a file of items, or stdin for "-"
+func openInput(path string) (io.ReadCloser, error) {
+
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	return os.Open(path)
+}
+
+// readItems reads one item per line, skipping blank lines and # comments
+func readItems(r io.Reader) (items []string, err error) {
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+
+		item := strings.TrimSpace(scanner.Text())
+
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, scanner.Err()
+}
+
+// readColumn reads the items in a CSV column, skipping blank fields and # comments.
+// The column is a 1-based index, or the name of a column in the header row.
+func readColumn(r io.Reader, column string) (items []string, err error) {
+
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	index, err := strconv.Atoi(column)
+
+	if err == nil && index < 1 {
+		return nil, errors.New("CSV column numbers start at 1")
+	}
+
+	index--
+
+	// A named column is looked up in the header row
+	if err != nil {
+
+		header, err := cr.Read()
+
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		index = -1
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				index = i
+				break
+			}
+		}
+
+		if index < 0 {
+			return nil, errors.New("CSV column not found in header: " + column)
+		}
+	}
+
+	for {
+		record, err := cr.Read()
+
+		if err == io.EOF {
+			return items, nil
+		}
+
+		if err != nil {
+			return items, err
+		}
+
+		if index >= len(record) {
+			continue
+		}
+
+		if item := strings.TrimSpace(record[index]); item != "" {
+			items = append(items, item)
+		}
+	}
+}
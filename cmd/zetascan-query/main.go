@@ -0,0 +1,123 @@
+// Command zetascan-query queries zetascan from the command line, and runs the
+// diagnostics, comparisons and mail integrations built on the zetascan package.
+//
+//	zetascan-query <command> [flags] [arguments]
+//
+// The exit status is 0 when no item is listed (or all checks pass), 1 when an
+// item is listed and 2 on any error.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/zetascan/go-zetascan/zetascan/config"
+	"github.com/zetascan/go-zetascan/zetascan/diskcache"
+	"github.com/zetascan/go-zetascan/zetascan/rediscache"
+)
+
+// Exit codes shared by every command
+const (
+	exitClean  = 0 // Nothing listed, or all checks passed
+	exitListed = 1 // An item is blacklisted
+	exitError  = 2 // A lookup, check or usage error
+)
+
+// command is a zetascan-query subcommand
+type command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+
+	// Result cache backends for -cache and the config file's cache setting
+	config.RegisterCache("", diskcache.OpenDefault)
+	config.RegisterCache("redis", rediscache.OpenDefault)
+	config.RegisterCache("rediss", rediscache.OpenDefault)
+
+	// Assigned in init, as the help command refers to the table
+	commands = []command{
+		{"query", "Query domains, IPs, URLs or email addresses", runQuery},
+		{"batch", "Query many items concurrently, from arguments or stdin", runBatch},
+		{"verify", "Check the documented test items via each method", runVerify},
+		{"compare", "Compare results for the same items between methods", runCompare},
+		{"bench", "Measure throughput and latency across methods and end-points", runBench},
+		{"serve", "Run the Postfix policy or milter server", runServe},
+		{"cache", "Inspect, export, purge, compact or warm the result cache", runCache},
+		{"config", "Show the effective configuration", runConfig},
+		{"help", "Show help for a command", runHelp},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to a command, returning the exit status
+func run(args []string) int {
+
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitError
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitClean
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name == args[0] {
+			return cmd.Run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "zetascan-query: unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+
+	return exitError
+}
+
+// usage lists the commands
+func usage(w io.Writer) {
+
+	fmt.Fprintln(w, "Usage: zetascan-query <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.Name, cmd.Summary)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'zetascan-query help <command>' for the flags of a command.")
+	fmt.Fprintln(w, "Exit status: 0 clean, 1 listed, 2 error.")
+}
+
+// runHelp shows the flags of a command
+func runHelp(args []string) int {
+
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return exitClean
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name == args[0] && cmd.Name != "help" {
+			return cmd.Run([]string{"-h"})
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "zetascan-query: unknown command %q\n", args[0])
+
+	return exitError
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Output is the result of one item, as written by every -output format and
+// passed to -output templates, e.g template={{.Query}} {{.Verdict}} {{.Result.Score}}
+type Output struct {
+	Query   string `json:"query"`
+	Verdict string `json:"verdict"` // listed, whitelisted, not listed or error
+	Error   string `json:"error,omitempty"`
+
+	zetascan.JsonRecord
+}
+
+// newOutput return the output for the result of a query
+func newOutput(myzetascan zetascan.Api, query string, m zetascan.JsonRecord, err error) (o Output) {
+
+	o = Output{Query: query, JsonRecord: m}
+
+	switch {
+	case err != nil:
+		o.Verdict = "error"
+		o.Error = err.Error()
+	case len(m.Results) == 0:
+		o.Verdict = "not listed"
+	case myzetascan.IsBlackList(&m):
+		o.Verdict = "listed"
+	case myzetascan.IsWhiteList(&m):
+		o.Verdict = "whitelisted"
+	default:
+		o.Verdict = "not listed"
+	}
+
+	return o
+}
+
+// Result return the first result, empty if none
+func (o Output) Result() (result zetascan.JsonResult) {
+
+	if len(o.Results) > 0 {
+		result = o.Results[0]
+	}
+
+	return result
+}
+
+// exitStatus return the exit status for the item
+func (o Output) exitStatus() int {
+
+	switch o.Verdict {
+	case "error":
+		return exitError
+	case "listed":
+		return exitListed
+	}
+
+	return exitClean
+}
+
+// column is a field of the csv, tsv and table output, named after its JSON path
+type column struct {
+	Name  string
+	Value func(o Output) string
+}
+
+// columns are the stable column names for the csv, tsv and table output
+var columns = []column{
+	{"query", func(o Output) string { return o.Query }},
+	{"verdict", func(o Output) string { return o.Verdict }},
+	{"item", func(o Output) string { return o.Result().Item }},
+	{"found", func(o Output) string { return strconv.FormatBool(o.Result().Found) }},
+	{"wl", func(o Output) string { return strconv.FormatBool(o.Result().Wl) }},
+	{"score", func(o Output) string { return formatFloat(o.Result().Score) }},
+	{"webscore", func(o Output) string { return formatFloat(o.Result().WebScore) }},
+	{"fromSubnet", func(o Output) string { return strconv.FormatBool(o.Result().FromSubnet) }},
+	{"sources", func(o Output) string { return strings.Join(o.Result().Sources, ";") }},
+	{"wldata", func(o Output) string { return o.Result().Wldata }},
+	{"matched", func(o Output) string { return o.Result().Matched }},
+	{"extended.ASNum", func(o Output) string { return o.Result().Extended.ASNum }},
+	{"extended.route", func(o Output) string { return o.Result().Extended.Route }},
+	{"extended.country", func(o Output) string { return o.Result().Extended.Country }},
+	{"extended.domain", func(o Output) string { return o.Result().Extended.Domain }},
+	{"extended.state", func(o Output) string { return o.Result().Extended.State }},
+	{"extended.time", func(o Output) string { return o.Result().Extended.Time }},
+	{"extended.reason.class", func(o Output) string { return o.Result().Extended.Reason.Class }},
+	{"extended.reason.rule", func(o Output) string { return o.Result().Extended.Reason.Rule }},
+	{"extended.reason.type", func(o Output) string { return o.Result().Extended.Reason.Type }},
+	{"extended.reason.name", func(o Output) string { return o.Result().Extended.Reason.Name }},
+	{"extended.reason.source", func(o Output) string { return o.Result().Extended.Reason.Source }},
+	{"extended.reason.port", func(o Output) string { return o.Result().Extended.Reason.Port }},
+	{"extended.reason.sourceport", func(o Output) string { return o.Result().Extended.Reason.SourcePort }},
+	{"extended.reason.destination", func(o Output) string { return o.Result().Extended.Reason.Destination }},
+	{"status", func(o Output) string { return o.Status }},
+	{"executionTime", func(o Output) string { return strconv.FormatInt(o.ExecutionTime, 10) }},
+	{"error", func(o Output) string { return o.Error }},
+}
+
+// tableColumns are the default columns of the table output, which is read by people
+var tableColumns = "query,verdict,score,webscore,sources,matched,error"
+
+// outputFlags are the -output and -columns flags of the query commands
+type outputFlags struct {
+	format  string
+	columns string
+
+	// Omit the header row, when appending to the output of a previous run
+	noHeader bool
+}
+
+func addOutputFlags(fs *flag.FlagSet) *outputFlags {
+
+	f := &outputFlags{}
+
+	fs.StringVar(&f.format, "output", "text", "Output format: text, json, ndjson, csv, tsv, table or template=<Go template>")
+	fs.StringVar(&f.columns, "columns", "", "Comma separated columns for csv, tsv and table output (default all, or "+tableColumns+" for table)")
+
+	return f
+}
+
+// outputWriter writes the result of each item in an output format
+type outputWriter interface {
+	Write(o Output) error
+	Close() error
+}
+
+// writer return an outputWriter for the flags, writing to w
+func (f *outputFlags) writer(w io.Writer) (outputWriter, error) {
+
+	if text, ok := strings.CutPrefix(f.format, "template="); ok {
+
+		tmpl, err := template.New("output").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &templateWriter{w: w, tmpl: tmpl, newline: !strings.HasSuffix(text, "\n")}, nil
+	}
+
+	names := f.columns
+	if names == "" && f.format == "table" {
+		names = tableColumns
+	}
+
+	cols, err := selectColumns(names)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch f.format {
+	case "text":
+		return &textWriter{w: w}, nil
+	case "json":
+		return &jsonWriter{w: w}, nil
+	case "ndjson":
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return newCSVWriter(w, cols, !f.noHeader)
+	case "tsv":
+		return newTSVWriter(w, cols, !f.noHeader)
+	case "table":
+		return newTableWriter(w, cols, !f.noHeader)
+	}
+
+	return nil, errors.New("Unknown output format: " + f.format)
+}
+
+// selectColumns return the named columns, or all columns if none named
+func selectColumns(names string) (cols []column, err error) {
+
+	if names == "" {
+		return columns, nil
+	}
+
+	for _, name := range splitList(names) {
+
+		found := false
+
+		for _, col := range columns {
+			if col.Name == name {
+				cols = append(cols, col)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, errors.New("Unknown output column: " + name)
+		}
+	}
+
+	return cols, nil
+}
+
+// textWriter writes a line summarising each item, errors to stderr
+type textWriter struct {
+	w io.Writer
+}
+
+func (tw *textWriter) Write(o Output) error {
+
+	if o.Error != "" {
+		_, err := fmt.Fprintf(os.Stderr, "zetascan-query: %s: %s\n", o.Query, o.Error)
+		return err
+	}
+
+	if o.Verdict != "listed" {
+		_, err := fmt.Fprintf(tw.w, "%s: %s\n", o.Query, o.Verdict)
+		return err
+	}
+
+	result := o.Result()
+	line := fmt.Sprintf("%s: listed score=%g webscore=%g", o.Query, result.Score, result.WebScore)
+
+	if len(result.Sources) > 0 {
+		line += " sources=" + strings.Join(result.Sources, ",")
+	}
+
+	if result.Matched != "" && result.Matched != o.Query {
+		line += " matched=" + result.Matched
+	}
+
+	_, err := fmt.Fprintln(tw.w, line)
+	return err
+}
+
+func (tw *textWriter) Close() error {
+	return nil
+}
+
+// jsonWriter writes a JSON array of items, completed on Close
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (jw *jsonWriter) Write(o Output) error {
+
+	data, err := json.MarshalIndent(o, "  ", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	sep := ",\n  "
+	if jw.count == 0 {
+		sep = "[\n  "
+	}
+	jw.count++
+
+	_, err = io.WriteString(jw.w, sep+string(data))
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+
+	end := "\n]\n"
+	if jw.count == 0 {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(jw.w, end)
+	return err
+}
+
+// ndjsonWriter writes a JSON object per line
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) Write(o Output) error {
+	return nw.enc.Encode(o)
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+// csvWriter writes RFC 4180 CSV with a header row
+type csvWriter struct {
+	w    *csv.Writer
+	cols []column
+}
+
+func newCSVWriter(w io.Writer, cols []column, header bool) (*csvWriter, error) {
+
+	cw := &csvWriter{w: csv.NewWriter(w), cols: cols}
+
+	if !header {
+		return cw, nil
+	}
+
+	return cw, cw.w.Write(columnNames(cols))
+}
+
+func (cw *csvWriter) Write(o Output) error {
+
+	if err := cw.w.Write(columnValues(cw.cols, o)); err != nil {
+		return err
+	}
+
+	// Flush each row, so results are visible as they are written
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) Close() error {
+
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// tsvWriter writes tab separated values with a header row, replacing any tabs
+// and newlines within values with spaces
+type tsvWriter struct {
+	w    io.Writer
+	cols []column
+}
+
+func newTSVWriter(w io.Writer, cols []column, header bool) (*tsvWriter, error) {
+
+	tw := &tsvWriter{w: w, cols: cols}
+
+	if !header {
+		return tw, nil
+	}
+
+	return tw, tw.writeRow(columnNames(cols))
+}
+
+func (tw *tsvWriter) Write(o Output) error {
+	return tw.writeRow(columnValues(tw.cols, o))
+}
+
+func (tw *tsvWriter) writeRow(values []string) error {
+
+	for i, v := range values {
+		values[i] = tsvEscaper.Replace(v)
+	}
+
+	_, err := io.WriteString(tw.w, strings.Join(values, "\t")+"\n")
+	return err
+}
+
+func (tw *tsvWriter) Close() error {
+	return nil
+}
+
+var tsvEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+// tableWriter writes aligned columns, once every row is known
+type tableWriter struct {
+	tw   *tabwriter.Writer
+	cols []column
+}
+
+func newTableWriter(w io.Writer, cols []column, header bool) (*tableWriter, error) {
+
+	tw := &tableWriter{tw: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0), cols: cols}
+
+	if !header {
+		return tw, nil
+	}
+
+	names := columnNames(cols)
+	for i, name := range names {
+		names[i] = strings.ToUpper(name)
+	}
+
+	return tw, tw.writeRow(names)
+}
+
+func (tw *tableWriter) Write(o Output) error {
+	return tw.writeRow(columnValues(tw.cols, o))
+}
+
+func (tw *tableWriter) writeRow(values []string) error {
+
+	for i, v := range values {
+		if v = tsvEscaper.Replace(v); v == "" {
+			v = "-"
+		}
+		values[i] = v
+	}
+
+	_, err := io.WriteString(tw.tw, strings.Join(values, "\t")+"\n")
+	return err
+}
+
+func (tw *tableWriter) Close() error {
+	return tw.tw.Flush()
+}
+
+// templateWriter executes a Go template for each item
+type templateWriter struct {
+	w       io.Writer
+	tmpl    *template.Template
+	newline bool
+}
+
+func (tw *templateWriter) Write(o Output) error {
+
+	if err := tw.tmpl.Execute(tw.w, o); err != nil {
+		return err
+	}
+
+	if tw.newline {
+		_, err := io.WriteString(tw.w, "\n")
+		return err
+	}
+
+	return nil
+}
+
+func (tw *templateWriter) Close() error {
+	return nil
+}
+
+func columnNames(cols []column) (names []string) {
+
+	for _, col := range cols {
+		names = append(names, col.Name)
+	}
+
+	return names
+}
+
+func columnValues(cols []column, o Output) (values []string) {
+
+	for _, col := range cols {
+		values = append(values, col.Value(o))
+	}
+
+	return values
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// runQuery queries each item in turn
+func runQuery(args []string) int {
+
+	fs := newFlagSet("query", "item...",
+		"Query each domain, IP, URL or email address, printing a line per item.\n"+
+			"The exit status is 1 if any item is blacklisted.")
+
+	f := addApiFlags(fs, "json")
+	domain := fs.Bool("domain", false, "Query the hostname and its registered domain, for domain items")
+	parents := fs.Bool("parents", false, "With -domain, also query every parent domain")
+	timeout := fs.Duration("timeout", 30*time.Second, "Maximum time for each item, including the labels of -domain")
+	output := addOutputFlags(fs)
+
+	if status, ok := parse(fs, args); !ok {
+		return status
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitError
+	}
+
+	myzetascan, closer, err := f.api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	w, err := output.writer(os.Stdout)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	status := exitClean
+
+	for _, item := range fs.Args() {
+
+		var m zetascan.JsonRecord
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+
+		if *domain {
+			m, err = myzetascan.QueryDomainContext(ctx, item, *parents)
+		} else {
+			m, err = myzetascan.QueryContext(ctx, item)
+		}
+
+		cancel()
+
+		o := newOutput(myzetascan, item, m, err)
+
+		if err := w.Write(o); err != nil {
+			return fail(err)
+		}
+
+		status = max(status, o.exitStatus())
+	}
+
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+
+	return status
+}
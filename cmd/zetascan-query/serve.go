@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan/milter"
+	"github.com/zetascan/go-zetascan/zetascan/policyd"
+	"github.com/zetascan/go-zetascan/zetascan/prommetrics"
+)
+
+// server is implemented by the policy and milter servers
+type server interface {
+	ListenAndServe(network, address string) error
+	Shutdown(ctx context.Context) error
+}
+
+// Default listen addresses for each server
+var listenAddrs = map[string]string{
+	"policy": "tcp:127.0.0.1:10040",
+	"milter": "tcp:127.0.0.1:8891",
+}
+
+// runServe runs a mail server integration until interrupted
+func runServe(args []string) int {
+
+	fs := newFlagSet("serve", "policy|milter",
+		"Run the Postfix policy delegation server (policy) or the milter server (milter).\n"+
+			"Both stop cleanly on SIGINT or SIGTERM.")
+
+	f := addApiFlags(fs, "json")
+	listen := fs.String("listen", "", "Listen address, tcp:host:port or unix:/path/to/socket (default tcp:127.0.0.1:10040 for policy, tcp:127.0.0.1:8891 for milter)")
+	reject := fs.Float64("reject", 0.9, "Reject at or above this score (0 to disable)")
+	deferScore := fs.Float64("defer", policyd.DefaultConfig.DeferScore, "policy: DEFER_IF_PERMIT at or above this score (0 to disable)")
+	quarantine := fs.Float64("quarantine", milter.DefaultConfig.QuarantineScore, "milter: quarantine at or above this score (0 to disable)")
+	timeout := fs.Duration("timeout", 10*time.Second, "Maximum time for the lookups of a request or message stage")
+	metrics := fs.String("metrics", "", "Serve Prometheus metrics on this address at /metrics, e.g 127.0.0.1:9140")
+
+	if status, ok := parse(fs, args); !ok {
+		return status
+	}
+
+	if fs.NArg() != 1 || listenAddrs[fs.Arg(0)] == "" {
+		fs.Usage()
+		return exitError
+	}
+
+	settings, err := f.settings()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	myzetascan, closer, err := settings.Api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	myzetascan.Logger = f.logger()
+
+	// Thresholds not given as flags are taken from the config
+	for name, score := range map[string]struct {
+		flag    *float64
+		setting *float64
+	}{
+		"reject":     {reject, settings.RejectScore},
+		"defer":      {deferScore, settings.DeferScore},
+		"quarantine": {quarantine, settings.QuarantineScore},
+	} {
+		if !isSet(fs, name) && score.setting != nil {
+			*score.flag = *score.setting
+		}
+	}
+
+	if *metrics != "" {
+
+		collector := prommetrics.New()
+		myzetascan.Metrics = collector
+
+		l, err := net.Listen("tcp", *metrics)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", collector.Handler())
+
+		go http.Serve(l, mux)
+	}
+
+	var errorLog func(error)
+
+	if f.verbose {
+		errorLog = func(err error) { log.Println(err) }
+	}
+
+	var srv server
+
+	switch fs.Arg(0) {
+	case "policy":
+		s := policyd.NewServer(myzetascan)
+		s.Config.RejectScore = *reject
+		s.Config.DeferScore = *deferScore
+		s.Config.Timeout = *timeout
+		s.ErrorLog = errorLog
+		srv = s
+
+	case "milter":
+		s := milter.NewServer(myzetascan)
+		s.Config.RejectScore = *reject
+		s.Config.QuarantineScore = *quarantine
+		s.Config.Timeout = *timeout
+		s.ErrorLog = errorLog
+		srv = s
+	}
+
+	if *listen == "" {
+		*listen = listenAddrs[fs.Arg(0)]
+	}
+
+	network, address, ok := strings.Cut(*listen, ":")
+
+	if !ok || (network != "tcp" && network != "unix") {
+		return fail(errors.New("Invalid -listen address, expected tcp:host:port or unix:/path"))
+	}
+
+	// Remove a stale socket from a previous run
+	if network == "unix" {
+		if err := policyd.RemoveSocket(address); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Shutdown cleanly on SIGINT/SIGTERM, answering in-flight requests
+	stopped := make(chan struct{})
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+
+		close(stopped)
+	}()
+
+	fmt.Fprintln(os.Stderr, "zetascan-query: serving", fs.Arg(0), "on", *listen)
+
+	if err := srv.ListenAndServe(network, address); err != nil {
+		return fail(err)
+	}
+
+	<-stopped
+
+	return exitClean
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// runVerify runs the diagnostics against the documented test items
+func runVerify(args []string) int {
+
+	fs := newFlagSet("verify", "",
+		"Verify authentication and queries, running the documented test items via each method.\n"+
+			"The exit status is 2 unless every check passes.")
+
+	f := addApiFlags(fs, "")
+	methods := fs.String("format", "", "Comma separated query formats to check (default all)")
+	report := fs.String("report", "text", "Report format (text, json, junit)")
+	count := fs.Int("count", 1, "Number of times to run the checks")
+
+	if status, ok := parse(fs, args); !ok {
+		return status
+	}
+
+	myzetascan, closer, err := f.api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	list := splitList(*methods)
+
+	if err := checkMethods(list...); err != nil {
+		return fail(err)
+	}
+
+	status := exitClean
+
+	for cnt := 0; cnt < *count; cnt++ {
+
+		diag, err := myzetascan.Diagnose(context.Background(), list...)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		switch *report {
+		case "text":
+			err = diag.WriteText(os.Stdout)
+		case "json":
+			err = diag.WriteJSON(os.Stdout)
+		case "junit":
+			err = diag.WriteJUnit(os.Stdout)
+		default:
+			err = errors.New("Unknown report format: " + *report)
+		}
+
+		if err != nil {
+			return fail(err)
+		}
+
+		if diag.Status != zetascan.Healthy {
+			status = exitError
+		}
+	}
+
+	return status
+}
+
+// runCompare diffs the results of the same items between methods
+func runCompare(args []string) int {
+
+	fs := newFlagSet("compare", "[item...]",
+		"Query each item (default the documented test items) via every method and diff the results.\n"+
+			"The exit status is 1 if any item disagrees between methods.")
+
+	f := addApiFlags(fs, "")
+	methods := fs.String("format", "", "Comma separated query formats to compare (default all)")
+	jsonOut := fs.Bool("json", false, "Toggle to output in JSON")
+
+	if status, ok := parse(fs, args); !ok {
+		return status
+	}
+
+	myzetascan, closer, err := f.api()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer closer.Close()
+
+	comparison, err := myzetascan.Compare(context.Background(), fs.Args(), splitList(*methods)...)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if *jsonOut {
+		err = comparison.WriteJSON(os.Stdout)
+	} else {
+		err = comparison.WriteText(os.Stdout)
+	}
+
+	if err != nil {
+		return fail(err)
+	}
+
+	if comparison.Disagreements() > 0 {
+		return exitListed
+	}
+
+	return exitClean
+}
@@ -0,0 +1,295 @@
+// Command zetascan-server serves zetascan lookups to other services, over gRPC
+// and as JSON over HTTP, with the policy decision for each result. It uses the
+// same config file, cache and failover as zetascan-query.
+//
+//	ZETASCAN_SERVER_TOKENS=YOURTOKEN zetascan-server -grpc 127.0.0.1:9090 -http 127.0.0.1:8080
+//
+//	curl -H "Authorization: Bearer YOURTOKEN" http://127.0.0.1:8080/v1/check/example.com
+//
+// The gRPC service is defined in zetascan/server/serverpb/zetascan.proto.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/config"
+	"github.com/zetascan/go-zetascan/zetascan/diskcache"
+	"github.com/zetascan/go-zetascan/zetascan/prommetrics"
+	"github.com/zetascan/go-zetascan/zetascan/rediscache"
+	"github.com/zetascan/go-zetascan/zetascan/server"
+	"github.com/zetascan/go-zetascan/zetascan/server/serverpb"
+)
+
+// EnvTokens are the comma separated client tokens, if there's no -token-file
+const EnvTokens = "ZETASCAN_SERVER_TOKENS"
+
+// Actions for -fail
+var failActions = map[string]serverpb.Action{
+	"allow":      serverpb.Action_ACTION_ALLOW,
+	"quarantine": serverpb.Action_ACTION_QUARANTINE,
+	"defer":      serverpb.Action_ACTION_DEFER,
+	"reject":     serverpb.Action_ACTION_REJECT,
+}
+
+// Result cache backends for -cache and the config file's cache setting
+func init() {
+	config.RegisterCache("", diskcache.OpenDefault)
+	config.RegisterCache("redis", rediscache.OpenDefault)
+	config.RegisterCache("rediss", rediscache.OpenDefault)
+}
+
+func main() {
+
+	configPath := flag.String("config", "", "Config file (default $"+config.EnvConfig+" or "+config.DefaultPath()+")")
+	profile := flag.String("profile", "", "Config profile, e.g prod or staging (default $"+config.EnvProfile+" or the file's profile)")
+	apiKey := flag.String("apikey", "", "Specify API key, preferably via $"+config.EnvAPIKey+" or the config file")
+	ipAuth := flag.Bool("ipauth", false, "Toggle to bypass API key and use IP authentication")
+	format := flag.String("format", "", "Specify the default query format (text, http, json, jsonx, dns), or a comma separated fallback chain (default json)")
+	endpoint := flag.String("endpoint", "", "Query another end-point, host[:port] or URL, e.g on-prem or the emulator")
+	cache := flag.String("cache", "", "Cache results in this file, or a redis:// URL to share them between instances (default in memory)")
+
+	grpcAddr := flag.String("grpc", "127.0.0.1:9090", "Serve gRPC on this address, empty to disable")
+	httpAddr := flag.String("http", "127.0.0.1:8080", "Serve the JSON gateway and health probes on this address, empty to disable")
+	tlsCert := flag.String("tls-cert", "", "Serve gRPC and HTTP over TLS with this certificate file")
+	tlsKey := flag.String("tls-key", "", "The key file of -tls-cert")
+	tokenFile := flag.String("token-file", "", "File of client tokens, one per line (default $"+EnvTokens+", comma separated)")
+	noAuth := flag.Bool("insecure-no-auth", false, "Allow any client without a token, when there's no -token-file or $"+EnvTokens)
+
+	reject := flag.Float64("reject", server.DefaultConfig.RejectScore, "Reject at or above this score (0 to disable)")
+	deferScore := flag.Float64("defer", server.DefaultConfig.DeferScore, "Defer at or above this score (0 to disable)")
+	quarantine := flag.Float64("quarantine", server.DefaultConfig.QuarantineScore, "Quarantine at or above this score (0 to disable)")
+	fail := flag.String("fail", "allow", "Action when a lookup fails (allow, quarantine, defer, reject)")
+	timeout := flag.Duration("timeout", server.DefaultConfig.Timeout, "Maximum time for a lookup")
+	maxBatch := flag.Int("max-batch", server.DefaultConfig.MaxBatch, "Maximum items per batch")
+	concurrency := flag.Int("concurrency", server.DefaultConfig.Concurrency, "Parallel lookups per batch or stream")
+	probe := flag.String("probe", server.DefaultConfig.ProbeItem, "Item looked up for the readiness probe every "+server.DefaultConfig.ProbeInterval.String()+", bypassing the cache so each probe is a query of the API, empty to not probe")
+
+	verbose := flag.Bool("verbose", false, "Log failed lookups, requests, retries and DNS answers")
+	metrics := flag.String("metrics", "", "Serve Prometheus metrics on this address at /metrics, e.g 127.0.0.1:9140")
+
+	flag.Parse()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	settings, err := config.Load(*configPath, *profile)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flags := config.Settings{
+		APIKey:   zetascan.Secret(*apiKey),
+		Method:   *format,
+		Endpoint: *endpoint,
+		Cache:    *cache,
+	}
+
+	if set["ipauth"] {
+		flags.IPAuth = ipAuth
+	}
+
+	settings = settings.Merge(flags)
+
+	myzetascan, closer, err := settings.Api()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer closer.Close()
+
+	logger := slog.New(slog.DiscardHandler)
+
+	if *verbose {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		myzetascan.Logger = logger
+	}
+
+	if *metrics != "" {
+		collector := prommetrics.New()
+		myzetascan.Metrics = collector
+
+		go func() {
+			log.Fatal(collector.ListenAndServe(*metrics))
+		}()
+	}
+
+	// Cache in memory unless the config has a cache
+	clientConfig := zetascan.DefaultClientConfig
+	clientConfig.CacheTTL = zetascan.DefaultCacheTTL
+
+	client := zetascan.NewClient(myzetascan, clientConfig)
+
+	s := server.NewServer(client)
+	s.Logger = logger
+
+	// Thresholds not given as flags are taken from the config
+	for name, score := range map[string]struct {
+		flag    *float64
+		setting *float64
+	}{
+		"reject":     {reject, settings.RejectScore},
+		"defer":      {deferScore, settings.DeferScore},
+		"quarantine": {quarantine, settings.QuarantineScore},
+	} {
+		if !set[name] && score.setting != nil {
+			*score.flag = *score.setting
+		}
+	}
+
+	s.Config.RejectScore = *reject
+	s.Config.DeferScore = *deferScore
+	s.Config.QuarantineScore = *quarantine
+	s.Config.Timeout = *timeout
+	s.Config.MaxBatch = *maxBatch
+	s.Config.Concurrency = *concurrency
+	s.Config.ProbeItem = *probe
+
+	action, ok := failActions[strings.ToLower(*fail)]
+
+	if !ok {
+		log.Fatal("Unknown -fail action: ", *fail)
+	}
+
+	s.Config.FailAction = action
+
+	if s.Config.Tokens, err = readTokens(*tokenFile); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(s.Config.Tokens) == 0 && !*noAuth {
+		log.Fatal("No client tokens, set -token-file or $" + EnvTokens + ", or -insecure-no-auth to allow any client")
+	}
+
+	if len(s.Config.Tokens) == 0 {
+		log.Println("zetascan-server: -insecure-no-auth, any client is allowed")
+		s.Config.AllowAnyClient = true
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("Both -tls-cert and -tls-key are needed for TLS")
+	}
+
+	if *grpcAddr == "" && *httpAddr == "" {
+		log.Fatal("Nothing to serve, both -grpc and -http are empty")
+	}
+
+	var grpcOpts []grpc.ServerOption
+
+	if *tlsCert != "" {
+
+		creds, err := credentials.NewServerTLSFromFile(*tlsCert, *tlsKey)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		grpcOpts = append(grpcOpts, grpc.Creds(creds))
+	}
+
+	// Listen before serving, so address errors are reported at once
+	var grpcListener, httpListener net.Listener
+
+	if *grpcAddr != "" {
+		if grpcListener, err = net.Listen("tcp", *grpcAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *httpAddr != "" {
+		if httpListener, err = net.Listen("tcp", *httpAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	errs := make(chan error, 2)
+
+	if grpcListener != nil {
+		log.Println("zetascan-server serving gRPC on", grpcListener.Addr())
+		go func() { errs <- s.ServeGRPC(grpcListener, grpcOpts...) }()
+	}
+
+	if httpListener != nil {
+		log.Println("zetascan-server serving HTTP on", httpListener.Addr())
+		go func() {
+			if *tlsCert != "" {
+				errs <- s.ServeGatewayTLS(httpListener, *tlsCert, *tlsKey)
+			} else {
+				errs <- s.ServeGateway(httpListener)
+			}
+		}()
+	}
+
+	// Shutdown cleanly on SIGINT/SIGTERM, reporting not ready while draining
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+
+	case <-sig:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := s.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+
+	client.Close()
+}
+
+// readTokens return the tokens in a file, or $ZETASCAN_SERVER_TOKENS if path is empty
+func readTokens(path string) (tokens []zetascan.Secret, err error) {
+
+	if path == "" {
+
+		for _, token := range strings.Split(os.Getenv(EnvTokens), ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, zetascan.Secret(token))
+			}
+		}
+
+		return tokens, nil
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, zetascan.Secret(line))
+		}
+	}
+
+	return tokens, scanner.Err()
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/zetascanio/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan"
 )
 
 func main() {
@@ -2,58 +2,54 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
-	"github.com/zetascanio/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/middleware"
 )
 
 func main() {
-	fmt.Println("Launching a test webserver on port 8000")
-	http.HandleFunc("/", hello)
-	http.ListenAndServe(":8000", nil)
-}
-
-func hello(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 	var myzetascan zetascan.Api
 
-	// Query the remote users address, are they blacklisted?
-	query := r.RemoteAddr // "baddomain.org" , use for testing a blacklist hit
-
 	apiKey := ""   // Speciy an IP key
 	ipAuth := true // Auth via the IP address, which must be added via the zetascan developer portal
 
-	// Init with our API key
+	// Init with our API key, once for all requests
 	myzetascan, err = myzetascan.Init(apiKey, ipAuth)
 
 	if err != nil {
-		fmt.Println(err)
+		log.Fatal(err)
 	}
 
-	// Query via the JSON method
+	// Query via the DNS method
 	myzetascan.ApiMethod = "dns"
-	m, _ := myzetascan.Query(query)
 
-	// Find the record score ( not supported via DNS, only DNS txt record)
-	// The minimum score is -0.1, meaning that an item was found in White List only. Score 0 means that the item is not found in our DB, and the maximum score is 1. In general, items with score above 0.35 shall be considered as spam or fraud.
-	//score := myzetascan.Score(&m)
+	// Block blacklisted clients, allow requests through if zetascan is unavailable
+	blocker := middleware.New(myzetascan)
+	blocker.FailOpen = true
 
-	// If whitelist, trust
-	if myzetascan.IsWhiteList(&m) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("200: OK - Whitelist hit, trusted record"))
+	// Trust X-Forwarded-For from a local reverse proxy
+	if err := blocker.TrustProxies("127.0.0.1", "::1"); err != nil {
+		log.Fatal(err)
+	}
 
-	} else if myzetascan.IsBlackList(&m) {
-		// If in a blacklist, throw a 403 error
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("403: Request denied - Blacklist hit!"))
+	fmt.Println("Launching a test webserver on port 8000")
+	log.Fatal(http.ListenAndServe(":8000", blocker.Handler(http.HandlerFunc(hello))))
+}
 
-	} else {
-		// If no match, proceed as normal
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("200: OK - No blacklist/whitelist match found"))
+func hello(w http.ResponseWriter, r *http.Request) {
 
+	verdict, _ := middleware.FromContext(r.Context())
+
+	// If whitelist, trust
+	if verdict.Err == nil && len(verdict.Record.Results) > 0 && verdict.Record.Results[0].Wl {
+		w.Write([]byte("200: OK - Whitelist hit, trusted record"))
+		return
 	}
 
+	// If no match, proceed as normal
+	w.Write([]byte("200: OK - No blacklist/whitelist match found"))
 }
@@ -74,7 +74,7 @@ func main() {
 		} else if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Printf("%s: %s\n", m)
+		fmt.Printf("%+v\n", m)
 	}
 
 	dec = json.NewDecoder(strings.NewReader(jsonStream2))
@@ -87,7 +87,7 @@ func main() {
 		}
 
 		fmt.Println(m)
-		fmt.Printf("%s: %s\n", m)
+		fmt.Printf("%+v\n", m)
 	}
 
 }
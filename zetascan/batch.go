@@ -0,0 +1,155 @@
+package zetascan
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultConcurrency is the number of parallel queries used by QueryBatch
+const DefaultConcurrency = 8
+
+// BatchResult holds the result of one item from QueryBatch
+type BatchResult struct {
+	Item   string
+	Record JsonRecord
+	Err    error
+}
+
+// QueryBatch query many domains/IPs in parallel, returning results in the same
+// order as items. Concurrency limits the parallel queries, DefaultConcurrency if <= 0.
+func (myapi Api) QueryBatch(ctx context.Context, items []string, concurrency int) (results []BatchResult) {
+
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	ctx, span := myapi.startSpan(ctx, SpanBatch)
+	span.SetAttribute(AttrMethod, myapi.ApiMethod)
+	span.SetAttribute(AttrBatchSize, len(items))
+	span.SetAttribute(AttrConcurrency, concurrency)
+	defer span.End()
+
+	results = make([]BatchResult, len(items))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+
+		results[i].Item = item
+
+		// Wait for a free slot, or give up on the remaining items
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *BatchResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result.Record, result.Err = myapi.QueryContext(ctx, result.Item)
+		}(&results[i])
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// BatchOptions limits the queries made by QueryEach
+type BatchOptions struct {
+	Concurrency int     // Parallel queries, DefaultConcurrency if <= 0
+	Rate        float64 // Maximum queries started per second, 0 for no limit
+}
+
+// QueryEach query many domains/IPs in parallel, calling fn with the index and
+// result of each item as it completes. Calls to fn are never concurrent.
+// When ctx is done no more queries are started, and ctx.Err() is returned once
+// the queries in flight complete.
+func (myapi Api) QueryEach(ctx context.Context, items []string, opts BatchOptions, fn func(i int, result BatchResult)) error {
+
+	concurrency := opts.Concurrency
+
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	ctx, span := myapi.startSpan(ctx, SpanBatch)
+	span.SetAttribute(AttrMethod, myapi.ApiMethod)
+	span.SetAttribute(AttrBatchSize, len(items))
+	span.SetAttribute(AttrConcurrency, concurrency)
+	defer span.End()
+
+	var interval time.Duration
+
+	if opts.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+
+	sem := make(chan struct{}, concurrency)
+	next := time.Now()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i, item := range items {
+
+		// Wait for a free slot, or give up on the remaining items
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+
+		// Space out the queries to the rate limit
+		if interval > 0 {
+
+			if wait := time.Until(next); wait > 0 {
+
+				timer := time.NewTimer(wait)
+
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					<-sem
+					wg.Wait()
+					return ctx.Err()
+				}
+			}
+
+			// Don't burst to catch up after waiting on a slot
+			next = maxTime(next, time.Now()).Add(interval)
+		}
+
+		wg.Add(1)
+		go func(i int, item string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			m, err := myapi.QueryContext(ctx, item)
+
+			mu.Lock()
+			defer mu.Unlock()
+			fn(i, BatchResult{Item: item, Record: m, Err: err})
+		}(i, item)
+	}
+
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func maxTime(a, b time.Time) time.Time {
+
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
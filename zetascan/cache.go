@@ -0,0 +1,245 @@
+package zetascan
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long results are cached if Api.CacheTTL is not set
+var DefaultCacheTTL = time.Hour
+
+// CacheEntry is a cached result, with the method that answered it
+type CacheEntry struct {
+	Record  JsonRecord `json:"record"`
+	Method  string     `json:"method"`
+	Stored  time.Time  `json:"stored"`
+	Expires time.Time  `json:"expires"`
+
+	// The expired entry may be served until StaleUntil while it's refreshed,
+	// zero if not (see Api.CacheStale)
+	StaleUntil time.Time `json:"staleUntil,omitempty"`
+}
+
+// Expired return if the entry has expired at a time
+func (e CacheEntry) Expired(now time.Time) bool {
+	return !now.Before(e.Expires)
+}
+
+// Stale return if the entry has expired at a time, but may still be served
+func (e CacheEntry) Stale(now time.Time) bool {
+	return e.Expired(now) && now.Before(e.StaleUntil)
+}
+
+// Retain return when the entry is no longer of use, and may be removed
+func (e CacheEntry) Retain() time.Time {
+
+	if e.StaleUntil.After(e.Expires) {
+		return e.StaleUntil
+	}
+
+	return e.Expires
+}
+
+// Cache stores results by key, the method (and DNS record type) and item.
+// It must be safe for concurrent use. NewMemoryCache keeps results in memory,
+// zetascan/diskcache in a file shared by the processes on a host, and
+// zetascan/rediscache in a Redis-protocol store shared by a fleet.
+type Cache interface {
+	// Get return the entry for a key, even if expired, and if it was found
+	Get(ctx context.Context, key string) (CacheEntry, bool, error)
+
+	// Set stores the entry for a key
+	Set(ctx context.Context, key string, entry CacheEntry) error
+}
+
+// MemoryCache is an in-memory LRU cache of results
+type MemoryCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+}
+
+type memoryEntry struct {
+	key   string
+	entry CacheEntry
+}
+
+// NewMemoryCache return an in-memory cache of up to size results, evicting the
+// least recently used, or of any number if size is 0
+func NewMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Get implements Cache
+func (c *MemoryCache) Get(ctx context.Context, key string) (CacheEntry, bool, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+
+	if !ok {
+		return CacheEntry{}, false, nil
+	}
+
+	c.order.MoveToFront(e)
+
+	return e.Value.(*memoryEntry).entry, true, nil
+}
+
+// Set implements Cache, evicting the least recently used beyond the size limit
+func (c *MemoryCache) Set(ctx context.Context, key string, entry CacheEntry) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value = &memoryEntry{key: key, entry: entry}
+		c.order.MoveToFront(e)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryEntry{key: key, entry: entry})
+
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).key)
+	}
+
+	return nil
+}
+
+// CacheKey return the cache key of an item queried from an endpoint (or DNS
+// server) via a method, and the DNS record type for the dns method, e.g
+// https://api.zetascan.com/json/127.9.9.1
+func CacheKey(endpoint string, method string, recordType string, item string) string {
+
+	if method == "dns" {
+		if recordType == "" {
+			recordType = RecordA
+		}
+		method += "/" + recordType
+	}
+
+	return endpoint + "/" + method + "/" + item
+}
+
+// CacheKey return the cache key of an item queried via the Api's method, from
+// its primary endpoint or DNS server
+func (myapi Api) CacheKey(item string) string {
+
+	endpoint := myapi.GetEndpoint()
+	if myapi.ApiMethod == "dns" {
+		endpoint = myapi.dnsServer()
+	}
+
+	return CacheKey(endpoint, myapi.ApiMethod, myapi.DnsType, item)
+}
+
+// cached return the cached result of an item, if the Api has a cache and it
+// hasn't expired, or is stale while it's refreshed in the background
+func (myapi Api) cached(ctx context.Context, span Span, item Item) (m JsonRecord, ok bool) {
+
+	if myapi.Cache == nil {
+		return m, false
+	}
+
+	key := myapi.CacheKey(item.String())
+	entry, ok, err := myapi.Cache.Get(ctx, key)
+
+	if err != nil {
+		myapi.logger().WarnContext(ctx, "zetascan cache lookup failed", "method", myapi.ApiMethod, "error", err)
+	}
+
+	now := time.Now()
+	stale := ok && err == nil && entry.Stale(now)
+	ok = ok && err == nil && (!entry.Expired(now) || stale)
+
+	if myapi.Metrics != nil {
+		myapi.Metrics.ObserveCache(myapi.ApiMethod, ok)
+	}
+
+	span.SetAttribute(AttrCacheHit, ok)
+
+	if stale {
+		span.SetAttribute(AttrCacheStale, true)
+		myapi.revalidate(ctx, key, item)
+	}
+
+	// Callers may modify the results, don't share them with the cache
+	m = entry.Record
+	m.Results = append(JsonResults(nil), m.Results...)
+
+	return m, ok
+}
+
+// DefaultRevalidateTimeout limits the background refresh of a stale result
+var DefaultRevalidateTimeout = 30 * time.Second
+
+// revalidate refreshes a stale result in the background, unless it already is
+func (myapi Api) revalidate(ctx context.Context, key string, item Item) {
+
+	revalidating := myapi.revalidating
+
+	// Not from Init or NewClient, served stale without a refresh
+	if revalidating == nil {
+		return
+	}
+
+	if _, busy := revalidating.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+
+	// Outlive the caller's query, but keep its values (e.g the trace)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultRevalidateTimeout)
+
+	go func() {
+		defer revalidating.Delete(key)
+		defer cancel()
+
+		ctx, span := myapi.startSpan(ctx, SpanRevalidate)
+		span.SetAttribute(AttrMethod, myapi.ApiMethod)
+		defer span.End()
+
+		// Another refresh may have finished since the stale entry was read
+		if entry, ok, err := myapi.Cache.Get(ctx, key); err == nil && ok && !entry.Expired(time.Now()) {
+			return
+		}
+
+		if _, _, err := myapi.lookup(ctx, item); err != nil {
+			span.RecordError(err)
+			myapi.logger().WarnContext(ctx, "zetascan cache refresh failed", "method", myapi.ApiMethod, "error", err)
+		}
+	}()
+}
+
+// store caches the result of an item, if the Api has a cache
+func (myapi Api) store(ctx context.Context, item Item, m JsonRecord) {
+
+	if myapi.Cache == nil {
+		return
+	}
+
+	ttl := myapi.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	now := time.Now()
+	m.Results = append(JsonResults(nil), m.Results...)
+
+	entry := CacheEntry{Record: m, Method: myapi.ApiMethod, Stored: now, Expires: now.Add(ttl)}
+
+	if myapi.CacheStale > 0 {
+		entry.StaleUntil = entry.Expires.Add(myapi.CacheStale)
+	}
+
+	if err := myapi.Cache.Set(ctx, myapi.CacheKey(item.String()), entry); err != nil {
+		myapi.logger().WarnContext(ctx, "zetascan cache store failed", "method", myapi.ApiMethod, "error", err)
+	}
+}
@@ -0,0 +1,161 @@
+package zetascan
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS record types queried by the dns method
+const (
+	RecordA   = "A"   // Listing codes, e.g 127.0.0.2
+	RecordTXT = "TXT" // The text format answer, with the score and sources
+)
+
+// ClientConfig configures the connection pool and cache of a Client
+type ClientConfig struct {
+	// Idle HTTP connections kept open per end-point
+	MaxIdleConnsPerHost int
+
+	// Results are cached in memory for CacheTTL, 0 to disable the cache,
+	// unless the Api has a Cache. CacheSize limits the number of results,
+	// evicting the least recently used.
+	CacheTTL  time.Duration
+	CacheSize int
+}
+
+// DefaultClientConfig pools connections, and does not cache results
+var DefaultClientConfig = ClientConfig{
+	MaxIdleConnsPerHost: 32,
+	CacheTTL:            0,
+	CacheSize:           10000,
+}
+
+// Client is a long-lived zetascan client, safe for concurrent use by many
+// goroutines. Its configuration is copied from an Api when created and can't
+// be changed, options override the method and DNS record type of a call.
+// The connection pool, cache, metrics, tracer and logger are shared by every call.
+type Client struct {
+	api Api
+}
+
+// CallOption overrides the client's configuration for one call
+type CallOption func(*Api)
+
+// WithMethod queries via a method (http, text, json, jsonx or dns)
+func WithMethod(method string) CallOption {
+	return func(myapi *Api) { myapi.ApiMethod = method }
+}
+
+// WithRecordType queries A or TXT records, for the dns method
+func WithRecordType(recordType string) CallOption {
+	return func(myapi *Api) { myapi.DnsType = strings.ToUpper(recordType) }
+}
+
+// WithNoCache queries the endpoint, neither reading nor storing cached results
+func WithNoCache() CallOption {
+	return func(myapi *Api) { myapi.Cache = nil }
+}
+
+// NewClient return a client configured by myapi, e.g from Init and SetEndpoint
+func NewClient(myapi Api, config ClientConfig) *Client {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+
+	myapi.client = &http.Client{Transport: transport}
+	myapi.revalidating = &sync.Map{}
+
+	if config.CacheTTL > 0 && myapi.Cache == nil {
+		myapi.Cache = NewMemoryCache(config.CacheSize)
+		myapi.CacheTTL = config.CacheTTL
+	}
+
+	return &Client{api: myapi}
+}
+
+// Api return a copy of the client's Api with the options applied, sharing the
+// client's connection pool and cache, e.g for Diagnose or QueryBatch
+func (c *Client) Api(opts ...CallOption) Api {
+
+	myapi := c.api
+
+	for _, opt := range opts {
+		opt(&myapi)
+	}
+
+	return myapi
+}
+
+// Query a domain/IP, cancelled when ctx is done
+func (c *Client) Query(ctx context.Context, item string, opts ...CallOption) (m JsonRecord, err error) {
+
+	myapi := c.Api(opts...)
+
+	if err := myapi.checkCall(); err != nil {
+		return m, err
+	}
+
+	return myapi.QueryContext(ctx, item)
+}
+
+// QueryBatch queries many items concurrently, see Api.QueryBatch
+func (c *Client) QueryBatch(ctx context.Context, items []string, concurrency int, opts ...CallOption) ([]BatchResult, error) {
+
+	myapi := c.Api(opts...)
+
+	if err := myapi.checkCall(); err != nil {
+		return nil, err
+	}
+
+	return myapi.QueryBatch(ctx, items, concurrency), nil
+}
+
+// QueryEach queries many items concurrently, calling fn with each result, see Api.QueryEach
+func (c *Client) QueryEach(ctx context.Context, items []string, batch BatchOptions, fn func(i int, result BatchResult), opts ...CallOption) error {
+
+	myapi := c.Api(opts...)
+
+	if err := myapi.checkCall(); err != nil {
+		return err
+	}
+
+	return myapi.QueryEach(ctx, items, batch, fn)
+}
+
+// Close closes idle connections
+func (c *Client) Close() error {
+
+	c.api.client.CloseIdleConnections()
+
+	return nil
+}
+
+// checkCall return an error if the method or record type is unknown
+func (myapi Api) checkCall() error {
+
+	for _, method := range append([]string{myapi.ApiMethod}, myapi.MethodFallback...) {
+		if !validMethod(method) {
+			return errors.New("Unknown query method: " + method)
+		}
+	}
+
+	if myapi.DnsType != "" && myapi.DnsType != RecordA && myapi.DnsType != RecordTXT {
+		return errors.New("Unknown DNS record type: " + myapi.DnsType)
+	}
+
+	return nil
+}
+
+// httpClient return the client's pooled HTTP client, or the default client
+func (myapi Api) httpClient() *http.Client {
+
+	if myapi.client == nil {
+		return http.DefaultClient
+	}
+
+	return myapi.client
+}
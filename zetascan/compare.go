@@ -0,0 +1,296 @@
+package zetascan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// CompareFields are the result fields compared between methods
+var CompareFields = []string{"found", "wl", "score", "webscore", "sources"}
+
+// FieldDiff is a field where the methods disagree, with each method's normalised value
+type FieldDiff struct {
+	Field  string            `json:"field"`
+	Values map[string]string `json:"values"`
+}
+
+// ItemComparison is the result of one item queried via every method
+type ItemComparison struct {
+	Item   string            `json:"item"`
+	Agree  bool              `json:"agree"`
+	Diffs  []FieldDiff       `json:"diffs,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Comparison is the result of Compare
+type Comparison struct {
+	Methods   []string         `json:"methods"`
+	Reference string           `json:"reference"`
+	Items     []ItemComparison `json:"items"`
+
+	// Agreement counts, per method and field, the items matching the reference method.
+	// Compared counts the items each method answered (along with the reference).
+	Agreement map[string]map[string]int `json:"agreement"`
+	Compared  map[string]int            `json:"compared"`
+}
+
+// Compare queries the items via each method in parallel (all methods if none
+// specified) and diffs the normalised results field by field. Items default to
+// the documented test items. The reference method for agreement is jsonx, json
+// or otherwise the first method.
+func (myapi Api) Compare(ctx context.Context, items []string, methods ...string) (c *Comparison, err error) {
+
+	if len(methods) == 0 {
+		methods = Methods
+	}
+
+	for _, method := range methods {
+		if !validMethod(method) {
+			return nil, errors.New("Unknown query method: " + method)
+		}
+	}
+
+	if len(items) == 0 {
+		for _, test := range TestItems {
+			items = append(items, test.Item)
+		}
+	}
+
+	c = &Comparison{
+		Methods:   methods,
+		Reference: referenceMethod(methods),
+		Agreement: make(map[string]map[string]int),
+		Compared:  make(map[string]int),
+	}
+
+	// Query every item via every method at once, bounded by DefaultConcurrency
+	records := make([][]JsonRecord, len(items))
+	errs := make([][]error, len(items))
+	sem := make(chan struct{}, DefaultConcurrency)
+
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+
+		records[i] = make([]JsonRecord, len(methods))
+		errs[i] = make([]error, len(methods))
+
+		for j, method := range methods {
+
+			api := myapi.only(method)
+
+			wg.Add(1)
+			go func(i, j int, item string) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				records[i][j], errs[i][j] = api.QueryContext(ctx, item)
+			}(i, j, item)
+		}
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, method := range methods {
+		c.Agreement[method] = make(map[string]int)
+	}
+
+	for i, item := range items {
+		c.Items = append(c.Items, c.compareItem(item, records[i], errs[i]))
+	}
+
+	return c, nil
+}
+
+// compareItem diffs the results of one item
+func (c *Comparison) compareItem(item string, records []JsonRecord, errs []error) (ic ItemComparison) {
+
+	ic = ItemComparison{Item: item, Agree: true}
+	values := make(map[string]map[string]string)
+
+	for j, method := range c.Methods {
+
+		if errs[j] != nil {
+			if ic.Errors == nil {
+				ic.Errors = make(map[string]string)
+			}
+			ic.Errors[method] = errs[j].Error()
+			ic.Agree = false
+			continue
+		}
+
+		values[method] = normalise(records[j])
+	}
+
+	for _, field := range CompareFields {
+
+		diff := FieldDiff{Field: field, Values: make(map[string]string)}
+		distinct := make(map[string]bool)
+
+		for _, method := range c.Methods {
+			if v, ok := values[method]; ok {
+				diff.Values[method] = v[field]
+				distinct[v[field]] = true
+			}
+		}
+
+		if len(distinct) > 1 {
+			ic.Agree = false
+			ic.Diffs = append(ic.Diffs, diff)
+		}
+	}
+
+	// Count agreement with the reference method
+	ref, ok := values[c.Reference]
+
+	if !ok {
+		return ic
+	}
+
+	for method, v := range values {
+
+		c.Compared[method]++
+
+		for _, field := range CompareFields {
+			if v[field] == ref[field] {
+				c.Agreement[method][field]++
+			}
+		}
+	}
+
+	return ic
+}
+
+// Disagreements return the number of items where the methods disagree or failed
+func (c *Comparison) Disagreements() (n int) {
+
+	for _, ic := range c.Items {
+		if !ic.Agree {
+			n++
+		}
+	}
+
+	return n
+}
+
+// WriteText renders the comparison as human readable tables
+func (c *Comparison) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Compared %d items via %s, %d disagree\n\n", len(c.Items), strings.Join(c.Methods, ", "), c.Disagreements())
+
+	for _, ic := range c.Items {
+
+		if ic.Agree {
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\n", ic.Item)
+
+		for _, method := range c.Methods {
+			if err, ok := ic.Errors[method]; ok {
+				fmt.Fprintf(tw, "  %s\terror: %s\t\n", method, err)
+			}
+		}
+
+		for _, diff := range ic.Diffs {
+
+			fmt.Fprintf(tw, "  %s", diff.Field)
+
+			for _, method := range c.Methods {
+				if v, ok := diff.Values[method]; ok {
+					fmt.Fprintf(tw, "\t%s=%s", method, v)
+				}
+			}
+
+			fmt.Fprintln(tw, "\t")
+		}
+
+		fmt.Fprintln(tw)
+	}
+
+	// Agreement with the reference, to show which transport is safe for which decision
+	fmt.Fprintf(tw, "Agreement with %s\n", c.Reference)
+	fmt.Fprintf(tw, "  METHOD\t%s\t\n", strings.ToUpper(strings.Join(CompareFields, "\t")))
+
+	for _, method := range c.Methods {
+
+		fmt.Fprintf(tw, "  %s", method)
+
+		for _, field := range CompareFields {
+			fmt.Fprintf(tw, "\t%d/%d", c.Agreement[method][field], c.Compared[method])
+		}
+
+		fmt.Fprintln(tw, "\t")
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON renders the comparison as indented JSON
+func (c *Comparison) WriteJSON(w io.Writer) error {
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(c)
+}
+
+// normalise return the compared fields of a record as comparable strings
+func normalise(m JsonRecord) map[string]string {
+
+	var result JsonResult
+
+	if len(m.Results) > 0 {
+		result = m.Results[0]
+	}
+
+	var sources []string
+	for _, source := range result.Sources {
+		if source = strings.ToLower(strings.TrimSpace(source)); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+
+	return map[string]string{
+		"found":    strconv.FormatBool(result.Found),
+		"wl":       strconv.FormatBool(result.Wl),
+		"score":    formatScore(result.Score),
+		"webscore": formatScore(result.WebScore),
+		"sources":  strings.Join(sources, ","),
+	}
+}
+
+// formatScore rounds to 2 decimal places, hiding float32 parsing noise
+func formatScore(score float64) string {
+	return strconv.FormatFloat(math.Round(score*100)/100, 'f', -1, 64)
+}
+
+// referenceMethod picks the richest method to compare against
+func referenceMethod(methods []string) string {
+
+	for _, preferred := range []string{"jsonx", "json"} {
+		for _, method := range methods {
+			if method == preferred {
+				return method
+			}
+		}
+	}
+
+	return methods[0]
+}
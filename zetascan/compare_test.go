@@ -0,0 +1,200 @@
+package zetascan_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+func TestCompare(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	// The JSON methods agree on every field
+	c, err := emulator.Api("").Compare(context.Background(), nil, "http", "json", "jsonx")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Reference != "jsonx" || len(c.Items) != len(zetascan.TestItems) || c.Disagreements() != 0 {
+		t.Fatalf("reference %s, %d items, %d disagree", c.Reference, len(c.Items), c.Disagreements())
+	}
+
+	for _, method := range c.Methods {
+		for _, field := range zetascan.CompareFields {
+			if c.Agreement[method][field] != len(c.Items) || c.Compared[method] != len(c.Items) {
+				t.Errorf("%s %s: %d/%d agree", method, field, c.Agreement[method][field], c.Compared[method])
+			}
+		}
+	}
+
+	// DNS carries the verdict alone, so differs on the score and sources
+	c, err = emulator.Api("").Compare(context.Background(), []string{"127.9.9.1"}, "dns", "json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ic := c.Items[0]
+
+	if c.Reference != "json" || ic.Agree || len(ic.Diffs) != 3 {
+		t.Fatalf("reference %s, item %+v", c.Reference, ic)
+	}
+
+	for i, field := range []string{"score", "webscore", "sources"} {
+		if ic.Diffs[i].Field != field {
+			t.Errorf("diff %d is %s, want %s", i, ic.Diffs[i].Field, field)
+		}
+	}
+
+	if v := ic.Diffs[0].Values; v["dns"] != "0" || v["json"] != "0.95" {
+		t.Errorf("score values %v", v)
+	}
+
+	if c.Agreement["dns"]["found"] != 1 || c.Agreement["dns"]["score"] != 0 {
+		t.Errorf("dns agreement %v", c.Agreement["dns"])
+	}
+
+	if _, err := emulator.Api("").Compare(context.Background(), nil, "json", "smtp"); err == nil {
+		t.Error("Compare accepted an unknown method")
+	}
+}
+
+// A listing differing between methods is found, and failed methods are reported
+func TestCompareDisagreement(t *testing.T) {
+
+	fixtures := zetascantest.DefaultFixtures()
+	fixtures["127.9.9.3"] = zetascantest.Fixture{Found: true, Score: 0.8, Sources: []string{"shSBL"}, DNS: []string{"127.8.0.1"}}
+
+	emulator := zetascantest.NewServer(fixtures)
+	defer emulator.Close()
+
+	emulator.InjectMethod("text", zetascantest.Burst(http.StatusForbidden, -1))
+
+	c, err := emulator.Api("").Compare(context.Background(), []string{"127.9.9.1", "127.9.9.3"}, "dns", "text", "json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Disagreements() != 2 {
+		t.Errorf("%d disagreements, want 2", c.Disagreements())
+	}
+
+	for _, ic := range c.Items {
+		if ic.Errors["text"] == "" || len(ic.Errors) != 1 {
+			t.Errorf("%s: errors %v, want text", ic.Item, ic.Errors)
+		}
+	}
+
+	// Listed via json, whitelisted via dns
+	diffs := make(map[string]map[string]string)
+	for _, diff := range c.Items[1].Diffs {
+		diffs[diff.Field] = diff.Values
+	}
+
+	if want := map[string]string{"dns": "false", "json": "true"}; !maps.Equal(diffs["found"], want) {
+		t.Errorf("found values %v, want %v", diffs["found"], want)
+	}
+
+	if want := map[string]string{"dns": "true", "json": "false"}; !maps.Equal(diffs["wl"], want) {
+		t.Errorf("wl values %v, want %v", diffs["wl"], want)
+	}
+
+	// Failed methods are not counted
+	if c.Compared["text"] != 0 || c.Compared["dns"] != 2 || c.Agreement["dns"]["found"] != 1 {
+		t.Errorf("compared %v, dns agreement %v", c.Compared, c.Agreement["dns"])
+	}
+
+	var text bytes.Buffer
+
+	if err := c.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"Compared 2 items via dns, text, json, 2 disagree", "error: Request forbidden", "found", "dns=false", "json=true", "Agreement with json"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text missing %q:\n%s", want, &text)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := c.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded zetascan.Comparison
+
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Reference != "json" || len(decoded.Items) != 2 || decoded.Items[1].Agree || decoded.Compared["dns"] != 2 {
+		t.Errorf("decoded comparison %+v", decoded)
+	}
+}
+
+func TestNormalise(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		record zetascan.JsonRecord
+		want   map[string]string
+	}{
+		{
+			name:   "no results",
+			record: zetascan.JsonRecord{},
+			want:   map[string]string{"found": "false", "wl": "false", "score": "0", "webscore": "0", "sources": ""},
+		},
+		{
+			name: "sources",
+			record: zetascan.JsonRecord{Results: []zetascan.JsonResult{
+				{Found: true, Score: 0.95, WebScore: 0.6, Sources: []string{" shXBL", "", "SHSBL "}},
+			}},
+			want: map[string]string{"found": "true", "wl": "false", "score": "0.95", "webscore": "0.6", "sources": "shsbl,shxbl"},
+		},
+		{
+			name: "float32 noise",
+			record: zetascan.JsonRecord{Results: []zetascan.JsonResult{
+				{Wl: true, Score: float64(float32(-0.1)), WebScore: 0.30000001},
+			}},
+			want: map[string]string{"found": "false", "wl": "true", "score": "-0.1", "webscore": "0.3", "sources": ""},
+		},
+	}
+
+	for _, test := range tests {
+		if got := zetascan.Normalise(test.record); !maps.Equal(got, test.want) {
+			t.Errorf("%s: Normalise = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// Compare queries the endpoint, not the cache
+func TestCompareUncached(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.Cache = zetascan.NewMemoryCache(100)
+
+	for range 2 {
+		if _, err := myzetascan.Compare(context.Background(), []string{"127.9.9.1"}, "json"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := emulator.Requests("127.9.9.1"); n != 2 {
+		t.Errorf("%d requests for 127.9.9.1, want 2", n)
+	}
+}
@@ -0,0 +1,508 @@
+// Package config loads zetascan settings from a YAML config file with named
+// profiles, and ZETASCAN_* environment variables, and builds an Api from them.
+//
+// Settings are applied in order of precedence, highest first: explicit
+// overrides (e.g command line flags), the environment, the selected profile,
+// then the top level of the config file.
+//
+//	# ~/.config/zetascan/config.yaml
+//	method: json
+//	profile: prod
+//
+//	profiles:
+//	  prod:
+//	    apikey: YOURAPIKEY
+//	    fallback: [restlb.zetascan.com]
+//	  onprem:
+//	    ipauth: true
+//	    endpoint: http://zetascan.internal:8080
+//	    dns_server: zetascan.internal:53
+//	    reject: 0.8
+package config
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Settings configure an Api, and the thresholds of the mail integrations.
+// Empty or nil fields are not set, so lower precedence settings apply.
+type Settings struct {
+	APIKey       zetascan.Secret `yaml:"apikey"`
+	SecondaryKey zetascan.Secret `yaml:"apikey_secondary"` // Tried when the API key is forbidden
+	KeyFile      string          `yaml:"key_file"`         // Watched file of keys, see zetascan.FileKeys
+	IPAuth       *bool           `yaml:"ipauth"`
+	HeaderAuth   *bool           `yaml:"header_auth"`
+	Endpoint     string          `yaml:"endpoint"`
+	Fallback     []string        `yaml:"fallback"`
+	Method       string          `yaml:"method"` // A method, or comma separated fallback chain, e.g dns,jsonx
+	Enrich       *bool           `yaml:"enrich"` // Look up listed items via jsonx for the extended data
+	DNSServer    string          `yaml:"dns_server"`
+	Cache        string          `yaml:"cache"`       // Result cache file or URL, opened by the backend registered with RegisterCache
+	CacheTTL     time.Duration   `yaml:"cache_ttl"`   // e.g 24h, default zetascan.DefaultCacheTTL
+	CacheStale   time.Duration   `yaml:"cache_stale"` // Serve expired results this much longer while refreshing them
+
+	// Score thresholds for the policy server and milter
+	RejectScore     *float64 `yaml:"reject"`
+	DeferScore      *float64 `yaml:"defer"`
+	QuarantineScore *float64 `yaml:"quarantine"`
+}
+
+// File is the config file format, settings at the top level apply to every profile
+type File struct {
+	Settings `yaml:",inline"`
+
+	// Profile used when none is specified
+	Profile  string              `yaml:"profile"`
+	Profiles map[string]Settings `yaml:"profiles"`
+}
+
+// Environment variables read by FromEnv
+const (
+	EnvConfig     = "ZETASCAN_CONFIG"
+	EnvProfile    = "ZETASCAN_PROFILE"
+	EnvAPIKey     = "ZETASCAN_API_KEY"
+	EnvSecondary  = "ZETASCAN_API_KEY_SECONDARY"
+	EnvKeyFile    = "ZETASCAN_KEY_FILE"
+	EnvIPAuth     = "ZETASCAN_IPAUTH"
+	EnvHeaderAuth = "ZETASCAN_HEADER_AUTH"
+	EnvEndpoint   = "ZETASCAN_ENDPOINT"
+	EnvFallback   = "ZETASCAN_FALLBACK" // Comma separated
+	EnvMethod     = "ZETASCAN_METHOD"
+	EnvEnrich     = "ZETASCAN_ENRICH"
+	EnvDNSServer  = "ZETASCAN_DNS_SERVER"
+	EnvCache      = "ZETASCAN_CACHE"
+	EnvCacheTTL   = "ZETASCAN_CACHE_TTL"
+	EnvCacheStale = "ZETASCAN_CACHE_STALE"
+	EnvReject     = "ZETASCAN_REJECT"
+	EnvDefer      = "ZETASCAN_DEFER"
+	EnvQuarantine = "ZETASCAN_QUARANTINE"
+)
+
+// DefaultPath return the config file used when none is specified,
+// $XDG_CONFIG_HOME/zetascan/config.yaml or ~/.config/zetascan/config.yaml
+func DefaultPath() string {
+
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "zetascan", "config.yaml")
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".config", "zetascan", "config.yaml")
+}
+
+// ReadFile reads a config file
+func ReadFile(path string) (f File, err error) {
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return f, err
+	}
+
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return f, errors.New("Invalid config file " + path + ": " + err.Error())
+	}
+
+	return f, nil
+}
+
+// Select return the settings of a profile over the top level settings, or the
+// file's default profile if profile is empty
+func (f File) Select(profile string) (Settings, error) {
+
+	if profile == "" {
+		profile = f.Profile
+	}
+
+	if profile == "" {
+		return f.Settings, nil
+	}
+
+	p, ok := f.Profiles[profile]
+
+	if !ok {
+		return f.Settings, errors.New("Unknown config profile: " + profile)
+	}
+
+	return f.Settings.Merge(p), nil
+}
+
+// ProfileNames return the profiles in the file, sorted
+func (f File) ProfileNames() (names []string) {
+
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// FromEnv return the settings from ZETASCAN_* environment variables
+func FromEnv() (s Settings, err error) {
+
+	get := func(key string) string {
+		return strings.TrimSpace(os.Getenv(key))
+	}
+
+	s.APIKey = zetascan.Secret(get(EnvAPIKey))
+	s.SecondaryKey = zetascan.Secret(get(EnvSecondary))
+	s.KeyFile = get(EnvKeyFile)
+	s.Endpoint = get(EnvEndpoint)
+	s.Method = get(EnvMethod)
+	s.DNSServer = get(EnvDNSServer)
+	s.Cache = get(EnvCache)
+
+	if v := get(EnvCacheTTL); v != "" {
+		if s.CacheTTL, err = time.ParseDuration(v); err != nil || s.CacheTTL < 0 {
+			return s, errors.New("Invalid " + EnvCacheTTL + ": " + v)
+		}
+	}
+
+	if v := get(EnvCacheStale); v != "" {
+		if s.CacheStale, err = time.ParseDuration(v); err != nil || s.CacheStale < 0 {
+			return s, errors.New("Invalid " + EnvCacheStale + ": " + v)
+		}
+	}
+
+	for _, fallback := range strings.Split(get(EnvFallback), ",") {
+		if fallback = strings.TrimSpace(fallback); fallback != "" {
+			s.Fallback = append(s.Fallback, fallback)
+		}
+	}
+
+	for key, flag := range map[string]**bool{EnvIPAuth: &s.IPAuth, EnvHeaderAuth: &s.HeaderAuth, EnvEnrich: &s.Enrich} {
+
+		v := get(key)
+
+		if v == "" {
+			continue
+		}
+
+		b, err := strconv.ParseBool(v)
+
+		if err != nil {
+			return s, errors.New("Invalid " + key + ": " + v)
+		}
+
+		*flag = &b
+	}
+
+	for key, score := range map[string]**float64{EnvReject: &s.RejectScore, EnvDefer: &s.DeferScore, EnvQuarantine: &s.QuarantineScore} {
+
+		v := get(key)
+
+		if v == "" {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(v, 64)
+
+		if err != nil {
+			return s, errors.New("Invalid " + key + ": " + v)
+		}
+
+		*score = &f
+	}
+
+	return s, nil
+}
+
+// Load return the settings of a profile from the config file, overridden by
+// the environment. The path defaults to ZETASCAN_CONFIG or DefaultPath, which
+// may not exist, and the profile to ZETASCAN_PROFILE or the file's default.
+func Load(path, profile string) (s Settings, err error) {
+
+	if profile == "" {
+		profile = os.Getenv(EnvProfile)
+	}
+
+	f, err := LoadFile(path)
+
+	if err != nil {
+		return s, err
+	}
+
+	if s, err = f.Select(profile); err != nil {
+		return s, err
+	}
+
+	env, err := FromEnv()
+
+	if err != nil {
+		return s, err
+	}
+
+	return s.Merge(env), nil
+}
+
+// LoadFile reads the config file at path, ZETASCAN_CONFIG or DefaultPath.
+// Only a missing default file is not an error.
+func LoadFile(path string) (f File, err error) {
+
+	if path == "" {
+		path = os.Getenv(EnvConfig)
+	}
+
+	if path != "" {
+		return ReadFile(path)
+	}
+
+	if path = DefaultPath(); path == "" {
+		return f, nil
+	}
+
+	f, err = ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return File{}, nil
+	}
+
+	return f, err
+}
+
+// Merge return s with any field set in override replacing it
+func (s Settings) Merge(override Settings) Settings {
+
+	if override.APIKey != "" {
+		s.APIKey = override.APIKey
+	}
+
+	if override.SecondaryKey != "" {
+		s.SecondaryKey = override.SecondaryKey
+	}
+
+	if override.KeyFile != "" {
+		s.KeyFile = override.KeyFile
+	}
+
+	if override.IPAuth != nil {
+		s.IPAuth = override.IPAuth
+	}
+
+	if override.HeaderAuth != nil {
+		s.HeaderAuth = override.HeaderAuth
+	}
+
+	if override.Endpoint != "" {
+		s.Endpoint = override.Endpoint
+	}
+
+	if len(override.Fallback) > 0 {
+		s.Fallback = override.Fallback
+	}
+
+	if override.Method != "" {
+		s.Method = override.Method
+	}
+
+	if override.Enrich != nil {
+		s.Enrich = override.Enrich
+	}
+
+	if override.DNSServer != "" {
+		s.DNSServer = override.DNSServer
+	}
+
+	if override.Cache != "" {
+		s.Cache = override.Cache
+	}
+
+	if override.CacheTTL != 0 {
+		s.CacheTTL = override.CacheTTL
+	}
+
+	if override.CacheStale != 0 {
+		s.CacheStale = override.CacheStale
+	}
+
+	if override.RejectScore != nil {
+		s.RejectScore = override.RejectScore
+	}
+
+	if override.DeferScore != nil {
+		s.DeferScore = override.DeferScore
+	}
+
+	if override.QuarantineScore != nil {
+		s.QuarantineScore = override.QuarantineScore
+	}
+
+	return s
+}
+
+// Api return an Api configured by the settings. An API key or key file is
+// required unless IPAuth is set. A key file is watched for changes.
+//
+// Close the returned closer when done with the Api, to stop watching the key
+// file and close the cache. Nothing is left open if an error is returned.
+func (s Settings) Api() (myzetascan zetascan.Api, closer io.Closer, err error) {
+
+	var opened closers
+
+	defer func() {
+		if err != nil {
+			opened.Close()
+			closer = nil
+		}
+	}()
+
+	ipAuth := s.IPAuth != nil && *s.IPAuth
+
+	if s.APIKey == "" && s.KeyFile == "" && !ipAuth {
+		return myzetascan, nil, errors.New("Please specify an API key, or enable IP authentication")
+	}
+
+	myzetascan, err = myzetascan.Init(s.APIKey.Reveal(), ipAuth)
+
+	if err != nil {
+		return myzetascan, nil, err
+	}
+
+	if s.KeyFile != "" {
+
+		keys, err := zetascan.NewFileKeys(s.KeyFile, 0)
+
+		if err != nil {
+			return myzetascan, nil, err
+		}
+
+		myzetascan.Keys = keys
+		opened = append(opened, keys)
+
+	} else if s.SecondaryKey != "" {
+		myzetascan.Keys = zetascan.StaticKeys(s.APIKey.Reveal(), s.SecondaryKey.Reveal())
+	}
+
+	if s.Method != "" {
+
+		methods, err := zetascan.ParseMethods(s.Method)
+
+		if err != nil {
+			return myzetascan, nil, err
+		}
+
+		myzetascan.ApiMethod = methods[0]
+		myzetascan.MethodFallback = methods[1:]
+	}
+
+	myzetascan.EnrichOnHit = s.Enrich != nil && *s.Enrich
+
+	if s.Cache != "" {
+
+		cache, err := OpenCache(s.Cache)
+
+		if err != nil {
+			return myzetascan, nil, err
+		}
+
+		if c, ok := cache.(io.Closer); ok {
+			opened = append(opened, c)
+		}
+
+		myzetascan.Cache = cache
+		myzetascan.CacheTTL = s.CacheTTL
+		myzetascan.CacheStale = s.CacheStale
+	}
+
+	if s.Endpoint != "" || len(s.Fallback) > 0 {
+
+		endpoint := s.Endpoint
+		if endpoint == "" {
+			endpoint = myzetascan.GetEndpoint()
+		}
+
+		if myzetascan, err = myzetascan.SetEndpoint(endpoint, s.Fallback...); err != nil {
+			return myzetascan, nil, err
+		}
+	}
+
+	if s.DNSServer != "" {
+		myzetascan.DnsServer = s.DNSServer
+	}
+
+	myzetascan.HeaderAuth = s.HeaderAuth != nil && *s.HeaderAuth
+
+	return myzetascan, opened, nil
+}
+
+// closers closes each of the resources opened for an Api
+type closers []io.Closer
+
+// Close implements io.Closer, returning the first error
+func (cs closers) Close() (err error) {
+
+	for _, c := range cs {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// CacheOpener opens the result cache of a cache setting, a file path or URL
+type CacheOpener func(setting string) (zetascan.Cache, error)
+
+var (
+	cacheMu      sync.RWMutex
+	cacheOpeners = make(map[string]CacheOpener)
+)
+
+// RegisterCache makes a cache backend available to the cache setting, for a
+// URL scheme (e.g "redis") or "" for file paths. Commands register the
+// backends they support, e.g zetascan/diskcache and zetascan/rediscache, so
+// the config package doesn't depend on every backend.
+func RegisterCache(scheme string, open CacheOpener) {
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	cacheOpeners[scheme] = open
+}
+
+// OpenCache opens the cache of a setting via the backend registered for its scheme
+func OpenCache(setting string) (zetascan.Cache, error) {
+
+	scheme := ""
+	if i := strings.Index(setting, "://"); i > 0 {
+		scheme = strings.ToLower(setting[:i])
+	}
+
+	cacheMu.RLock()
+	open, ok := cacheOpeners[scheme]
+	cacheMu.RUnlock()
+
+	if !ok && scheme == "" {
+		return nil, errors.New("No cache backend registered for cache files")
+	}
+
+	if !ok {
+		return nil, errors.New("No cache backend registered for " + scheme + ":// caches")
+	}
+
+	return open(setting)
+}
+
+// IsRedisURL return if a cache setting is a Redis-protocol store rather than a file
+func IsRedisURL(cache string) bool {
+	return strings.HasPrefix(cache, "redis://") || strings.HasPrefix(cache, "rediss://")
+}
@@ -0,0 +1,306 @@
+package config_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/config"
+)
+
+// clearEnv unsets every ZETASCAN_* variable, and points the default config at an empty directory
+func clearEnv(t *testing.T) {
+
+	for _, key := range []string{
+		config.EnvConfig, config.EnvProfile, config.EnvAPIKey, config.EnvSecondary, config.EnvKeyFile,
+		config.EnvIPAuth, config.EnvHeaderAuth, config.EnvEndpoint, config.EnvFallback, config.EnvMethod,
+		config.EnvEnrich, config.EnvDNSServer, config.EnvCache, config.EnvCacheTTL, config.EnvCacheStale,
+		config.EnvReject, config.EnvDefer, config.EnvQuarantine,
+	} {
+		t.Setenv(key, "")
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+}
+
+// writeConfig writes a config file, returning its path
+func writeConfig(t *testing.T, yaml string) string {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+const testConfig = `
+method: json
+endpoint: http://top.example.com
+dns_server: top.example.com:53
+cache_ttl: 1h
+reject: 0.9
+profile: prod
+
+profiles:
+  prod:
+    apikey: PRODKEY
+    method: jsonx
+    fallback: [restlb.zetascan.com]
+    reject: 0.8
+  onprem:
+    ipauth: true
+    endpoint: http://zetascan.internal:8080
+`
+
+func TestPrecedence(t *testing.T) {
+
+	clearEnv(t)
+	path := writeConfig(t, testConfig)
+
+	// Top level settings, under the default profile
+	s, err := config.Load(path, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.APIKey != "PRODKEY" || s.Method != "jsonx" || s.Endpoint != "http://top.example.com" || s.DNSServer != "top.example.com:53" {
+		t.Errorf("prod profile %+v", s)
+	}
+
+	if s.CacheTTL != time.Hour || *s.RejectScore != 0.8 || !slices.Equal(s.Fallback, []string{"restlb.zetascan.com"}) {
+		t.Errorf("prod profile ttl %v, reject %v, fallback %v", s.CacheTTL, *s.RejectScore, s.Fallback)
+	}
+
+	// A named profile over the default, and ZETASCAN_PROFILE
+	for _, profile := range []string{"onprem", "env"} {
+
+		var err error
+
+		if profile == "env" {
+			t.Setenv(config.EnvProfile, "onprem")
+			s, err = config.Load(path, "")
+		} else {
+			s, err = config.Load(path, profile)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s.APIKey != "" || s.IPAuth == nil || !*s.IPAuth || s.Endpoint != "http://zetascan.internal:8080" || s.Method != "json" || *s.RejectScore != 0.9 {
+			t.Errorf("%s profile %+v", profile, s)
+		}
+	}
+
+	t.Setenv(config.EnvProfile, "")
+
+	if _, err := config.Load(path, "staging"); err == nil || !strings.Contains(err.Error(), "staging") {
+		t.Errorf("unknown profile err = %v", err)
+	}
+
+	// The environment over the profile
+	t.Setenv(config.EnvAPIKey, " ENVKEY ")
+	t.Setenv(config.EnvMethod, "dns")
+	t.Setenv(config.EnvReject, "0.7")
+
+	if s, err = config.Load(path, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.APIKey != "ENVKEY" || s.Method != "dns" || *s.RejectScore != 0.7 || s.Endpoint != "http://top.example.com" {
+		t.Errorf("environment %+v", s)
+	}
+
+	// Overrides over everything
+	reject := 0.5
+	s = s.Merge(config.Settings{Method: "http", RejectScore: &reject})
+
+	if s.APIKey != "ENVKEY" || s.Method != "http" || *s.RejectScore != 0.5 {
+		t.Errorf("overrides %+v", s)
+	}
+
+	// ZETASCAN_CONFIG is used without a path
+	t.Setenv(config.EnvConfig, path)
+
+	if s, err = config.Load("", ""); err != nil || s.Method != "dns" || s.Endpoint != "http://top.example.com" {
+		t.Errorf("ZETASCAN_CONFIG settings %+v, %v", s, err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+
+	clearEnv(t)
+
+	// A missing default file is not an error
+	if f, err := config.LoadFile(""); err != nil || f.Profiles != nil {
+		t.Errorf("missing default file %+v, %v", f, err)
+	}
+
+	// Other missing files are
+	if _, err := config.LoadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file loaded")
+	}
+
+	if _, err := config.LoadFile(writeConfig(t, "profiles: [prod]\n")); err == nil || !strings.Contains(err.Error(), "Invalid config file") {
+		t.Errorf("invalid file err = %v", err)
+	}
+
+	f, err := config.LoadFile(writeConfig(t, testConfig))
+
+	if err != nil || !slices.Equal(f.ProfileNames(), []string{"onprem", "prod"}) {
+		t.Errorf("profiles %v, %v", f.ProfileNames(), err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+
+	clearEnv(t)
+
+	t.Setenv(config.EnvFallback, "a.example.com, ,b.example.com")
+	t.Setenv(config.EnvIPAuth, "true")
+	t.Setenv(config.EnvEnrich, "0")
+	t.Setenv(config.EnvCacheTTL, "30m")
+	t.Setenv(config.EnvQuarantine, "0.6")
+
+	s, err := config.FromEnv()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(s.Fallback, []string{"a.example.com", "b.example.com"}) || !*s.IPAuth || *s.Enrich || s.HeaderAuth != nil {
+		t.Errorf("settings %+v", s)
+	}
+
+	if s.CacheTTL != 30*time.Minute || *s.QuarantineScore != 0.6 || s.RejectScore != nil {
+		t.Errorf("ttl %v, quarantine %v, reject %v", s.CacheTTL, *s.QuarantineScore, s.RejectScore)
+	}
+}
+
+func TestFromEnvErrors(t *testing.T) {
+
+	for key, value := range map[string]string{
+		config.EnvIPAuth:     "maybe",
+		config.EnvHeaderAuth: "yes please",
+		config.EnvEnrich:     "2",
+		config.EnvCacheTTL:   "1 day",
+		config.EnvCacheStale: "-1h",
+		config.EnvReject:     "high",
+		config.EnvDefer:      "0.5.1",
+		config.EnvQuarantine: "x",
+	} {
+		t.Run(key, func(t *testing.T) {
+
+			clearEnv(t)
+			t.Setenv(key, value)
+
+			_, err := config.FromEnv()
+
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("err = %v, want an error naming %s", err, key)
+			}
+
+			// Load reports it too
+			if _, err := config.Load("", ""); err == nil {
+				t.Error("Load ignored the error")
+			}
+		})
+	}
+}
+
+// closingCache counts the times it is closed
+type closingCache struct {
+	closed int
+}
+
+func (c *closingCache) Get(ctx context.Context, key string) (zetascan.CacheEntry, bool, error) {
+	return zetascan.CacheEntry{}, false, nil
+}
+
+func (c *closingCache) Set(ctx context.Context, key string, entry zetascan.CacheEntry) error {
+	return nil
+}
+
+func (c *closingCache) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestApi(t *testing.T) {
+
+	cache := &closingCache{}
+
+	config.RegisterCache("test", func(setting string) (zetascan.Cache, error) {
+		return cache, nil
+	})
+
+	ipAuth := true
+
+	myzetascan, closer, err := config.Settings{IPAuth: &ipAuth, Method: "dns,jsonx", Cache: "test://", DNSServer: "127.0.0.1:5353"}.Api()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if myzetascan.ApiMethod != "dns" || !slices.Equal(myzetascan.MethodFallback, []string{"jsonx"}) || myzetascan.Cache != cache || myzetascan.DnsServer != "127.0.0.1:5353" {
+		t.Errorf("Api %+v", myzetascan)
+	}
+
+	if err := closer.Close(); err != nil || cache.closed != 1 {
+		t.Errorf("Close = %v, closed %d times", err, cache.closed)
+	}
+}
+
+// Resources opened before an error are closed
+func TestApiErrors(t *testing.T) {
+
+	cache := &closingCache{}
+
+	config.RegisterCache("test", func(setting string) (zetascan.Cache, error) {
+		return cache, nil
+	})
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+
+	if err := os.WriteFile(keyFile, []byte("YOURAPIKEY\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		settings config.Settings
+		closed   int
+	}{
+		{"no key", config.Settings{}, 0},
+		{"missing key file", config.Settings{KeyFile: keyFile + ".missing"}, 0},
+		{"unknown method", config.Settings{APIKey: "YOURAPIKEY", Method: "smtp"}, 0},
+		{"unknown cache", config.Settings{APIKey: "YOURAPIKEY", Cache: "memcached://localhost"}, 0},
+		{"invalid endpoint", config.Settings{KeyFile: keyFile, Cache: "test://", Endpoint: "ftp://zetascan.internal"}, 1},
+		{"invalid fallback", config.Settings{APIKey: "YOURAPIKEY", Cache: "test://", Fallback: []string{"ftp://zetascan.internal"}}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			cache.closed = 0
+
+			_, closer, err := test.settings.Api()
+
+			if err == nil || closer != nil {
+				t.Fatalf("err = %v, closer %v", err, closer)
+			}
+
+			if cache.closed != test.closed {
+				t.Errorf("cache closed %d times, want %d", cache.closed, test.closed)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package zetascan
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// TestItem is a documented zetascan test item, and whether it is blacklisted
+type TestItem struct {
+	Item   string
+	Listed bool
+}
+
+// TestItems are the documented test items, answered the same by every zetascan endpoint
+var TestItems = []TestItem{
+	// Records that will pass (whitelist)
+	{Item: "okdomain.org", Listed: false},
+	{Item: "127.9.9.4", Listed: false},
+
+	// Records that will fail (blacklisted)
+	{Item: "baddomain.org", Listed: true},
+	{Item: "127.9.9.1", Listed: true},
+	{Item: "127.9.9.2", Listed: true},
+	{Item: "127.9.9.3", Listed: true},
+}
+
+// Methods are the query methods supported by Query
+var Methods = []string{"http", "text", "json", "jsonx", "dns"}
+
+// Health is the overall status of a diagnostics report
+type Health string
+
+const (
+	Healthy   Health = "healthy"   // Every check passed
+	Degraded  Health = "degraded"  // Some checks failed
+	Unhealthy Health = "unhealthy" // No check passed
+)
+
+// Check is the result of one test item via one method
+type Check struct {
+	Item      string  `json:"item"`
+	Method    string  `json:"method"`
+	Expected  string  `json:"expected"`
+	Actual    string  `json:"actual"`
+	Pass      bool    `json:"pass"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Latency percentiles in milliseconds
+type Latency struct {
+	MinMs float64 `json:"minMs"`
+	P50Ms float64 `json:"p50Ms"`
+	P90Ms float64 `json:"p90Ms"`
+	P99Ms float64 `json:"p99Ms"`
+	MaxMs float64 `json:"maxMs"`
+}
+
+// MethodReport summarises the checks for one method
+type MethodReport struct {
+	Method   string  `json:"method"`
+	Endpoint string  `json:"endpoint"`
+	Status   Health  `json:"status"`
+	Passed   int     `json:"passed"`
+	Failed   int     `json:"failed"`
+	Errors   int     `json:"errors"`
+	Latency  Latency `json:"latency"`
+	Checks   []Check `json:"checks"`
+}
+
+// Report is the result of Diagnose
+type Report struct {
+	Status     Health         `json:"status"`
+	Started    time.Time      `json:"started"`
+	DurationMs float64        `json:"durationMs"`
+	Methods    []MethodReport `json:"methods"`
+}
+
+// Diagnose runs the documented test items via each method (all methods if none
+// specified), reporting pass/fail per item, latency and overall health.
+// An error is only returned for an unknown method, or if ctx is done.
+func (myapi Api) Diagnose(ctx context.Context, methods ...string) (report *Report, err error) {
+
+	if len(methods) == 0 {
+		methods = Methods
+	}
+
+	for _, method := range methods {
+		if !validMethod(method) {
+			return nil, errors.New("Unknown query method: " + method)
+		}
+	}
+
+	report = &Report{Started: time.Now()}
+
+	for _, method := range methods {
+
+		api := myapi.only(method)
+
+		mr := MethodReport{Method: method, Endpoint: api.endpointFor(method)}
+
+		for _, test := range TestItems {
+
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+
+			mr.Checks = append(mr.Checks, api.check(ctx, test))
+		}
+
+		mr.summarise()
+		report.Methods = append(report.Methods, mr)
+	}
+
+	report.DurationMs = millis(time.Since(report.Started))
+	report.Status = report.health()
+
+	return report, nil
+}
+
+// check runs a single test item
+func (myapi Api) check(ctx context.Context, test TestItem) (c Check) {
+
+	c = Check{Item: test.Item, Method: myapi.ApiMethod, Expected: verdict(test.Listed)}
+
+	startTime := time.Now()
+	response, err := myapi.QueryContext(ctx, test.Item)
+	c.LatencyMs = millis(time.Since(startTime))
+
+	if err != nil {
+		c.Actual = "error"
+		c.Error = err.Error()
+		return c
+	}
+
+	listed := len(response.Results) > 0 && myapi.IsBlackList(&response)
+
+	c.Actual = verdict(listed)
+	c.Pass = listed == test.Listed
+
+	return c
+}
+
+// endpointFor return the endpoint used by a method
+func (myapi Api) endpointFor(method string) string {
+
+	if method == "dns" {
+		return "dns://" + myapi.dnsServer()
+	}
+
+	return myapi.GetEndpoint()
+}
+
+func (mr *MethodReport) summarise() {
+
+	var latencies []float64
+
+	for _, c := range mr.Checks {
+
+		switch {
+		case c.Error != "":
+			mr.Errors++
+		case c.Pass:
+			mr.Passed++
+		default:
+			mr.Failed++
+		}
+
+		latencies = append(latencies, c.LatencyMs)
+	}
+
+	mr.Latency = percentiles(latencies)
+
+	switch {
+	case mr.Passed == len(mr.Checks):
+		mr.Status = Healthy
+	case mr.Passed == 0:
+		mr.Status = Unhealthy
+	default:
+		mr.Status = Degraded
+	}
+}
+
+func (r *Report) health() Health {
+
+	healthy, unhealthy := 0, 0
+
+	for _, mr := range r.Methods {
+		switch mr.Status {
+		case Healthy:
+			healthy++
+		case Unhealthy:
+			unhealthy++
+		}
+	}
+
+	switch {
+	case healthy == len(r.Methods):
+		return Healthy
+	case unhealthy == len(r.Methods):
+		return Unhealthy
+	}
+
+	return Degraded
+}
+
+// WriteText renders the report as a human readable table
+func (r *Report) WriteText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Zetascan diagnostics: %s (%.0fms)\n\n", r.Status, r.DurationMs)
+
+	for _, mr := range r.Methods {
+
+		fmt.Fprintf(tw, "%s via %s: %s, %d passed, %d failed, %d errors\n", mr.Method, mr.Endpoint, mr.Status, mr.Passed, mr.Failed, mr.Errors)
+		fmt.Fprintf(tw, "  latency min %.1fms, p50 %.1fms, p90 %.1fms, p99 %.1fms, max %.1fms\n",
+			mr.Latency.MinMs, mr.Latency.P50Ms, mr.Latency.P90Ms, mr.Latency.P99Ms, mr.Latency.MaxMs)
+
+		fmt.Fprintln(tw, "  ITEM\tEXPECTED\tACTUAL\tRESULT\tLATENCY\t")
+
+		for _, c := range mr.Checks {
+
+			result := "pass"
+			if c.Error != "" {
+				result = "error: " + c.Error
+			} else if !c.Pass {
+				result = "FAIL"
+			}
+
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%.1fms\t\n", c.Item, c.Expected, c.Actual, result, c.LatencyMs)
+		}
+
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON renders the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
+
+// JUnit XML format, as understood by most CI dashboards
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+// WriteJUnit renders the report as JUnit XML, a test suite per method
+func (r *Report) WriteJUnit(w io.Writer) error {
+
+	suites := junitSuites{Name: "zetascan", Time: seconds(r.DurationMs)}
+
+	for _, mr := range r.Methods {
+
+		suite := junitSuite{
+			Name:      "zetascan." + mr.Method,
+			Tests:     len(mr.Checks),
+			Failures:  mr.Failed,
+			Errors:    mr.Errors,
+			Timestamp: r.Started.UTC().Format("2006-01-02T15:04:05"),
+		}
+
+		var total float64
+
+		for _, c := range mr.Checks {
+
+			tc := junitCase{Name: c.Item, Classname: suite.Name, Time: seconds(c.LatencyMs)}
+
+			if c.Error != "" {
+				tc.Error = &junitMessage{Message: c.Error, Type: "error"}
+			} else if !c.Pass {
+				tc.Failure = &junitMessage{Message: "expected " + c.Expected + ", got " + c.Actual, Type: "verdict"}
+			}
+
+			total += c.LatencyMs
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		suite.Time = seconds(total)
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// percentiles calculates nearest-rank latency percentiles
+func percentiles(values []float64) (l Latency) {
+
+	if len(values) == 0 {
+		return l
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		return sorted[max(0, min(i, len(sorted)-1))]
+	}
+
+	return Latency{
+		MinMs: sorted[0],
+		P50Ms: rank(50),
+		P90Ms: rank(90),
+		P99Ms: rank(99),
+		MaxMs: sorted[len(sorted)-1],
+	}
+}
+
+func validMethod(method string) bool {
+
+	for _, m := range Methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+func verdict(listed bool) string {
+
+	if listed {
+		return "listed"
+	}
+
+	return "not listed"
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func seconds(ms float64) string {
+	return fmt.Sprintf("%.3f", ms/1000)
+}
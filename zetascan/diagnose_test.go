@@ -0,0 +1,261 @@
+package zetascan_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+func TestDiagnose(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	report, err := emulator.Api("").Diagnose(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != zetascan.Healthy || len(report.Methods) != len(zetascan.Methods) {
+		t.Fatalf("status %s, %d methods, want healthy via %d", report.Status, len(report.Methods), len(zetascan.Methods))
+	}
+
+	for _, mr := range report.Methods {
+
+		if mr.Status != zetascan.Healthy || mr.Passed != len(zetascan.TestItems) || mr.Failed != 0 || mr.Errors != 0 {
+			t.Errorf("%s: %s, %d passed, %d failed, %d errors", mr.Method, mr.Status, mr.Passed, mr.Failed, mr.Errors)
+		}
+
+		if mr.Latency.MinMs > mr.Latency.P50Ms || mr.Latency.P50Ms > mr.Latency.MaxMs {
+			t.Errorf("%s: latency %+v out of order", mr.Method, mr.Latency)
+		}
+	}
+
+	if _, err := emulator.Api("").Diagnose(context.Background(), "smtp"); err == nil {
+		t.Error("Diagnose accepted an unknown method")
+	}
+}
+
+// diagnoseFailing return a report where json fails every check, and dns answers
+// 127.9.9.3 with a whitelist code
+func diagnoseFailing(t *testing.T) *zetascan.Report {
+
+	fixtures := zetascantest.DefaultFixtures()
+	fixtures["127.9.9.3"] = zetascantest.Fixture{Found: true, Score: 0.8, Sources: []string{"shSBL"}, DNS: []string{"127.8.0.1"}}
+
+	emulator := zetascantest.NewServer(fixtures)
+	t.Cleanup(func() { emulator.Close() })
+
+	emulator.InjectMethod("json", zetascantest.Burst(http.StatusForbidden, -1))
+
+	report, err := emulator.Api("").Diagnose(context.Background(), "json", "dns", "jsonx")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return report
+}
+
+func TestDiagnoseFailures(t *testing.T) {
+
+	report := diagnoseFailing(t)
+
+	if report.Status != zetascan.Degraded {
+		t.Errorf("status %s, want degraded", report.Status)
+	}
+
+	want := []struct {
+		status                  zetascan.Health
+		passed, failed, errored int
+	}{
+		{zetascan.Unhealthy, 0, 0, 6},
+		{zetascan.Degraded, 5, 1, 0},
+		{zetascan.Healthy, 6, 0, 0},
+	}
+
+	for i, mr := range report.Methods {
+
+		w := want[i]
+
+		if mr.Status != w.status || mr.Passed != w.passed || mr.Failed != w.failed || mr.Errors != w.errored {
+			t.Errorf("%s: %s, %d passed, %d failed, %d errors, want %s, %d, %d, %d",
+				mr.Method, mr.Status, mr.Passed, mr.Failed, mr.Errors, w.status, w.passed, w.failed, w.errored)
+		}
+	}
+
+	for _, c := range report.Methods[1].Checks {
+		if c.Item == "127.9.9.3" && (c.Pass || c.Expected != "listed" || c.Actual != "not listed") {
+			t.Errorf("dns check %+v, want a failure", c)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+
+	if l := zetascan.Percentiles(nil); l != (zetascan.Latency{}) {
+		t.Errorf("no values = %+v", l)
+	}
+
+	if l := zetascan.Percentiles([]float64{7}); l != (zetascan.Latency{MinMs: 7, P50Ms: 7, P90Ms: 7, P99Ms: 7, MaxMs: 7}) {
+		t.Errorf("one value = %+v", l)
+	}
+
+	// 1 to 100 in reverse, nearest rank is the value itself
+	var values []float64
+	for i := 100; i > 0; i-- {
+		values = append(values, float64(i))
+	}
+
+	if l := zetascan.Percentiles(values); l != (zetascan.Latency{MinMs: 1, P50Ms: 50, P90Ms: 90, P99Ms: 99, MaxMs: 100}) {
+		t.Errorf("1 to 100 = %+v", l)
+	}
+
+	// Ranks round up, 50% of 5 values is the 3rd
+	if l := zetascan.Percentiles([]float64{10, 20, 30, 40, 50}); l != (zetascan.Latency{MinMs: 10, P50Ms: 30, P90Ms: 50, P99Ms: 50, MaxMs: 50}) {
+		t.Errorf("5 values = %+v", l)
+	}
+
+	// The input is not reordered
+	if values[0] != 100 {
+		t.Error("values sorted in place")
+	}
+}
+
+func TestReportWriteText(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	if err := diagnoseFailing(t).WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"Zetascan diagnostics: degraded",
+		"json via http://",
+		"unhealthy, 0 passed, 0 failed, 6 errors",
+		"dns via dns://",
+		"error: ",
+		"FAIL",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text report missing %q:\n%s", want, &buf)
+		}
+	}
+}
+
+func TestReportWriteJSON(t *testing.T) {
+
+	report := diagnoseFailing(t)
+
+	var buf bytes.Buffer
+
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded zetascan.Report
+
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Status != report.Status || len(decoded.Methods) != 3 || decoded.Methods[0].Checks[0].Error == "" {
+		t.Errorf("decoded report %+v", decoded)
+	}
+}
+
+func TestReportWriteJUnit(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	if err := diagnoseFailing(t).WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Well-formed throughout
+	dec := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+
+	for {
+		_, err := dec.Token()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("malformed XML: %v\n%s", err, &buf)
+		}
+	}
+
+	var suites struct {
+		XMLName  xml.Name `xml:"testsuites"`
+		Tests    int      `xml:"tests,attr"`
+		Failures int      `xml:"failures,attr"`
+		Errors   int      `xml:"errors,attr"`
+		Suites   []struct {
+			Name  string `xml:"name,attr"`
+			Tests int    `xml:"tests,attr"`
+			Cases []struct {
+				Name    string `xml:"name,attr"`
+				Failure *struct {
+					Message string `xml:"message,attr"`
+				} `xml:"failure"`
+				Error *struct {
+					Message string `xml:"message,attr"`
+				} `xml:"error"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatal(err)
+	}
+
+	if suites.Tests != 18 || suites.Failures != 1 || suites.Errors != 6 || len(suites.Suites) != 3 {
+		t.Fatalf("%d tests, %d failures, %d errors in %d suites", suites.Tests, suites.Failures, suites.Errors, len(suites.Suites))
+	}
+
+	if suites.Suites[0].Name != "zetascan.json" || suites.Suites[0].Cases[0].Error == nil {
+		t.Errorf("json suite %+v, want errors", suites.Suites[0])
+	}
+
+	for _, tc := range suites.Suites[1].Cases {
+		if (tc.Failure != nil) != (tc.Name == "127.9.9.3") {
+			t.Errorf("dns case %s failure %+v", tc.Name, tc.Failure)
+		}
+	}
+
+	if f := suites.Suites[1].Cases[5].Failure; f == nil || f.Message != "expected listed, got not listed" {
+		t.Errorf("dns failure %+v", f)
+	}
+}
+
+// Diagnose queries the endpoint, not the cache
+func TestDiagnoseUncached(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.Cache = zetascan.NewMemoryCache(100)
+
+	for range 2 {
+		if _, err := myzetascan.Diagnose(context.Background(), "json"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := emulator.Requests("127.9.9.1"); n != 2 {
+		t.Errorf("%d requests for 127.9.9.1, want 2", n)
+	}
+}
@@ -0,0 +1,492 @@
+// Package diskcache is a persistent zetascan result cache in an embedded bbolt
+// file, so results survive restarts and are shared by the processes on a host.
+//
+// The file is opened for each operation and locked while in use, so several
+// processes (e.g batch jobs and CLI runs) can share it, waiting up to
+// Config.LockTimeout for each other. Expired entries, and the oldest entries
+// beyond the size limits, are removed by Compact, which runs automatically
+// when the file grows beyond Config.MaxBytes, at most once every
+// Config.CompactInterval.
+//
+//	cache, err := diskcache.Open(diskcache.DefaultPath(), diskcache.DefaultConfig)
+//	defer cache.Close()
+//
+//	myzetascan.Cache = cache
+//	myzetascan.CacheTTL = 24 * time.Hour
+package diskcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Config limits the size of the cache
+type Config struct {
+	// Compact keeps at most MaxEntries, removing the oldest first, 0 for no limit
+	MaxEntries int
+
+	// Set compacts the file when it grows beyond MaxBytes, 0 for no limit.
+	// Compact removes the oldest entries until they take 3/4 of MaxBytes.
+	MaxBytes int64
+
+	// Minimum time between the compactions run by Set
+	CompactInterval time.Duration
+
+	// Maximum time to wait for another process using the file
+	LockTimeout time.Duration
+}
+
+// DefaultConfig keeps up to 1,000,000 entries in up to 256MB, compacting at
+// most every 5 minutes
+var DefaultConfig = Config{
+	MaxEntries:      1000000,
+	MaxBytes:        256 << 20,
+	CompactInterval: 5 * time.Minute,
+	LockTimeout:     10 * time.Second,
+}
+
+var resultsBucket = []byte("results")
+
+// Cache is a zetascan.Cache in a bbolt file
+type Cache struct {
+	path   string
+	config Config
+
+	lastCompact atomic.Int64 // Unix nanoseconds of the last compaction by Set
+}
+
+// Stats summarise the entries of a cache
+type Stats struct {
+	Path    string         `json:"path"`
+	Bytes   int64          `json:"bytes"`
+	Entries int            `json:"entries"`
+	Expired int            `json:"expired"`
+	Methods map[string]int `json:"methods"`
+	Oldest  time.Time      `json:"oldest,omitempty"`
+	Newest  time.Time      `json:"newest,omitempty"`
+}
+
+// DefaultPath return the cache file used when none is specified,
+// $XDG_CACHE_HOME/zetascan/results.db or ~/.cache/zetascan/results.db
+func DefaultPath() string {
+
+	dir, err := os.UserCacheDir()
+
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "zetascan", "results.db")
+}
+
+// Open return the cache in a file, creating it and its directory if needed
+func Open(path string, config Config) (*Cache, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{path: path, config: config}
+
+	err := c.update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(resultsBucket)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.lastCompact.Store(time.Now().UnixNano())
+
+	return c, nil
+}
+
+// OpenDefault return the cache in a file with the DefaultConfig, as a
+// zetascan.Cache, e.g for config.RegisterCache
+func OpenDefault(path string) (zetascan.Cache, error) {
+
+	c, err := Open(path, DefaultConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Path return the cache file
+func (c *Cache) Path() string {
+	return c.path
+}
+
+// Close implements io.Closer, like the other cache backends. The file is only
+// open during each operation, so there is nothing to release.
+func (c *Cache) Close() error {
+	return nil
+}
+
+// Get implements zetascan.Cache
+func (c *Cache) Get(ctx context.Context, key string) (entry zetascan.CacheEntry, ok bool, err error) {
+
+	err = c.view(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket(resultsBucket)
+
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get([]byte(key))
+
+		if v == nil {
+			return nil
+		}
+
+		ok = true
+		return json.Unmarshal(v, &entry)
+	})
+
+	return entry, ok && err == nil, err
+}
+
+// Set implements zetascan.Cache, compacting the file if larger than MaxBytes
+// and not compacted in the last CompactInterval
+func (c *Cache) Set(ctx context.Context, key string, entry zetascan.CacheEntry) error {
+
+	v, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	var size int64
+
+	err = c.update(func(tx *bolt.Tx) error {
+
+		b, err := tx.CreateBucketIfNotExists(resultsBucket)
+
+		if err != nil {
+			return err
+		}
+
+		size = tx.Size()
+
+		return b.Put([]byte(key), v)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if c.config.MaxBytes <= 0 || size <= c.config.MaxBytes {
+		return nil
+	}
+
+	// Only one Set of this Cache compacts, and not again until CompactInterval has passed
+	last := c.lastCompact.Load()
+
+	if time.Since(time.Unix(0, last)) < c.config.CompactInterval || !c.lastCompact.CompareAndSwap(last, time.Now().UnixNano()) {
+		return nil
+	}
+
+	_, err = c.Compact()
+	return err
+}
+
+// Each calls fn with every entry in key order, stopping if fn returns an error
+func (c *Cache) Each(fn func(key string, entry zetascan.CacheEntry) error) error {
+
+	return c.view(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket(resultsBucket)
+
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+
+			var entry zetascan.CacheEntry
+
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			return fn(string(k), entry)
+		})
+	})
+}
+
+// Purge removes the entries for which match returns true, or every entry if
+// match is nil, returning the number removed
+func (c *Cache) Purge(match func(key string, entry zetascan.CacheEntry) bool) (removed int, err error) {
+
+	err = c.update(func(tx *bolt.Tx) error {
+
+		b, err := tx.CreateBucketIfNotExists(resultsBucket)
+
+		if err != nil {
+			return err
+		}
+
+		if match == nil {
+			removed = b.Stats().KeyN
+
+			if err := tx.DeleteBucket(resultsBucket); err != nil {
+				return err
+			}
+
+			_, err := tx.CreateBucket(resultsBucket)
+			return err
+		}
+
+		var keys [][]byte
+
+		err = b.ForEach(func(k, v []byte) error {
+
+			var entry zetascan.CacheEntry
+
+			// Remove entries that can't be read, along with those matched
+			if json.Unmarshal(v, &entry) != nil || match(string(k), entry) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		removed = len(keys)
+		return nil
+	})
+
+	return removed, err
+}
+
+// Compact removes expired entries (that can't be served stale), then the oldest entries beyond the size
+// limits, and rewrites the file to release the free space. It return the
+// number of entries removed.
+func (c *Cache) Compact() (removed int, err error) {
+
+	now := time.Now()
+
+	type stored struct {
+		key    []byte
+		stored time.Time
+		size   int64
+	}
+
+	db, err := c.open(false)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+
+		var live []stored
+		var expired [][]byte
+		var size int64
+
+		b, err := tx.CreateBucketIfNotExists(resultsBucket)
+
+		if err != nil {
+			return err
+		}
+
+		err = b.ForEach(func(k, v []byte) error {
+
+			var entry zetascan.CacheEntry
+
+			if json.Unmarshal(v, &entry) != nil || !now.Before(entry.Retain()) {
+				expired = append(expired, append([]byte(nil), k...))
+				return nil
+			}
+
+			live = append(live, stored{key: append([]byte(nil), k...), stored: entry.Stored, size: int64(len(k) + len(v))})
+			size += int64(len(k) + len(v))
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// Oldest first
+		sort.Slice(live, func(i, j int) bool { return live[i].stored.Before(live[j].stored) })
+
+		for len(live) > 0 && ((c.config.MaxEntries > 0 && len(live) > c.config.MaxEntries) ||
+			(c.config.MaxBytes > 0 && size > c.config.MaxBytes*3/4)) {
+
+			expired = append(expired, live[0].key)
+			size -= live[0].size
+			live = live[1:]
+		}
+
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		removed = len(expired)
+		return nil
+	})
+
+	if err != nil {
+		return removed, err
+	}
+
+	// Rewrite the file while holding the lock, processes waiting on the old
+	// file reopen the new one (see open). A failed rewrite leaves the old file.
+	tmp := c.path + ".compact"
+	os.Remove(tmp)
+
+	dst, err := bolt.Open(tmp, 0600, &bolt.Options{Timeout: c.config.LockTimeout})
+
+	if err != nil {
+		return removed, err
+	}
+
+	if err := bolt.Compact(dst, db, 64<<20); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return removed, err
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return removed, err
+	}
+
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return removed, err
+	}
+
+	return removed, nil
+}
+
+// Stats summarise the entries
+func (c *Cache) Stats() (s Stats, err error) {
+
+	now := time.Now()
+	s = Stats{Path: c.path, Methods: make(map[string]int)}
+
+	err = c.Each(func(key string, entry zetascan.CacheEntry) error {
+
+		s.Entries++
+		s.Methods[entry.Method]++
+
+		if entry.Expired(now) {
+			s.Expired++
+		}
+
+		if s.Oldest.IsZero() || entry.Stored.Before(s.Oldest) {
+			s.Oldest = entry.Stored
+		}
+
+		if entry.Stored.After(s.Newest) {
+			s.Newest = entry.Stored
+		}
+
+		return nil
+	})
+
+	if info, serr := os.Stat(c.path); serr == nil {
+		s.Bytes = info.Size()
+	}
+
+	return s, err
+}
+
+// view runs a read-only transaction
+func (c *Cache) view(fn func(tx *bolt.Tx) error) error {
+
+	db, err := c.open(true)
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	return db.View(fn)
+}
+
+// update runs a read-write transaction
+func (c *Cache) update(fn func(tx *bolt.Tx) error) error {
+
+	db, err := c.open(false)
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	return db.Update(fn)
+}
+
+// open locks and opens the file, shared if read only, waiting up to
+// LockTimeout for another process. If the file was replaced by a compaction
+// while waiting for the lock, the new file is opened instead.
+func (c *Cache) open(readOnly bool) (*bolt.DB, error) {
+
+	deadline := time.Now().Add(c.config.LockTimeout)
+
+	for {
+		timeout := time.Until(deadline)
+
+		if timeout <= 0 {
+			return nil, errors.New("Timed out waiting for the cache lock: " + c.path)
+		}
+
+		// A read-only open of a missing file fails, create it instead
+		before, err := os.Stat(c.path)
+
+		if err != nil {
+			readOnly = false
+		}
+
+		db, err := bolt.Open(c.path, 0600, &bolt.Options{Timeout: timeout, ReadOnly: readOnly})
+
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, errors.New("Timed out waiting for the cache lock: " + c.path)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		after, err := os.Stat(c.path)
+
+		if err == nil && (before == nil || os.SameFile(before, after)) {
+			return db, nil
+		}
+
+		db.Close()
+	}
+}
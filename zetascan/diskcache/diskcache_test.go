@@ -0,0 +1,264 @@
+package diskcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// entry return an entry stored now, expiring after ttl
+func entry(ttl time.Duration) zetascan.CacheEntry {
+
+	now := time.Now()
+
+	return zetascan.CacheEntry{
+		Record:  zetascan.JsonRecord{Results: []zetascan.JsonResult{{Item: "127.9.9.1", Found: true, Score: 0.95}}},
+		Method:  "json",
+		Stored:  now,
+		Expires: now.Add(ttl),
+	}
+}
+
+func TestGetSet(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "results.db")
+	ctx := context.Background()
+
+	c, err := Open(path, DefaultConfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set(ctx, "json:127.9.9.1", entry(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := c.Get(ctx, "json:192.0.2.1"); ok || err != nil {
+		t.Errorf("missing key: ok = %v, err = %v", ok, err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries survive reopening the file
+	c, err = Open(path, DefaultConfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	e, ok, err := c.Get(ctx, "json:127.9.9.1")
+
+	if err != nil || !ok || e.Method != "json" || e.Record.Results[0].Score != 0.95 {
+		t.Errorf("Get = %+v, %v, %v", e, ok, err)
+	}
+}
+
+// Caches of the same file, as in separate processes, share the entries
+func TestShared(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "results.db")
+	ctx := context.Background()
+
+	config := DefaultConfig
+	config.MaxEntries = 5
+
+	a, err := Open(path, config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a.Close()
+
+	b, err := Open(path, config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	var wg sync.WaitGroup
+
+	for i, c := range []*Cache{a, b} {
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range 10 {
+				if err := c.Set(ctx, fmt.Sprintf("json:192.0.%d.%d", i, j), entry(time.Hour)); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for i, c := range []*Cache{a, b} {
+		for _, key := range []string{"json:192.0.0.9", "json:192.0.1.9"} {
+			if _, ok, err := c.Get(ctx, key); !ok || err != nil {
+				t.Errorf("cache %d Get(%s): ok = %v, err = %v", i, key, ok, err)
+			}
+		}
+	}
+
+	// A compaction by one replaces the file under the other
+	if _, err := a.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats, err := b.Stats(); err != nil || stats.Entries != 5 {
+		t.Errorf("stats after Compact = %+v, %v", stats, err)
+	}
+
+	if err := b.Set(ctx, "json:127.9.9.1", entry(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := a.Get(ctx, "json:127.9.9.1"); !ok || err != nil {
+		t.Errorf("Get after Compact: ok = %v, err = %v", ok, err)
+	}
+}
+
+// A failed rewrite leaves the cache usable
+func TestCompactFailure(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "results.db")
+	ctx := context.Background()
+
+	c, err := Open(path, DefaultConfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if err := c.Set(ctx, "json:127.9.9.1", entry(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rewrite can't create its temporary file
+	if err := os.MkdirAll(filepath.Join(path+".compact", "busy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Compact(); err == nil {
+		t.Fatal("Compact succeeded without its temporary file")
+	}
+
+	if _, ok, err := c.Get(ctx, "json:127.9.9.1"); !ok || err != nil {
+		t.Errorf("Get after a failed Compact: ok = %v, err = %v", ok, err)
+	}
+
+	if err := c.Set(ctx, "json:127.9.9.3", entry(time.Hour)); err != nil {
+		t.Errorf("Set after a failed Compact: %v", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+
+	ctx := context.Background()
+
+	config := DefaultConfig
+	config.MaxEntries = 10
+
+	c, err := Open(filepath.Join(t.TempDir(), "results.db"), config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	for i := range 20 {
+		if err := c.Set(ctx, fmt.Sprintf("json:192.0.2.%d", i), entry(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Set(ctx, "json:expired", entry(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := c.Compact()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The expired entry and the 10 oldest
+	if removed != 11 {
+		t.Errorf("removed %d, want 11", removed)
+	}
+
+	// The rewritten file is used
+	if _, ok, err := c.Get(ctx, "json:192.0.2.19"); !ok || err != nil {
+		t.Errorf("newest entry after Compact: ok = %v, err = %v", ok, err)
+	}
+
+	if stats, err := c.Stats(); err != nil || stats.Entries != 10 {
+		t.Errorf("stats = %+v, %v", stats, err)
+	}
+}
+
+// Set compacts a file beyond MaxBytes at most once every CompactInterval
+func TestCompactInterval(t *testing.T) {
+
+	ctx := context.Background()
+
+	config := DefaultConfig
+	config.MaxBytes = 1
+	config.CompactInterval = time.Hour
+
+	c, err := Open(filepath.Join(t.TempDir(), "results.db"), config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	// Not since Open
+	for i := range 5 {
+		if err := c.Set(ctx, fmt.Sprintf("json:192.0.2.%d", i), entry(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats, _ := c.Stats(); stats.Entries != 5 {
+		t.Errorf("%d entries, want 5 before CompactInterval", stats.Entries)
+	}
+
+	// Once the interval has passed, then not again
+	c.lastCompact.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+
+	if err := c.Set(ctx, "json:192.0.2.5", entry(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats, _ := c.Stats(); stats.Entries != 0 {
+		t.Errorf("%d entries, want 0 after compacting to 3/4 of 1 byte", stats.Entries)
+	}
+
+	if err := c.Set(ctx, "json:192.0.2.6", entry(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats, _ := c.Stats(); stats.Entries != 1 {
+		t.Errorf("%d entries, want 1 within CompactInterval", stats.Entries)
+	}
+}
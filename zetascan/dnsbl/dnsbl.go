@@ -0,0 +1,575 @@
+// Package dnsbl serves a local DNSBL zone, e.g zs.local, answering from
+// zetascan lookups, for software that only speaks DNSBL such as Postfix
+// reject_rbl_client, SpamAssassin or rspamd.
+//
+// Reversed IPv4 (1.9.9.127.zs.local), reversed IPv6 nibbles and domains
+// (baddomain.org.zs.local) are looked up via a zetascan.Client, over its
+// method and cache. Listed items are answered with an A record for each Code
+// they match, and a TXT record with the reason. Other items are NXDOMAIN, and
+// failed lookups SERVFAIL.
+//
+//	client := zetascan.NewClient(myzetascan, zetascan.ClientConfig{CacheTTL: time.Hour})
+//	s := dnsbl.NewServer(client)
+//	s.Config.Zone = "zs.local"
+//
+//	log.Fatal(s.ListenAndServe("127.0.0.1:5353"))
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Code is the A record answered for the items it matches, listed items by
+// default. Sources and MinScore need a method that returns them, i.e not dns.
+type Code struct {
+	Address netip.Addr
+
+	// Match whitelisted items instead of listed ones
+	Whitelisted bool
+
+	// Match items listed in any of these sources, any source if empty
+	Sources []string
+
+	// Match items listed at or above this score, 0 for any score
+	MinScore float64
+}
+
+// DefaultCodes answers 127.0.0.2 for any listed item, and also 127.0.0.3 at
+// scores of 0.9+, e.g for reject_rbl_client zs.local=127.0.0.3
+var DefaultCodes = []Code{
+	{Address: netip.AddrFrom4([4]byte{127, 0, 0, 2})},
+	{Address: netip.AddrFrom4([4]byte{127, 0, 0, 3}), MinScore: 0.9},
+}
+
+// Match return if a result is answered with the code
+func (c Code) Match(result zetascan.JsonResult) bool {
+
+	if c.Whitelisted {
+		return result.Wl
+	}
+
+	if !result.Found || result.Wl {
+		return false
+	}
+
+	if c.MinScore != 0 && result.Score < c.MinScore {
+		return false
+	}
+
+	if len(c.Sources) == 0 {
+		return true
+	}
+
+	for _, want := range c.Sources {
+		for _, source := range result.Sources {
+			if strings.EqualFold(want, source) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// String return the code as parsed by ParseCode
+func (c Code) String() string {
+
+	var conditions []string
+
+	if c.Whitelisted {
+		conditions = append(conditions, "whitelisted")
+	}
+
+	if len(c.Sources) > 0 {
+		conditions = append(conditions, strings.Join(c.Sources, "|"))
+	}
+
+	if c.MinScore != 0 {
+		conditions = append(conditions, "score:"+strconv.FormatFloat(c.MinScore, 'g', -1, 64))
+	}
+
+	if len(conditions) == 0 {
+		return c.Address.String()
+	}
+
+	return c.Address.String() + "=" + strings.Join(conditions, "+")
+}
+
+// ParseCode parses an address and what it matches, joined by +:
+//
+//	127.0.0.2                  any listed item
+//	127.0.0.3=score:0.9        listed at a score of 0.9+
+//	127.0.0.4=shXBL|shCBL      listed in any of the sources
+//	127.0.0.5=shSBL+score:0.5  both
+//	127.0.1.1=whitelisted      whitelisted items
+func ParseCode(s string) (code Code, err error) {
+
+	address, match, _ := strings.Cut(strings.TrimSpace(s), "=")
+
+	if code.Address, err = netip.ParseAddr(address); err != nil || !code.Address.Is4() {
+		return code, errors.New("Invalid code address: " + s)
+	}
+
+	if match == "" || match == "listed" {
+		return code, nil
+	}
+
+	for _, condition := range strings.Split(match, "+") {
+
+		switch {
+		case condition == "whitelisted":
+			code.Whitelisted = true
+
+		case strings.HasPrefix(condition, "score:"):
+
+			if code.MinScore, err = strconv.ParseFloat(strings.TrimPrefix(condition, "score:"), 64); err != nil {
+				return code, errors.New("Invalid code score: " + s)
+			}
+
+		case condition != "":
+			code.Sources = append(code.Sources, strings.Split(condition, "|")...)
+
+		default:
+			return code, errors.New("Invalid code: " + s)
+		}
+	}
+
+	if code.Whitelisted && (len(code.Sources) > 0 || code.MinScore != 0) {
+		return code, errors.New("Whitelisted codes can't match sources or scores: " + s)
+	}
+
+	return code, nil
+}
+
+// ParseCodes parses a comma separated list of codes, see ParseCode
+func ParseCodes(s string) (codes []Code, err error) {
+
+	for _, field := range strings.Split(s, ",") {
+
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+
+		code, err := ParseCode(field)
+
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	if len(codes) == 0 {
+		return nil, errors.New("No codes")
+	}
+
+	return codes, nil
+}
+
+// Config sets the zone served, and the codes and TTLs answered
+type Config struct {
+	// The zone served, e.g zs.local, queries outside it are refused
+	Zone string
+
+	// Codes answered for an item, each that matches in order
+	Codes []Code
+
+	// TTL of listed answers, and of NXDOMAIN and empty answers (the SOA minimum)
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	// Maximum time for a lookup, resolvers usually retry after about 5s
+	Timeout time.Duration
+}
+
+// DefaultConfig serves zs.local with the DefaultCodes
+var DefaultConfig = Config{
+	Zone:        "zs.local",
+	Codes:       DefaultCodes,
+	TTL:         5 * time.Minute,
+	NegativeTTL: time.Minute,
+	Timeout:     4 * time.Second,
+}
+
+// Server answers DNSBL queries for a zone
+type Server struct {
+	Client *zetascan.Client
+	Config Config
+
+	// Logger receives failed lookups, and each answer at debug level, if set
+	Logger *slog.Logger
+
+	mu      sync.Mutex
+	servers []*dns.Server
+	closed  bool
+}
+
+// NewServer return a DNSBL server using the default configuration
+func NewServer(client *zetascan.Client) *Server {
+	return &Server{Client: client, Config: DefaultConfig}
+}
+
+// ListenAndServe serves UDP and TCP on an address, e.g "127.0.0.1:5353"
+func (s *Server) ListenAndServe(address string) error {
+
+	pc, err := net.ListenPacket("udp", address)
+
+	if err != nil {
+		return err
+	}
+
+	// Listen for TCP on the same port as UDP, e.g for port 0
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	errs := make(chan error, 2)
+
+	go func() { errs <- s.ServePacket(pc) }()
+	go func() { errs <- s.Serve(l) }()
+
+	err = <-errs
+
+	// Stop the other if one fails
+	if err != nil {
+		s.Close()
+	}
+
+	if err2 := <-errs; err == nil {
+		err = err2
+	}
+
+	return err
+}
+
+// ServePacket serves UDP queries on pc until the server is closed
+func (s *Server) ServePacket(pc net.PacketConn) error {
+	return s.serve(&dns.Server{PacketConn: pc, Handler: s})
+}
+
+// Serve serves TCP queries on l until the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(&dns.Server{Listener: l, Handler: s})
+}
+
+// serve runs a DNS server, tracked for Shutdown
+func (s *Server) serve(server *dns.Server) error {
+
+	// Track the server once started, it can't be shut down before
+	server.NotifyStartedFunc = func() {
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.closed {
+			go server.Shutdown()
+			return
+		}
+
+		s.servers = append(s.servers, server)
+	}
+
+	err := server.ActivateAndServe()
+
+	// A closed listener is a shutdown
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown stops all servers, waiting for queries being answered until ctx
+// is done. The server can't be used after.
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	s.mu.Lock()
+	servers := s.servers
+	s.servers = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	var err error
+
+	for _, server := range servers {
+		if serr := server.ShutdownContext(ctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return err
+}
+
+// Close stops all servers at once
+func (s *Server) Close() error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return s.Shutdown(ctx)
+}
+
+// ServeDNS implements dns.Handler
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	if r.Opcode != dns.OpcodeQuery {
+		m.SetRcode(r, dns.RcodeNotImplemented)
+		s.write(w, r, m)
+		return
+	}
+
+	if len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeFormatError)
+		s.write(w, r, m)
+		return
+	}
+
+	q := r.Question[0]
+	zone := s.zone()
+	name := strings.ToLower(q.Name)
+
+	if q.Qclass != dns.ClassINET || !dns.IsSubDomain(zone, name) {
+		m.Authoritative = false
+		m.SetRcode(r, dns.RcodeRefused)
+		s.write(w, r, m)
+		return
+	}
+
+	if name == zone {
+		s.answerApex(m, q)
+		s.write(w, r, m)
+		return
+	}
+
+	item, err := zetascan.ParseDNSName(strings.TrimSuffix(name, "."+zone))
+
+	if err != nil {
+		s.answerNegative(m, dns.RcodeNameError)
+		s.write(w, r, m)
+		return
+	}
+
+	ctx := context.Background()
+
+	if s.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Config.Timeout)
+		defer cancel()
+	}
+
+	result, err := s.Lookup(ctx, item)
+
+	if err != nil {
+		s.logger().WarnContext(ctx, "zetascan lookup failed", "item", item.String(), "error", err)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		s.write(w, r, m)
+		return
+	}
+
+	codes := s.Codes(result)
+
+	s.logger().DebugContext(ctx, "zetascan dnsbl answer", "name", name, "type", dns.TypeToString[q.Qtype], "item", item.String(), "codes", codes)
+
+	if len(codes) == 0 {
+		s.answerNegative(m, dns.RcodeNameError)
+		s.write(w, r, m)
+		return
+	}
+
+	header := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: seconds(s.Config.TTL)}
+
+	if q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY {
+		for _, code := range codes {
+			header.Rrtype = dns.TypeA
+			m.Answer = append(m.Answer, &dns.A{Hdr: header, A: net.IP(code.AsSlice())})
+		}
+	}
+
+	if q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY {
+		header.Rrtype = dns.TypeTXT
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: header, Txt: txtStrings(Reason(item.String(), result))})
+	}
+
+	// The name exists, but not with this type
+	if len(m.Answer) == 0 {
+		s.answerNegative(m, dns.RcodeSuccess)
+	}
+
+	s.write(w, r, m)
+}
+
+// write sends an answer, truncated to fit the client's UDP size, logging if
+// it can't be sent
+func (s *Server) write(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+
+		m.Truncate(size)
+	}
+
+	if err := w.WriteMsg(m); err != nil {
+		s.logger().Warn("zetascan dnsbl answer could not be sent", "remote", w.RemoteAddr().String(), "error", err)
+	}
+}
+
+// txtStrings splits text into the strings of a TXT record, of up to 255 bytes each
+func txtStrings(text string) (txt []string) {
+
+	for len(text) > 255 {
+		txt = append(txt, text[:255])
+		text = text[255:]
+	}
+
+	return append(txt, text)
+}
+
+// Lookup return the result of an item via the client
+func (s *Server) Lookup(ctx context.Context, item zetascan.Item) (result zetascan.JsonResult, err error) {
+
+	m, err := s.Client.Query(ctx, item.String())
+
+	if err != nil {
+		return result, err
+	}
+
+	if len(m.Results) > 0 {
+		result = m.Results[0]
+	}
+
+	return result, nil
+}
+
+// Codes return the addresses answered for a result, in the order of the
+// configured codes and without duplicates
+func (s *Server) Codes(result zetascan.JsonResult) (addrs []netip.Addr) {
+
+	seen := make(map[netip.Addr]bool)
+
+	for _, code := range s.Config.Codes {
+		if code.Match(result) && !seen[code.Address] {
+			seen[code.Address] = true
+			addrs = append(addrs, code.Address)
+		}
+	}
+
+	return addrs
+}
+
+// Reason return the TXT reason for the result of an item
+func Reason(item string, result zetascan.JsonResult) string {
+
+	if result.Wl {
+		return item + " whitelisted by Zetascan"
+	}
+
+	var sources []string
+	for _, source := range result.Sources {
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+
+	reason := item + " listed by Zetascan"
+
+	if len(sources) > 0 {
+		reason += " in " + strings.Join(sources, ",")
+	}
+
+	if result.Score != 0 {
+		reason += fmt.Sprintf(" (score %g)", result.Score)
+	}
+
+	return reason
+}
+
+// answerApex answers the SOA and NS of the zone
+func (s *Server) answerApex(m *dns.Msg, q dns.Question) {
+
+	switch q.Qtype {
+	case dns.TypeSOA:
+		m.Answer = append(m.Answer, s.soa())
+	case dns.TypeNS:
+		m.Answer = append(m.Answer, &dns.NS{
+			Hdr: dns.RR_Header{Name: s.zone(), Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: seconds(s.Config.TTL)},
+			Ns:  "ns." + s.zone(),
+		})
+	default:
+		s.answerNegative(m, dns.RcodeSuccess)
+	}
+}
+
+// answerNegative answers NXDOMAIN or an empty answer, with the SOA for the
+// negative TTL
+func (s *Server) answerNegative(m *dns.Msg, rcode int) {
+
+	m.Rcode = rcode
+	m.Ns = append(m.Ns, s.soa())
+}
+
+// soa return the SOA record of the zone
+func (s *Server) soa() *dns.SOA {
+
+	negative := seconds(s.Config.NegativeTTL)
+
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: s.zone(), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: negative},
+		Ns:      "ns." + s.zone(),
+		Mbox:    "hostmaster." + s.zone(),
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  negative,
+	}
+}
+
+// zone return the fully qualified zone
+func (s *Server) zone() string {
+	return dns.Fqdn(strings.ToLower(s.Config.Zone))
+}
+
+func (s *Server) logger() *slog.Logger {
+
+	if s.Logger != nil {
+		return s.Logger
+	}
+
+	return slog.New(slog.DiscardHandler)
+}
+
+// seconds return a duration as a TTL
+func seconds(d time.Duration) uint32 {
+
+	if d <= 0 {
+		return 0
+	}
+
+	return uint32(d / time.Second)
+}
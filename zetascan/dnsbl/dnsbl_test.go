@@ -0,0 +1,351 @@
+package dnsbl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+func TestTxtStrings(t *testing.T) {
+
+	for _, n := range []int{0, 1, 255, 256, 600} {
+
+		text := strings.Repeat("a", n)
+		txt := txtStrings(text)
+
+		if strings.Join(txt, "") != text {
+			t.Errorf("%d bytes: strings don't join to the text", n)
+		}
+
+		for _, s := range txt {
+			if len(s) > 255 {
+				t.Errorf("%d bytes: a string of %d bytes", n, len(s))
+			}
+		}
+	}
+}
+
+// newServer serves a DNSBL for the emulator over UDP and TCP, and return the
+// address it listens on
+func newServer(t *testing.T, emulator *zetascantest.Server) (*Server, string) {
+
+	t.Helper()
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	client := zetascan.NewClient(myzetascan, zetascan.DefaultClientConfig)
+	t.Cleanup(func() { client.Close() })
+
+	s := NewServer(client)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+
+	go s.ServePacket(pc)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	return s, pc.LocalAddr().String()
+}
+
+// A reason longer than a TXT string is answered over UDP and TCP
+func TestLongReason(t *testing.T) {
+
+	var sources []string
+	for i := range 40 {
+		sources = append(sources, fmt.Sprintf("source%02d", i))
+	}
+
+	emulator := zetascantest.NewServer(zetascantest.Fixtures{"127.9.9.5": {Found: true, Score: 0.95, Sources: sources}})
+	defer emulator.Close()
+
+	s, addr := newServer(t, emulator)
+
+	want := Reason("127.9.9.5", zetascan.JsonResult{Found: true, Score: 0.95, Sources: sources})
+
+	if len(want) <= 255 {
+		t.Fatalf("reason of %d bytes, want more than 255", len(want))
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+
+		q := new(dns.Msg)
+		q.SetQuestion("5.9.9.127."+s.Config.Zone+".", dns.TypeTXT)
+		q.SetEdns0(4096, false)
+
+		in, _, err := (&dns.Client{Net: network}).Exchange(q, addr)
+
+		if err != nil {
+			t.Fatalf("%s: %v", network, err)
+		}
+
+		if len(in.Answer) != 1 {
+			t.Fatalf("%s: %d answers, want 1", network, len(in.Answer))
+		}
+
+		if got := strings.Join(in.Answer[0].(*dns.TXT).Txt, ""); got != want {
+			t.Errorf("%s: TXT = %q, want %q", network, got, want)
+		}
+	}
+}
+
+// exchange sends a query over UDP and return the answer
+func exchange(t *testing.T, addr, name string, qtype uint16) *dns.Msg {
+
+	t.Helper()
+
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+
+	in, _, err := (&dns.Client{Net: "udp"}).Exchange(q, addr)
+
+	if err != nil {
+		t.Fatalf("%s %s: %v", name, dns.TypeToString[qtype], err)
+	}
+
+	return in
+}
+
+// records return the type and data of each record, e.g "A 127.0.0.2"
+func records(rrs []dns.RR) (records []string) {
+
+	for _, rr := range rrs {
+
+		switch rr := rr.(type) {
+		case *dns.A:
+			records = append(records, "A "+rr.A.String())
+		case *dns.TXT:
+			records = append(records, "TXT "+strings.Join(rr.Txt, ""))
+		case *dns.SOA:
+			records = append(records, "SOA "+rr.Ns)
+		case *dns.NS:
+			records = append(records, "NS "+rr.Ns)
+		default:
+			records = append(records, rr.String())
+		}
+	}
+
+	return records
+}
+
+func TestServeDNS(t *testing.T) {
+
+	fixtures := zetascantest.DefaultFixtures()
+	fixtures["2001:db8::1"] = zetascantest.Fixture{Found: true, Score: 0.5, Sources: []string{"shSBL"}}
+
+	emulator := zetascantest.NewServer(fixtures)
+	defer emulator.Close()
+
+	_, addr := newServer(t, emulator)
+
+	const ipv6 = "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.zs.local."
+
+	for _, test := range []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		refused bool
+		answer  []string
+		ns      []string
+	}{
+		// Reversed IPv4
+		{"1.9.9.127.zs.local.", dns.TypeA, dns.RcodeSuccess, false, []string{"A 127.0.0.2", "A 127.0.0.3"}, nil},
+		{"3.9.9.127.zs.local.", dns.TypeA, dns.RcodeSuccess, false, []string{"A 127.0.0.2"}, nil},
+		{"1.9.9.127.ZS.LOCAL.", dns.TypeA, dns.RcodeSuccess, false, []string{"A 127.0.0.2", "A 127.0.0.3"}, nil},
+		{"1.9.9.127.zs.local.", dns.TypeTXT, dns.RcodeSuccess, false, []string{"TXT 127.9.9.1 listed by Zetascan in shSBL,shXBL (score 0.95)"}, nil},
+		{"3.9.9.127.zs.local.", dns.TypeANY, dns.RcodeSuccess, false, []string{"A 127.0.0.2", "TXT 127.9.9.3 listed by Zetascan in shSBL (score 0.8)"}, nil},
+		{"1.9.9.127.zs.local.", dns.TypeMX, dns.RcodeSuccess, false, nil, []string{"SOA ns.zs.local."}},
+
+		// IPv6 nibbles
+		{ipv6, dns.TypeA, dns.RcodeSuccess, false, []string{"A 127.0.0.2"}, nil},
+		{ipv6, dns.TypeTXT, dns.RcodeSuccess, false, []string{"TXT 2001:db8::1 listed by Zetascan in shSBL (score 0.5)"}, nil},
+
+		// Domains
+		{"baddomain.org.zs.local.", dns.TypeA, dns.RcodeSuccess, false, []string{"A 127.0.0.2", "A 127.0.0.3"}, nil},
+		{"baddomain.org.zs.local.", dns.TypeTXT, dns.RcodeSuccess, false, []string{"TXT baddomain.org listed by Zetascan in shDBL,ubBlack,ubGold,ubGrey,ubRed (score 1)"}, nil},
+
+		// Not listed, whitelisted or not an item
+		{"2.0.0.127.zs.local.", dns.TypeA, dns.RcodeNameError, false, nil, []string{"SOA ns.zs.local."}},
+		{"4.9.9.127.zs.local.", dns.TypeA, dns.RcodeNameError, false, nil, []string{"SOA ns.zs.local."}},
+		{"okdomain.org.zs.local.", dns.TypeTXT, dns.RcodeNameError, false, nil, []string{"SOA ns.zs.local."}},
+		{"foo.zs.local.", dns.TypeA, dns.RcodeNameError, false, nil, []string{"SOA ns.zs.local."}},
+		{"1.2.3.zs.local.", dns.TypeA, dns.RcodeNameError, false, nil, []string{"SOA ns.zs.local."}},
+
+		// Outside the zone
+		{"1.9.9.127.example.com.", dns.TypeA, dns.RcodeRefused, true, nil, nil},
+		{"local.", dns.TypeSOA, dns.RcodeRefused, true, nil, nil},
+
+		// Apex
+		{"zs.local.", dns.TypeSOA, dns.RcodeSuccess, false, []string{"SOA ns.zs.local."}, nil},
+		{"zs.local.", dns.TypeNS, dns.RcodeSuccess, false, []string{"NS ns.zs.local."}, nil},
+		{"zs.local.", dns.TypeA, dns.RcodeSuccess, false, nil, []string{"SOA ns.zs.local."}},
+	} {
+
+		in := exchange(t, addr, test.name, test.qtype)
+		what := test.name + " " + dns.TypeToString[test.qtype]
+
+		if in.Rcode != test.rcode {
+			t.Errorf("%s: rcode %s, want %s", what, dns.RcodeToString[in.Rcode], dns.RcodeToString[test.rcode])
+		}
+
+		if in.Authoritative == test.refused {
+			t.Errorf("%s: authoritative %t, want %t", what, in.Authoritative, !test.refused)
+		}
+
+		if got := records(in.Answer); strings.Join(got, "\n") != strings.Join(test.answer, "\n") {
+			t.Errorf("%s: answer %q, want %q", what, got, test.answer)
+		}
+
+		if got := records(in.Ns); strings.Join(got, "\n") != strings.Join(test.ns, "\n") {
+			t.Errorf("%s: authority %q, want %q", what, got, test.ns)
+		}
+	}
+}
+
+// Listed answers have the TTL, and negative ones the SOA of the NegativeTTL
+func TestServeDNSTTL(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	_, addr := newServer(t, emulator)
+
+	in := exchange(t, addr, "1.9.9.127.zs.local.", dns.TypeA)
+
+	for _, rr := range in.Answer {
+		if rr.Header().Ttl != 300 {
+			t.Errorf("listed TTL %d, want 300", rr.Header().Ttl)
+		}
+	}
+
+	in = exchange(t, addr, "2.0.0.127.zs.local.", dns.TypeA)
+
+	if len(in.Ns) != 1 {
+		t.Fatalf("%d authority records, want the SOA", len(in.Ns))
+	}
+
+	if soa := in.Ns[0].(*dns.SOA); soa.Hdr.Ttl != 60 || soa.Minttl != 60 {
+		t.Errorf("SOA TTL %d and minimum %d, want 60", soa.Hdr.Ttl, soa.Minttl)
+	}
+}
+
+// A failed lookup is SERVFAIL, so resolvers retry rather than cache it
+func TestServeDNSLookupFailure(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.InjectMethod("json", zetascantest.Burst(http.StatusForbidden, -1))
+
+	_, addr := newServer(t, emulator)
+
+	for _, name := range []string{"1.9.9.127.zs.local.", "baddomain.org.zs.local."} {
+
+		in := exchange(t, addr, name, dns.TypeA)
+
+		if in.Rcode != dns.RcodeServerFailure {
+			t.Errorf("%s: rcode %s, want SERVFAIL", name, dns.RcodeToString[in.Rcode])
+		}
+
+		if len(in.Answer) != 0 || len(in.Ns) != 0 {
+			t.Errorf("%s: %d answers and %d authority records, want none", name, len(in.Answer), len(in.Ns))
+		}
+	}
+
+	// The apex doesn't need a lookup
+	if in := exchange(t, addr, "zs.local.", dns.TypeSOA); in.Rcode != dns.RcodeSuccess || len(in.Answer) != 1 {
+		t.Errorf("apex SOA: rcode %s and %d answers, want the SOA", dns.RcodeToString[in.Rcode], len(in.Answer))
+	}
+}
+
+func TestParseCode(t *testing.T) {
+
+	for _, test := range []struct {
+		s    string
+		want string
+		err  string
+	}{
+		{s: "127.0.0.2", want: "127.0.0.2"},
+		{s: "127.0.0.2=listed", want: "127.0.0.2"},
+		{s: " 127.0.0.3=score:0.9 ", want: "127.0.0.3=score:0.9"},
+		{s: "127.0.0.4=shXBL|shCBL", want: "127.0.0.4=shXBL|shCBL"},
+		{s: "127.0.0.5=shSBL+score:0.5", want: "127.0.0.5=shSBL+score:0.5"},
+		{s: "127.0.0.5=score:0.5+shSBL", want: "127.0.0.5=shSBL+score:0.5"},
+		{s: "127.0.1.1=whitelisted", want: "127.0.1.1=whitelisted"},
+
+		{s: "", err: "Invalid code address"},
+		{s: "::1", err: "Invalid code address"},
+		{s: "localhost=listed", err: "Invalid code address"},
+		{s: "127.0.0.3=score:high", err: "Invalid code score"},
+		{s: "127.0.0.4=a++b", err: "Invalid code: "},
+		{s: "127.0.0.4=shSBL+", err: "Invalid code: "},
+		{s: "127.0.1.1=whitelisted+score:0.5", err: "Whitelisted codes can't match sources or scores"},
+		{s: "127.0.1.1=whitelisted+shSBL", err: "Whitelisted codes can't match sources or scores"},
+	} {
+
+		code, err := ParseCode(test.s)
+
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%q: error %v, want %q", test.s, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+			continue
+		}
+
+		if got := code.String(); got != test.want {
+			t.Errorf("%q: String = %q, want %q", test.s, got, test.want)
+		}
+
+		if again, err := ParseCode(code.String()); err != nil || !reflect.DeepEqual(again, code) {
+			t.Errorf("%q: String doesn't parse back, got %+v, %v", test.s, again, err)
+		}
+	}
+}
+
+func TestParseCodes(t *testing.T) {
+
+	codes, err := ParseCodes("127.0.0.2, 127.0.0.3=score:0.9,")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(codes, DefaultCodes) {
+		t.Errorf("codes %v, want the DefaultCodes %v", codes, DefaultCodes)
+	}
+
+	for s, want := range map[string]string{
+		"":                "No codes",
+		" , ":             "No codes",
+		"127.0.0.2,::1":   "Invalid code address: ::1",
+		"127.0.0.2,=shBL": "Invalid code address: =shBL",
+	} {
+		if _, err := ParseCodes(s); err == nil || err.Error() != want {
+			t.Errorf("%q: error %v, want %q", s, err, want)
+		}
+	}
+}
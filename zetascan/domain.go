@@ -0,0 +1,170 @@
+package zetascan
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// RegisteredDomain return the registrable domain (eTLD+1) of a hostname, using
+// the public suffix list embedded in golang.org/x/net/publicsuffix
+func RegisteredDomain(host string) (domain string, err error) {
+
+	item, err := ParseItem(host)
+
+	if err != nil {
+		return "", err
+	}
+
+	if item.IsIP() {
+		return "", &ItemError{Input: host, Reason: "IP addresses have no registered domain"}
+	}
+
+	return publicsuffix.EffectiveTLDPlusOne(item.Host)
+}
+
+// DomainLabels return the hostname and each parent label down to the
+// registrable domain, most specific first (a.b.baddomain.org, b.baddomain.org, baddomain.org).
+// If parents is false, only the hostname and registrable domain are returned.
+func DomainLabels(host string, parents bool) (labels []string, err error) {
+
+	item, err := ParseItem(host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	labels = []string{item.Host}
+
+	if item.IsIP() {
+		return labels, nil
+	}
+
+	registered, err := publicsuffix.EffectiveTLDPlusOne(item.Host)
+
+	// The host is a public suffix itself (e.g co.uk), only query as is
+	if err != nil || registered == item.Host {
+		return labels, nil
+	}
+
+	if parents {
+		name := item.Host
+		for name != registered {
+			name = name[strings.Index(name, ".")+1:]
+			labels = append(labels, name)
+		}
+	} else {
+		labels = append(labels, registered)
+	}
+
+	return labels, nil
+}
+
+// QueryDomain query a hostname along with its registrable domain, and
+// optionally each parent label, merging the results into a single record for
+// the original hostname. The most specific label found in a blacklist or a
+// whitelist decides the result, and is reported in Results[0].Matched, so a
+// listed subdomain of a whitelisted domain is listed, and a whitelisted
+// subdomain of a listed domain is whitelisted.
+func (myapi Api) QueryDomain(query string, parents bool) (m JsonRecord, err error) {
+	return myapi.QueryDomainContext(context.Background(), query, parents)
+}
+
+// QueryDomainContext query a hostname like QueryDomain, cancelled when ctx is done
+func (myapi Api) QueryDomainContext(ctx context.Context, query string, parents bool) (m JsonRecord, err error) {
+
+	labels, err := DomainLabels(query, parents)
+
+	if err != nil {
+		return m, err
+	}
+
+	records := make(map[string]JsonRecord, len(labels))
+
+	for _, label := range labels {
+
+		record, err := myapi.QueryContext(ctx, label)
+
+		if err != nil {
+			return m, err
+		}
+
+		records[label] = record
+	}
+
+	return mergeDomainRecords(labels[0], labels, records), nil
+}
+
+// mergeDomainRecords combine the results for each label, most specific first,
+// into one record for the host. The first label matched decides if the host is
+// listed or whitelisted. A listed host has the sources of every listed label
+// and the highest score.
+func mergeDomainRecords(host string, labels []string, records map[string]JsonRecord) (m JsonRecord) {
+
+	m.Results = JsonResults{{Item: host}}
+	merged := &m.Results[0]
+
+	var matched []JsonResult
+
+	for _, label := range labels {
+
+		record := records[label]
+
+		m.ExecutionTime += record.ExecutionTime
+
+		if m.Status == "" {
+			m.Status = record.Status
+		}
+
+		if len(record.Results) == 0 {
+			continue
+		}
+
+		result := record.Results[0]
+
+		if !result.Found && !result.Wl {
+			continue
+		}
+
+		if merged.Matched == "" {
+			merged.Matched = label
+			merged.Found = result.Found
+			merged.Wl = result.Wl
+			merged.Score = result.Score
+			merged.WebScore = result.WebScore
+			merged.FromSubnet = result.FromSubnet
+			merged.Wldata = result.Wldata
+			merged.Extended = result.Extended
+		}
+
+		matched = append(matched, result)
+	}
+
+	// Only the deciding label's sources for a whitelisted host
+	if len(matched) > 0 && merged.Wl {
+		matched = matched[:1]
+	}
+
+	sources := make(map[string]bool)
+
+	for _, result := range matched {
+
+		// A whitelisted parent adds nothing to a listed host
+		if !merged.Wl && result.Wl {
+			continue
+		}
+
+		merged.Score = max(merged.Score, result.Score)
+		merged.WebScore = max(merged.WebScore, result.WebScore)
+
+		for _, source := range result.Sources {
+			if source != "" && !sources[source] {
+				sources[source] = true
+				merged.Sources = append(merged.Sources, source)
+			}
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,118 @@
+package zetascan_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+func TestRegisteredDomain(t *testing.T) {
+
+	for host, want := range map[string]string{
+		"baddomain.org":          "baddomain.org",
+		"a.b.baddomain.org":      "baddomain.org",
+		"https://www.bbc.co.uk/": "bbc.co.uk",
+		"user@mail.example.com":  "example.com",
+	} {
+		if got, err := zetascan.RegisteredDomain(host); err != nil || got != want {
+			t.Errorf("RegisteredDomain(%q) = %q, %v, want %q", host, got, err, want)
+		}
+	}
+
+	for _, host := range []string{"127.9.9.1", "2001:db8::1", "co.uk", "localhost"} {
+		if got, err := zetascan.RegisteredDomain(host); err == nil {
+			t.Errorf("RegisteredDomain(%q) = %q, want an error", host, got)
+		}
+	}
+}
+
+func TestDomainLabels(t *testing.T) {
+
+	tests := []struct {
+		host    string
+		parents bool
+		want    []string
+	}{
+		{"a.b.baddomain.org", true, []string{"a.b.baddomain.org", "b.baddomain.org", "baddomain.org"}},
+		{"a.b.baddomain.org", false, []string{"a.b.baddomain.org", "baddomain.org"}},
+		{"baddomain.org", true, []string{"baddomain.org"}},
+		{"www.bbc.co.uk", true, []string{"www.bbc.co.uk", "bbc.co.uk"}},
+		{"co.uk", true, []string{"co.uk"}},
+		{"127.9.9.1", true, []string{"127.9.9.1"}},
+	}
+
+	for _, test := range tests {
+
+		labels, err := zetascan.DomainLabels(test.host, test.parents)
+
+		if err != nil || !slices.Equal(labels, test.want) {
+			t.Errorf("DomainLabels(%q, %v) = %q, %v, want %q", test.host, test.parents, labels, err, test.want)
+		}
+	}
+
+	if _, err := zetascan.DomainLabels("1.2.3", true); err == nil {
+		t.Error("DomainLabels accepted an invalid item")
+	}
+}
+
+func TestQueryDomain(t *testing.T) {
+
+	fixtures := zetascantest.DefaultFixtures()
+	fixtures["evil.okdomain.org"] = zetascantest.Fixture{Found: true, Score: 0.9, Sources: []string{"shDBL"}}
+	fixtures["www.baddomain.org"] = zetascantest.Fixture{Wl: true, Score: -1, Sources: []string{"wlDomain"}}
+	fixtures["b.listed.org"] = zetascantest.Fixture{Found: true, Score: 0.5, Sources: []string{"ubGrey"}}
+	fixtures["listed.org"] = zetascantest.Fixture{Found: true, Score: 0.8, Sources: []string{"shDBL"}}
+
+	emulator := zetascantest.NewServer(fixtures)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	tests := []struct {
+		host        string
+		listed      bool
+		whitelisted bool
+		matched     string
+		score       float64
+		sources     []string
+	}{
+		{"a.b.baddomain.org", true, false, "baddomain.org", 1, []string{"shDBL", "ubRed", "ubGold", "ubGrey", "ubBlack"}},
+		{"evil.okdomain.org", true, false, "evil.okdomain.org", 0.9, []string{"shDBL"}},
+		{"a.www.baddomain.org", false, true, "www.baddomain.org", -1, []string{"wlDomain"}},
+		{"a.b.listed.org", true, false, "b.listed.org", 0.8, []string{"ubGrey", "shDBL"}},
+		{"a.unlisted.org", false, false, "", 0, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+
+			m, err := myzetascan.QueryDomainContext(context.Background(), test.host, true)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := m.Results[0]
+
+			if result.Item != test.host {
+				t.Errorf("Item = %q, want %q", result.Item, test.host)
+			}
+
+			if myzetascan.IsBlackList(&m) != test.listed || myzetascan.IsWhiteList(&m) != test.whitelisted {
+				t.Errorf("listed, whitelisted = %v, %v, want %v, %v", myzetascan.IsBlackList(&m), myzetascan.IsWhiteList(&m), test.listed, test.whitelisted)
+			}
+
+			if result.Matched != test.matched || result.Score != test.score {
+				t.Errorf("matched, score = %q, %v, want %q, %v", result.Matched, result.Score, test.matched, test.score)
+			}
+
+			if !slices.Equal(result.Sources, test.sources) {
+				t.Errorf("sources = %q, want %q", result.Sources, test.sources)
+			}
+		})
+	}
+}
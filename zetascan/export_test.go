@@ -0,0 +1,7 @@
+package zetascan
+
+// Unexported helpers tested from zetascan_test
+var (
+	Percentiles = percentiles
+	Normalise   = normalise
+)
@@ -0,0 +1,106 @@
+package zetascan
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// WithFallback queries via each method in turn if the previous fails, e.g
+// WithMethod("dns"), WithFallback("jsonx")
+func WithFallback(methods ...string) CallOption {
+	return func(myapi *Api) { myapi.MethodFallback = methods }
+}
+
+// WithEnrich looks up listed items via jsonx, to fill in the extended data
+// missing from the other methods
+func WithEnrich() CallOption {
+	return func(myapi *Api) { myapi.EnrichOnHit = true }
+}
+
+// ParseMethods return the methods of a method, or a comma separated fallback
+// chain e.g "dns,jsonx", for WithMethod and WithFallback
+func ParseMethods(chain string) (methods []string, err error) {
+
+	for _, method := range strings.Split(chain, ",") {
+
+		method = strings.TrimSpace(method)
+
+		if !validMethod(method) {
+			return nil, errors.New("Unknown query method: " + method)
+		}
+
+		methods = append(methods, method)
+	}
+
+	return methods, nil
+}
+
+// queryChain queries via ApiMethod, then each MethodFallback until one answers
+func (myapi Api) queryChain(ctx context.Context, query string) (m JsonRecord, err error) {
+
+	m, err = myapi.queryMethod(ctx, query)
+
+	for _, method := range myapi.MethodFallback {
+
+		// An invalid item or a cancelled query won't succeed via another method
+		var itemErr *ItemError
+		if err == nil || errors.As(err, &itemErr) || ctx.Err() != nil {
+			break
+		}
+
+		myapi.logger().WarnContext(ctx, "zetascan method failed, falling back", "method", myapi.ApiMethod, "next", method, "error", err)
+
+		myapi.ApiMethod = method
+		m, err = myapi.queryMethod(ctx, query)
+	}
+
+	return m, err
+}
+
+// enrich fills in the extended data, and any missing score or sources, of a
+// listed item from a jsonx query. The answer is returned unchanged if the
+// jsonx query fails.
+func (myapi Api) enrich(ctx context.Context, query string, m JsonRecord) JsonRecord {
+
+	if m.Method == "jsonx" || len(m.Results) == 0 || !myapi.IsMatch(&m) {
+		return m
+	}
+
+	myapi.ApiMethod = "jsonx"
+	x, err := myapi.queryMethod(ctx, query)
+
+	if err != nil || len(x.Results) == 0 {
+		myapi.logger().WarnContext(ctx, "zetascan enrichment failed", "method", m.Method, "error", err)
+		return m
+	}
+
+	result, extended := m.Results[0], x.Results[0]
+
+	result.Extended = extended.Extended
+
+	if result.Score == 0 && result.WebScore == 0 {
+		result.Score = extended.Score
+		result.WebScore = extended.WebScore
+	}
+
+	if len(result.Sources) == 0 {
+		result.Sources = extended.Sources
+	}
+
+	m.Results = append(JsonResults{result}, m.Results[1:]...)
+
+	return m
+}
+
+// only return the Api querying via a method alone, without fallback,
+// enrichment or the cache
+func (myapi Api) only(method string) Api {
+
+	myapi.ApiMethod = method
+	myapi.MethodFallback = nil
+	myapi.Cache = nil
+	myapi.EnrichOnHit = false
+
+	return myapi
+}
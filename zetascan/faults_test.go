@@ -0,0 +1,384 @@
+package zetascan_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+// recorder counts the retries and failovers reported to Metrics
+type recorder struct {
+	mu        sync.Mutex
+	retries   map[string]int // By reason
+	failovers int
+	errors    []string
+}
+
+func (r *recorder) ObserveQuery(q zetascan.QueryMetric) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if q.Error != "" {
+		r.errors = append(r.errors, q.Error)
+	}
+}
+
+func (r *recorder) ObserveRetry(method, endpoint, reason string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.retries == nil {
+		r.retries = make(map[string]int)
+	}
+
+	r.retries[reason]++
+}
+
+func (r *recorder) ObserveFailover(method, from, to string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failovers++
+}
+
+func (r *recorder) ObserveCache(method string, hit bool) {}
+
+// failoverApi return an Api querying primary, failing over to secondary
+func failoverApi(t *testing.T, method string, primary, secondary *zetascantest.Server) (zetascan.Api, *recorder) {
+
+	myzetascan, err := primary.Api("").SetEndpoint(primary.URL, secondary.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{}
+	myzetascan.ApiMethod = method
+	myzetascan.Metrics = rec
+
+	return myzetascan, rec
+}
+
+func TestFailover(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		fault    zetascantest.Fault
+		failover bool
+		errType  string // If not failing over
+	}{
+		{"rate limited", zetascantest.Burst(http.StatusTooManyRequests, 1), true, ""},
+		{"server error", zetascantest.Burst(http.StatusInternalServerError, 1), true, ""},
+		{"unavailable", zetascantest.Burst(http.StatusServiceUnavailable, 1), true, ""},
+		{"dropped", zetascantest.Fault{Drop: true, Count: 2}, true, ""},
+		{"forbidden", zetascantest.Burst(http.StatusForbidden, 1), false, "forbidden"},
+		{"not found", zetascantest.Burst(http.StatusNotFound, 1), false, "http_status"},
+		{"truncated", zetascantest.Fault{Truncate: true}, false, "parse"},
+		{"invalid JSON", zetascantest.Fault{InvalidJSON: true}, false, "parse"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			primary := zetascantest.NewServer(nil)
+			defer primary.Close()
+
+			secondary := zetascantest.NewServer(nil)
+			defer secondary.Close()
+
+			primary.InjectMethod("json", test.fault)
+
+			myzetascan, rec := failoverApi(t, "json", primary, secondary)
+
+			m, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+			if !test.failover {
+
+				if err == nil {
+					t.Fatal("query succeeded, want an error")
+				}
+
+				if got := zetascan.ErrorType(err); got != test.errType {
+					t.Errorf("ErrorType = %q, want %q (%v)", got, test.errType, err)
+				}
+
+				if secondary.Requests("json") != 0 || rec.failovers != 0 {
+					t.Errorf("failed over to the secondary, %d requests", secondary.Requests("json"))
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !myzetascan.IsBlackList(&m) {
+				t.Errorf("127.9.9.1 not listed: %+v", m.Results)
+			}
+
+			if secondary.Requests("127.9.9.1") != 1 || rec.failovers != 1 {
+				t.Errorf("secondary requests, failovers = %d, %d, want 1, 1", secondary.Requests("127.9.9.1"), rec.failovers)
+			}
+		})
+	}
+}
+
+// HTTP errors are classified by status, even when wrapped
+func TestStatusError(t *testing.T) {
+
+	for code, want := range map[int]string{403: "forbidden", 404: "http_status", 418: "http_status", 429: "rate_limited", 502: "server_error"} {
+
+		err := fmt.Errorf("checking: %w", &zetascan.StatusError{Code: code, Message: "Request failed"})
+
+		if got := zetascan.ErrorType(err); got != want {
+			t.Errorf("%d: ErrorType = %q, want %q", code, got, want)
+		}
+	}
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.InjectMethod("json", zetascantest.Burst(http.StatusForbidden, 1))
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	_, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+	var statusErr *zetascan.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusForbidden {
+		t.Errorf("err = %v, want a 403 StatusError", err)
+	}
+}
+
+func TestFailoverExhausted(t *testing.T) {
+
+	primary := zetascantest.NewServer(nil)
+	defer primary.Close()
+
+	secondary := zetascantest.NewServer(nil)
+	defer secondary.Close()
+
+	primary.InjectMethod("json", zetascantest.Burst(http.StatusServiceUnavailable, -1))
+	secondary.InjectMethod("json", zetascantest.Burst(http.StatusTooManyRequests, -1))
+
+	myzetascan, rec := failoverApi(t, "json", primary, secondary)
+
+	_, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+	// The last endpoint's error is returned
+	if got := zetascan.ErrorType(err); got != "rate_limited" {
+		t.Errorf("ErrorType = %q, want rate_limited (%v)", got, err)
+	}
+
+	if rec.failovers != 1 || len(rec.errors) != 1 {
+		t.Errorf("failovers, errors = %d, %v", rec.failovers, rec.errors)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.Key = "GOODKEY"
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+	myzetascan.HeaderAuth = true
+
+	rec := &recorder{}
+	myzetascan.Metrics = rec
+
+	// The primary key is revoked, the secondary answers
+	myzetascan.Keys = zetascan.StaticKeys("REVOKEDKEY", "GOODKEY")
+
+	if _, err := myzetascan.QueryContext(context.Background(), "127.9.9.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.retries["forbidden"] != 1 {
+		t.Errorf("forbidden retries = %d, want 1", rec.retries["forbidden"])
+	}
+
+	// Later queries start from the key that answered
+	if _, err := myzetascan.QueryContext(context.Background(), "127.9.9.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.retries["forbidden"] != 1 {
+		t.Errorf("forbidden retries = %d after the second query, want 1", rec.retries["forbidden"])
+	}
+
+	// Every key is revoked
+	myzetascan.Keys = zetascan.StaticKeys("REVOKEDKEY", "OLDKEY")
+
+	_, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+	if got := zetascan.ErrorType(err); got != "forbidden" {
+		t.Errorf("ErrorType = %q, want forbidden (%v)", got, err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.InjectItem("127.9.9.1", zetascantest.Fault{Latency: time.Second})
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := myzetascan.QueryContext(ctx, "127.9.9.1")
+
+	if got := zetascan.ErrorType(err); got != "timeout" {
+		t.Errorf("ErrorType = %q, want timeout (%v)", got, err)
+	}
+}
+
+func TestDNSRetry(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("waits for a DNS timeout")
+	}
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	// The first query is dropped, the retry answers
+	emulator.InjectMethod("dns", zetascantest.Fault{Drop: true})
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "dns"
+
+	rec := &recorder{}
+	myzetascan.Metrics = rec
+
+	m, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !myzetascan.IsBlackList(&m) || rec.retries["timeout"] != 1 || emulator.Requests("dns") != 2 {
+		t.Errorf("listed, retries, requests = %v, %d, %d", myzetascan.IsBlackList(&m), rec.retries["timeout"], emulator.Requests("dns"))
+	}
+}
+
+func TestDNSTimeout(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.InjectMethod("dns", zetascantest.Fault{Drop: true, Count: -1})
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "dns"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err := myzetascan.QueryContext(ctx, "127.9.9.1")
+
+	if got := zetascan.ErrorType(err); got != "timeout" {
+		t.Errorf("ErrorType = %q, want timeout (%v)", got, err)
+	}
+}
+
+func TestDNSFaults(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "dns"
+
+	rec := &recorder{}
+	myzetascan.Metrics = rec
+
+	// Truncated over UDP, answered over TCP
+	emulator.InjectItem("127.9.9.1", zetascantest.Fault{Truncate: true})
+
+	m, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+	if err != nil || !myzetascan.IsBlackList(&m) {
+		t.Errorf("truncated: %v, %+v", err, m.Results)
+	}
+
+	if rec.retries["truncated"] != 1 {
+		t.Errorf("truncated retries = %d, want 1", rec.retries["truncated"])
+	}
+
+	// SERVFAIL and REFUSED are errors, not unlisted
+	for status, rcode := range map[int]string{http.StatusServiceUnavailable: "SERVFAIL", http.StatusForbidden: "REFUSED"} {
+
+		emulator.InjectItem("127.9.9.1", zetascantest.Burst(status, 1))
+
+		_, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+		if got := zetascan.ErrorType(err); got != "dns_rcode" {
+			t.Errorf("%s: ErrorType = %q, want dns_rcode (%v)", rcode, got, err)
+		}
+	}
+}
+
+// An answer without a question section is parsed, not a panic
+func TestDNSNoQuestion(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Question = nil
+		m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(127, 0, 0, 4)}}
+
+		w.WriteMsg(m)
+	})}
+
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	myzetascan, err := zetascan.Api{}.Init("", true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	myzetascan.ApiMethod = "dns"
+	myzetascan.DnsServer = pc.LocalAddr().String()
+	myzetascan.Logger = slog.New(slog.DiscardHandler)
+
+	m, err := myzetascan.QueryContext(context.Background(), "127.9.9.1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !myzetascan.IsBlackList(&m) {
+		t.Errorf("127.9.9.1 not listed: %+v", m.Results)
+	}
+}
@@ -0,0 +1,289 @@
+package zetascan
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ItemType classifies what kind of item is being queried
+type ItemType int
+
+const (
+	ItemUnknown ItemType = iota
+	ItemIPv4
+	ItemIPv6
+	ItemDomain
+	ItemEmail
+)
+
+// String return a readable name for the item type
+func (t ItemType) String() string {
+
+	switch t {
+	case ItemIPv4:
+		return "ipv4"
+	case ItemIPv6:
+		return "ipv6"
+	case ItemDomain:
+		return "domain"
+	case ItemEmail:
+		return "email"
+	}
+
+	return "unknown"
+}
+
+// Item is a normalised domain/IP ready to be queried against zetascan
+type Item struct {
+	Input string   // Raw input, as supplied by the caller
+	Type  ItemType // Classification of the input
+	Host  string   // Normalised IP or ASCII (punycode) domain that is queried
+	Local string   // Local part of an email address, empty otherwise
+}
+
+// ItemError is returned by ParseItem when the input can not be queried
+type ItemError struct {
+	Input  string
+	Reason string
+}
+
+func (e *ItemError) Error() string {
+	return "invalid item " + strconv.Quote(e.Input) + ": " + e.Reason
+}
+
+// ParseItem classifies a raw query as an IPv4, IPv6, domain or email address.
+//
+// URLs and email addresses are reduced to their host, ports and trailing dots
+// are stripped, and international domains are converted to punycode.
+func ParseItem(query string) (item Item, err error) {
+
+	item.Input = query
+	host := strings.TrimSpace(query)
+
+	if host == "" {
+		return item, &ItemError{Input: query, Reason: "empty item"}
+	}
+
+	// Full URL, e.g https://user@www.baddomain.org:8080/path
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil || u.Host == "" {
+			return item, &ItemError{Input: query, Reason: "malformed URL"}
+		}
+		host = u.Host
+	} else if i := strings.LastIndex(host, "@"); i >= 0 {
+
+		// Email address, query the domain (or IP literal) after the @
+		item.Local = host[:i]
+		host = host[i+1:]
+
+		if item.Local == "" || host == "" {
+			return item, &ItemError{Input: query, Reason: "malformed email address"}
+		}
+
+		item.Type = ItemEmail
+	}
+
+	host, ok := stripPort(host)
+
+	if !ok {
+		return item, &ItemError{Input: query, Reason: "invalid port"}
+	}
+
+	host = strings.TrimRight(host, ".")
+
+	if host == "" {
+		return item, &ItemError{Input: query, Reason: "missing host"}
+	}
+
+	// IP address, drop any IPv6 zone and unmap IPv4-in-IPv6
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.WithZone("").Unmap()
+		item.Host = addr.String()
+
+		if item.Type != ItemEmail {
+			if addr.Is4() {
+				item.Type = ItemIPv4
+			} else {
+				item.Type = ItemIPv6
+			}
+		}
+
+		return item, nil
+	}
+
+	// Otherwise must be a valid domain name
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return item, &ItemError{Input: query, Reason: "invalid domain: " + err.Error()}
+	}
+
+	if reason := checkDomain(ascii); reason != "" {
+		return item, &ItemError{Input: query, Reason: reason}
+	}
+
+	item.Host = ascii
+
+	if item.Type != ItemEmail {
+		item.Type = ItemDomain
+	}
+
+	return item, nil
+}
+
+// String return the form of the item used for HTTP queries
+func (item Item) String() string {
+	return item.Host
+}
+
+// IsIP return if the item resolves to an IPv4 or IPv6 address
+func (item Item) IsIP() bool {
+	_, err := netip.ParseAddr(item.Host)
+	return err == nil
+}
+
+// DNSName return the form of the item used for DNS queries, IP addresses are
+// reversed as per DNSBL convention (127.9.9.1 => 1.9.9.127)
+func (item Item) DNSName() string {
+
+	addr, err := netip.ParseAddr(item.Host)
+	if err != nil {
+		return item.Host
+	}
+
+	if addr.Is4() {
+		b := addr.As4()
+		return joinReversed([]string{itoa(b[0]), itoa(b[1]), itoa(b[2]), itoa(b[3])})
+	}
+
+	// IPv6 is reversed by nibble
+	b := addr.As16()
+	nibbles := make([]string, 0, 32)
+	for _, v := range b {
+		nibbles = append(nibbles, hexDigit(v>>4), hexDigit(v&0x0f))
+	}
+
+	return joinReversed(nibbles)
+}
+
+// ParseDNSName return the item of a DNSBL query name, the inverse of DNSName.
+// Reversed IPv4 (1.9.9.127) and IPv6 nibble (32 hex labels) names are IP
+// addresses, anything else is parsed as a domain.
+func ParseDNSName(name string) (item Item, err error) {
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	labels := strings.Split(name, ".")
+
+	switch len(labels) {
+	case 4:
+		reversed := append([]string(nil), labels...)
+		if addr, err := netip.ParseAddr(joinReversed(reversed)); err == nil && addr.Is4() {
+			return Item{Input: name, Type: ItemIPv4, Host: addr.String()}, nil
+		}
+
+	case 32:
+		var b [16]byte
+		ok := true
+
+		for i, label := range labels {
+
+			v, err := strconv.ParseUint(label, 16, 4)
+
+			if err != nil || len(label) != 1 {
+				ok = false
+				break
+			}
+
+			// Least significant nibble first
+			n := 31 - i
+			b[n/2] |= byte(v) << (4 * (1 - n%2))
+		}
+
+		if ok {
+			addr := netip.AddrFrom16(b).Unmap()
+			item := Item{Input: name, Type: ItemIPv6, Host: addr.String()}
+
+			if addr.Is4() {
+				item.Type = ItemIPv4
+			}
+
+			return item, nil
+		}
+	}
+
+	item, err = ParseItem(name)
+
+	if err == nil && item.Type != ItemDomain {
+		return item, &ItemError{Input: name, Reason: "not a domain or reversed IP"}
+	}
+
+	return item, err
+}
+
+// stripPort removes a :port suffix and IPv6 brackets from a host, and return
+// false if the port isn't 1-65535
+func stripPort(host string) (string, bool) {
+
+	// Bare IPv6 address, nothing to strip
+	if _, err := netip.ParseAddr(host); err == nil {
+		return host, true
+	}
+
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		n, err := strconv.ParseUint(port, 10, 16)
+		return h, err == nil && n > 0
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), true
+}
+
+// checkDomain return a reason the domain is unusable, or an empty string
+func checkDomain(domain string) string {
+
+	if len(domain) > 253 {
+		return "domain too long"
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "domain must contain at least two labels"
+	}
+
+	for _, label := range labels {
+		if label == "" {
+			return "empty label in domain"
+		}
+		if len(label) > 63 {
+			return "domain label too long"
+		}
+	}
+
+	// e.g 1.2.3, an incomplete IPv4 address rather than a domain
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "numeric top-level domain"
+	}
+
+	return ""
+}
+
+func joinReversed(parts []string) string {
+
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func itoa(b byte) string {
+	return strconv.Itoa(int(b))
+}
+
+func hexDigit(b byte) string {
+	return string("0123456789abcdef"[b])
+}
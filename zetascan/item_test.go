@@ -0,0 +1,135 @@
+package zetascan_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+func TestParseItem(t *testing.T) {
+
+	tests := []struct {
+		input string
+		typ   zetascan.ItemType
+		host  string
+		local string
+	}{
+		{"127.9.9.1", zetascan.ItemIPv4, "127.9.9.1", ""},
+		{" 127.9.9.1 ", zetascan.ItemIPv4, "127.9.9.1", ""},
+		{"127.9.9.1:25", zetascan.ItemIPv4, "127.9.9.1", ""},
+		{"::ffff:127.9.9.1", zetascan.ItemIPv4, "127.9.9.1", ""},
+		{"2001:db8::1", zetascan.ItemIPv6, "2001:db8::1", ""},
+		{"2001:DB8:0:0::1", zetascan.ItemIPv6, "2001:db8::1", ""},
+		{"[2001:db8::1]", zetascan.ItemIPv6, "2001:db8::1", ""},
+		{"[2001:db8::1]:8080", zetascan.ItemIPv6, "2001:db8::1", ""},
+		{"fe80::1%eth0", zetascan.ItemIPv6, "fe80::1", ""},
+		{"baddomain.org", zetascan.ItemDomain, "baddomain.org", ""},
+		{"BadDomain.ORG.", zetascan.ItemDomain, "baddomain.org", ""},
+		{"www.baddomain.org:8080", zetascan.ItemDomain, "www.baddomain.org", ""},
+		{"https://user@www.baddomain.org:8080/path?q=1", zetascan.ItemDomain, "www.baddomain.org", ""},
+		{"http://127.9.9.1/", zetascan.ItemIPv4, "127.9.9.1", ""},
+		{"http://[2001:db8::1]:8080/", zetascan.ItemIPv6, "2001:db8::1", ""},
+		{"münchen.de", zetascan.ItemDomain, "xn--mnchen-3ya.de", ""},
+		{"user@baddomain.org", zetascan.ItemEmail, "baddomain.org", "user"},
+		{"a@b@baddomain.org", zetascan.ItemEmail, "baddomain.org", "a@b"},
+		{"user@[127.9.9.1]", zetascan.ItemEmail, "127.9.9.1", "user"},
+		{"user@bücher.example", zetascan.ItemEmail, "xn--bcher-kva.example", "user"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+
+			item, err := zetascan.ParseItem(test.input)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if item.Type != test.typ || item.Host != test.host || item.Local != test.local || item.Input != test.input {
+				t.Errorf("ParseItem = %+v, want %v %q local %q", item, test.typ, test.host, test.local)
+			}
+		})
+	}
+}
+
+func TestParseItemErrors(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		reason string
+	}{
+		{"", "empty item"},
+		{"   ", "empty item"},
+		{"localhost", "domain must contain at least two labels"},
+		{"1.2.3", "numeric top-level domain"},
+		{"example.123", "numeric top-level domain"},
+		{"a..com", "empty label in domain"},
+		{"a.com:bar", "invalid port"},
+		{"a.com:0", "invalid port"},
+		{"a.com:65536", "invalid port"},
+		{"127.9.9.1:", "invalid port"},
+		{"[2001:db8::1]:x", "invalid port"},
+		{"http://a.com:99999/", "invalid port"},
+		{"http:///path", "malformed URL"},
+		{"@baddomain.org", "malformed email address"},
+		{"user@", "malformed email address"},
+		{".", "missing host"},
+		{"a_b.com", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+
+			_, err := zetascan.ParseItem(test.input)
+
+			var itemErr *zetascan.ItemError
+			if !errors.As(err, &itemErr) {
+				t.Fatalf("err = %v, want an ItemError", err)
+			}
+
+			if itemErr.Input != test.input {
+				t.Errorf("Input = %q, want %q", itemErr.Input, test.input)
+			}
+
+			if test.reason != "" && itemErr.Reason != test.reason {
+				t.Errorf("Reason = %q, want %q", itemErr.Reason, test.reason)
+			}
+
+			if zetascan.ErrorType(err) != "invalid_item" {
+				t.Errorf("ErrorType = %q, want invalid_item", zetascan.ErrorType(err))
+			}
+		})
+	}
+}
+
+// DNSName and ParseDNSName are inverses
+func TestDNSName(t *testing.T) {
+
+	for input, want := range map[string]string{
+		"127.9.9.1":     "1.9.9.127",
+		"2001:db8::1":   "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2",
+		"baddomain.org": "baddomain.org",
+	} {
+
+		item, err := zetascan.ParseItem(input)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := item.DNSName(); got != want {
+			t.Errorf("%s: DNSName = %q, want %q", input, got, want)
+		}
+
+		parsed, err := zetascan.ParseDNSName(want + ".")
+
+		if err != nil || parsed.Host != item.Host || parsed.Type != item.Type {
+			t.Errorf("ParseDNSName(%q) = %+v, %v, want %q", want, parsed, err, item.Host)
+		}
+	}
+
+	if _, err := zetascan.ParseDNSName("user@baddomain.org"); err == nil {
+		t.Error("ParseDNSName accepted an email address")
+	}
+}
@@ -0,0 +1,261 @@
+package zetascan
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyProvider supplies the API keys for each query, and may rotate them at any
+// time. It must be safe for concurrent use.
+type KeyProvider interface {
+	// Keys return the keys for an endpoint (e.g https://api.zetascan.com),
+	// primary first. The next key is tried when a key is forbidden (403).
+	Keys(endpoint string) []Secret
+}
+
+// KeySet is a set of API keys, with keys for specific endpoints
+type KeySet struct {
+	// Default keys used by any endpoint, primary first
+	Default []Secret
+
+	// Endpoints are keys for specific endpoints by host[:port], used instead of Default
+	Endpoints map[string][]Secret
+}
+
+// For return the keys for an endpoint
+func (ks KeySet) For(endpoint string) []Secret {
+
+	_, host, ok := strings.Cut(endpoint, "://")
+	if !ok {
+		host = endpoint
+	}
+
+	if keys, ok := ks.Endpoints[strings.ToLower(host)]; ok {
+		return keys
+	}
+
+	return ks.Default
+}
+
+// StaticKeys return a provider of fixed keys, primary first, for every endpoint
+func StaticKeys(keys ...string) KeyProvider {
+
+	ks := KeySet{}
+
+	for _, key := range keys {
+		if key != "" {
+			ks.Default = append(ks.Default, Secret(key))
+		}
+	}
+
+	return ks
+}
+
+// Keys implements KeyProvider
+func (ks KeySet) Keys(endpoint string) []Secret {
+	return ks.For(endpoint)
+}
+
+// EnvKeys return a provider of the keys in environment variables, primary
+// first, e.g EnvKeys("ZETASCAN_API_KEY", "ZETASCAN_API_KEY_SECONDARY").
+// The variables are read for each query, so changes apply immediately.
+func EnvKeys(names ...string) KeyProvider {
+	return envKeys(names)
+}
+
+type envKeys []string
+
+// Keys implements KeyProvider
+func (names envKeys) Keys(endpoint string) (keys []Secret) {
+
+	for _, name := range names {
+		if key := strings.TrimSpace(os.Getenv(name)); key != "" {
+			keys = append(keys, Secret(key))
+		}
+	}
+
+	return keys
+}
+
+// lastKeys remembers the index of the last key that answered each endpoint,
+// so queries start from a working key rather than the revoked primary
+type lastKeys struct {
+	endpoints sync.Map // Endpoint to *atomic.Int32
+}
+
+// For return the last key index of an endpoint, or a discarded index if lk is nil
+func (lk *lastKeys) For(endpoint string) *atomic.Int32 {
+
+	if lk == nil {
+		return new(atomic.Int32)
+	}
+
+	last, _ := lk.endpoints.LoadOrStore(endpoint, new(atomic.Int32))
+
+	return last.(*atomic.Int32)
+}
+
+// DefaultKeyPoll is how often FileKeys checks the key file for changes
+var DefaultKeyPoll = 10 * time.Second
+
+// FileKeys provides the keys in a file, reloading it when it changes. Each line
+// is a key, primary first, or an endpoint host[:port] and its key, separated by
+// whitespace. Blank lines and lines starting with # are ignored.
+//
+//	# Primary and secondary keys
+//	YOURAPIKEY
+//	YOURNEWAPIKEY
+//
+//	# On-prem end-point
+//	zetascan.internal:8080 YOURONPREMKEY
+type FileKeys struct {
+	Path string
+
+	keys    atomic.Pointer[KeySet]
+	modTime atomic.Int64
+	err     atomic.Pointer[error]
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewFileKeys loads the keys from a file, and checks it for changes every poll
+// interval (DefaultKeyPoll if 0) until closed
+func NewFileKeys(path string, poll time.Duration) (*FileKeys, error) {
+
+	fk := &FileKeys{Path: path, stop: make(chan struct{})}
+
+	if err := fk.Reload(); err != nil {
+		return nil, err
+	}
+
+	if poll <= 0 {
+		poll = DefaultKeyPoll
+	}
+
+	go fk.watch(poll)
+
+	return fk, nil
+}
+
+// Keys implements KeyProvider
+func (fk *FileKeys) Keys(endpoint string) []Secret {
+	return fk.keys.Load().For(endpoint)
+}
+
+// Reload reads the key file, keeping the current keys if it can't be read
+func (fk *FileKeys) Reload() error {
+
+	info, err := os.Stat(fk.Path)
+
+	if err == nil {
+		var ks *KeySet
+		if ks, err = readKeyFile(fk.Path); err == nil {
+			fk.keys.Store(ks)
+			fk.modTime.Store(info.ModTime().UnixNano())
+		}
+	}
+
+	if err != nil {
+		fk.err.Store(&err)
+	} else {
+		fk.err.Store(nil)
+	}
+
+	return err
+}
+
+// Err return the error of the last reload, if it failed
+func (fk *FileKeys) Err() error {
+
+	if err := fk.err.Load(); err != nil {
+		return *err
+	}
+
+	return nil
+}
+
+// Close stops watching the file
+func (fk *FileKeys) Close() error {
+
+	fk.once.Do(func() { close(fk.stop) })
+
+	return nil
+}
+
+// watch reloads the file when its modification time changes
+func (fk *FileKeys) watch(poll time.Duration) {
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fk.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if info, err := os.Stat(fk.Path); err != nil || info.ModTime().UnixNano() != fk.modTime.Load() {
+			fk.Reload()
+		}
+	}
+}
+
+// readKeyFile parses a key file
+func readKeyFile(path string) (*KeySet, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	ks := &KeySet{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		switch len(fields) {
+		case 1:
+			ks.Default = append(ks.Default, Secret(fields[0]))
+
+		case 2:
+			if ks.Endpoints == nil {
+				ks.Endpoints = make(map[string][]Secret)
+			}
+			host := strings.ToLower(fields[0])
+			if _, h, ok := strings.Cut(host, "://"); ok {
+				host = h
+			}
+			ks.Endpoints[host] = append(ks.Endpoints[host], Secret(fields[1]))
+
+		default:
+			return nil, errors.New("Invalid key file " + path + ", expected a key or an endpoint and key per line")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ks.Default) == 0 && len(ks.Endpoints) == 0 {
+		return nil, errors.New("No keys in key file " + path)
+	}
+
+	return ks, nil
+}
@@ -0,0 +1,129 @@
+package zetascan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Metrics receives measurements of the queries made by an Api, see Api.Metrics.
+// Implementations must be safe for concurrent use, zetascan/prommetrics is an
+// adapter for Prometheus.
+type Metrics interface {
+	// ObserveQuery is called once for every query, after any retries or failover
+	ObserveQuery(q QueryMetric)
+
+	// ObserveRetry is called when a DNS query is retried, for a timeout or truncated answer
+	ObserveRetry(method, endpoint, reason string)
+
+	// ObserveFailover is called when a web query fails over to the next endpoint
+	ObserveFailover(method, from, to string)
+
+	// ObserveCache is called for every cache lookup
+	ObserveCache(method string, hit bool)
+}
+
+// QueryMetric describes a completed query
+type QueryMetric struct {
+	Method   string
+	Endpoint string        // The endpoint that answered, or dns://server
+	Verdict  string        // listed, whitelisted, not_listed or error
+	Error    string        // The error type (see ErrorType), empty on success
+	Duration time.Duration // Including any retries or failover
+}
+
+// Verdicts reported in QueryMetric and spans
+const (
+	VerdictListed      = "listed"
+	VerdictWhitelisted = "whitelisted"
+	VerdictNotListed   = "not_listed"
+	VerdictError       = "error"
+)
+
+// observeQuery reports a completed query to the metrics hook, if any
+func (myapi Api) observeQuery(start time.Time, endpoint string, m JsonRecord, err error) {
+
+	if myapi.Metrics == nil {
+		return
+	}
+
+	q := QueryMetric{
+		Method:   myapi.ApiMethod,
+		Endpoint: endpoint,
+		Verdict:  myapi.verdictOf(m, err),
+		Duration: time.Since(start),
+	}
+
+	if err != nil {
+		q.Error = ErrorType(err)
+	}
+
+	myapi.Metrics.ObserveQuery(q)
+}
+
+// verdictOf return the verdict of a query for metrics and traces
+func (myapi Api) verdictOf(m JsonRecord, err error) string {
+
+	switch {
+	case err != nil:
+		return VerdictError
+	case len(m.Results) > 0 && myapi.IsBlackList(&m):
+		return VerdictListed
+	case len(m.Results) > 0 && myapi.IsWhiteList(&m):
+		return VerdictWhitelisted
+	}
+
+	return VerdictNotListed
+}
+
+// ErrorType classifies a query error, for metrics and error rates:
+// invalid_item, timeout, canceled, forbidden, rate_limited, server_error,
+// http_status, dns_rcode, parse, network or other
+func ErrorType(err error) string {
+
+	var itemErr *ItemError
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	var statusErr *StatusError
+
+	msg := err.Error()
+
+	switch {
+	case errors.As(err, &itemErr):
+		return "invalid_item"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &statusErr):
+		return statusErrorType(statusErr.Code)
+	case strings.HasPrefix(msg, "DNS query failed"):
+		return "dns_rcode"
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF), strings.HasPrefix(msg, "Malformed"):
+		return "parse"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+
+	return "other"
+}
+
+// statusErrorType return the ErrorType of an HTTP status
+func statusErrorType(code int) string {
+
+	switch {
+	case code == http.StatusForbidden:
+		return "forbidden"
+	case code == http.StatusTooManyRequests:
+		return "rate_limited"
+	case code >= 500:
+		return "server_error"
+	}
+
+	return "http_status"
+}
@@ -0,0 +1,249 @@
+// Package middleware provides net/http middleware that blocks clients listed by zetascan
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// DecideFunc return true if a request should be blocked for the zetascan result
+type DecideFunc func(r *http.Request, m zetascan.JsonRecord) bool
+
+// Verdict is the outcome of a lookup, available to downstream handlers via FromContext
+type Verdict struct {
+	IP      string              // Client IP that was queried
+	Record  zetascan.JsonRecord // Zetascan response, empty if the lookup failed
+	Blocked bool                // True if the request was (or should have been) blocked
+	Err     error               // Lookup error, if any
+}
+
+type verdictKey struct{}
+
+// Middleware wraps a http.Handler, querying the client IP against zetascan
+type Middleware struct {
+	Api zetascan.Api
+
+	// Decide if a request is blocked, defaults to a blacklist hit
+	Decide DecideFunc
+
+	// FailOpen allows requests through when the lookup fails, otherwise they are blocked
+	FailOpen bool
+
+	// BlockHandler writes the response for blocked requests, defaults to a 403
+	BlockHandler http.Handler
+
+	trusted []netip.Prefix
+}
+
+// New return a middleware using the specified Api, with default settings
+func New(api zetascan.Api) *Middleware {
+
+	return &Middleware{
+		Api:          api,
+		Decide:       DefaultDecide,
+		BlockHandler: http.HandlerFunc(defaultBlock),
+	}
+}
+
+// DefaultDecide blocks blacklisted records that are not also whitelisted
+func DefaultDecide(r *http.Request, m zetascan.JsonRecord) bool {
+
+	if len(m.Results) == 0 {
+		return false
+	}
+
+	return m.Results[0].Found && !m.Results[0].Wl
+}
+
+// ScoreAbove return a DecideFunc blocking blacklisted records with a WebScore above threshold
+func ScoreAbove(threshold float64) DecideFunc {
+
+	return func(r *http.Request, m zetascan.JsonRecord) bool {
+		return DefaultDecide(r, m) && m.Results[0].WebScore > threshold
+	}
+}
+
+// TrustProxies sets the proxy CIDRs (or single IPs) whose X-Forwarded-For and
+// Forwarded headers are trusted to carry the client IP
+func (mw *Middleware) TrustProxies(cidrs ...string) error {
+
+	for _, cidr := range cidrs {
+
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return err
+			}
+			mw.trusted = append(mw.trusted, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return err
+		}
+
+		mw.trusted = append(mw.trusted, prefix.Masked())
+	}
+
+	return nil
+}
+
+// Handler wraps next, blocking requests from listed clients
+func (mw *Middleware) Handler(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		verdict := Verdict{IP: mw.ClientIP(r)}
+
+		verdict.Record, verdict.Err = mw.Api.QueryContext(r.Context(), verdict.IP)
+
+		if verdict.Err != nil {
+			verdict.Blocked = !mw.FailOpen
+		} else {
+			verdict.Blocked = mw.decide(r, verdict.Record)
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), verdictKey{}, verdict))
+
+		if verdict.Blocked {
+			mw.block(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FromContext return the verdict stored by the middleware for a request
+func FromContext(ctx context.Context) (verdict Verdict, ok bool) {
+	verdict, ok = ctx.Value(verdictKey{}).(Verdict)
+	return verdict, ok
+}
+
+// ClientIP return the IP of the client, honouring forwarding headers set by trusted proxies
+func (mw *Middleware) ClientIP(r *http.Request) string {
+
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !mw.isTrusted(addr) {
+		return remote
+	}
+
+	// Prefer the standard Forwarded header, fallback to X-Forwarded-For
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	// Walk back from the nearest hop, the first untrusted address is the client
+	client := addr
+	for i := len(hops) - 1; i >= 0; i-- {
+
+		hop, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break
+		}
+
+		client = hop.Unmap()
+		if !mw.isTrusted(client) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+func (mw *Middleware) isTrusted(addr netip.Addr) bool {
+
+	addr = addr.Unmap()
+	for _, prefix := range mw.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (mw *Middleware) decide(r *http.Request, m zetascan.JsonRecord) bool {
+
+	if mw.Decide == nil {
+		return DefaultDecide(r, m)
+	}
+
+	return mw.Decide(r, m)
+}
+
+// block writes the response for a blocked request, via BlockHandler if set
+func (mw *Middleware) block(w http.ResponseWriter, r *http.Request) {
+
+	if mw.BlockHandler == nil {
+		defaultBlock(w, r)
+		return
+	}
+
+	mw.BlockHandler.ServeHTTP(w, r)
+}
+
+// xForwardedFor return each address in X-Forwarded-For headers, nearest hop last
+func xForwardedFor(headers []string) (hops []string) {
+
+	for _, header := range headers {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, stripHostPort(strings.TrimSpace(hop)))
+		}
+	}
+
+	return hops
+}
+
+// forwardedFor return the for= address of each element in RFC 7239 Forwarded headers
+func forwardedFor(headers []string) (hops []string) {
+
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+
+				hops = append(hops, stripHostPort(strings.Trim(value, `"`)))
+			}
+		}
+	}
+
+	return hops
+}
+
+// stripHostPort removes brackets and ports, e.g [2001:db8::1]:4711 or 192.0.2.1:80
+func stripHostPort(host string) string {
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return host
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+func defaultBlock(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("403: Request denied - Blacklist hit!"))
+}
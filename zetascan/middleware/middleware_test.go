@@ -0,0 +1,201 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/middleware"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+func TestClientIP(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		trusted   []string
+		remote    string
+		forwarded []string
+		xff       []string
+		want      string
+	}{
+		{"no proxies", nil, "192.0.2.1:1234", nil, []string{"127.9.9.1"}, "192.0.2.1"},
+		{"untrusted remote", []string{"10.0.0.0/8"}, "192.0.2.1:1234", nil, []string{"127.9.9.1"}, "192.0.2.1"},
+		{"trusted X-Forwarded-For", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, []string{"127.9.9.1"}, "127.9.9.1"},
+		{"trusted single IP", []string{"10.0.0.1"}, "10.0.0.1:1234", nil, []string{"127.9.9.1"}, "127.9.9.1"},
+		{"chain of proxies", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, []string{"127.9.9.1, 10.0.0.2"}, "127.9.9.1"},
+		{"spoofed leftmost hop", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, []string{"127.9.9.4, 127.9.9.1"}, "127.9.9.1"},
+		{"spoofed across headers", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, []string{"127.9.9.4", "127.9.9.1, 10.0.0.2"}, "127.9.9.1"},
+		{"X-Forwarded-For port", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, []string{"127.9.9.1:4711"}, "127.9.9.1"},
+		{"Forwarded", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{`for=127.9.9.1;proto=https`}, nil, "127.9.9.1"},
+		{"Forwarded over X-Forwarded-For", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"for=127.9.9.1"}, []string{"127.9.9.3"}, "127.9.9.1"},
+		{"Forwarded IPv6", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{`For="[2001:db8::1]:4711"`}, nil, "2001:db8::1"},
+		{"Forwarded spoofed", []string{"10.0.0.0/8"}, "10.0.0.1:1234", []string{"for=127.9.9.4, for=127.9.9.1;by=10.0.0.1"}, nil, "127.9.9.1"},
+		{"IPv4-mapped proxy", []string{"10.0.0.0/8"}, "[::ffff:10.0.0.1]:1234", nil, []string{"127.9.9.1"}, "127.9.9.1"},
+		{"only proxies", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"no header", []string{"10.0.0.0/8"}, "10.0.0.1:1234", nil, nil, "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			mw := middleware.New(zetascan.Api{})
+
+			if err := mw.TrustProxies(test.trusted...); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remote
+
+			for _, v := range test.forwarded {
+				r.Header.Add("Forwarded", v)
+			}
+
+			for _, v := range test.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			if got := mw.ClientIP(r); got != test.want {
+				t.Errorf("ClientIP = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTrustProxiesErrors(t *testing.T) {
+
+	mw := middleware.New(zetascan.Api{})
+
+	for _, cidr := range []string{"10.0.0", "10.0.0.0/33", "proxy.internal"} {
+		if err := mw.TrustProxies(cidr); err == nil {
+			t.Errorf("TrustProxies(%q) accepted", cidr)
+		}
+	}
+}
+
+// serve runs a request from remote through the middleware, returning the status and the verdict seen by the handler
+func serve(mw *middleware.Middleware, remote string) (int, middleware.Verdict) {
+
+	var verdict middleware.Verdict
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verdict, _ = middleware.FromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remote
+
+	w := httptest.NewRecorder()
+	mw.Handler(next).ServeHTTP(w, r)
+
+	return w.Code, verdict
+}
+
+func TestHandler(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	tests := []struct {
+		remote string
+		want   int
+	}{
+		{"127.9.9.1:1234", http.StatusForbidden}, // Listed
+		{"127.9.9.2:1234", http.StatusForbidden}, // Listed in the PBL
+		{"127.9.9.4:1234", http.StatusOK},        // Whitelisted
+		{"192.0.2.1:1234", http.StatusOK},        // Not listed
+	}
+
+	mw := middleware.New(myzetascan)
+
+	for _, test := range tests {
+
+		code, verdict := serve(mw, test.remote)
+
+		if code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.remote, code, test.want)
+		}
+
+		if code == http.StatusOK && (verdict.Blocked || verdict.Err != nil || len(verdict.Record.Results) != 1) {
+			t.Errorf("%s: verdict = %+v", test.remote, verdict)
+		}
+	}
+
+	// A score threshold only blocks above it
+	mw.Decide = middleware.ScoreAbove(0.5)
+
+	if code, _ := serve(mw, "127.9.9.2:1234"); code != http.StatusOK {
+		t.Errorf("ScoreAbove(0.5) blocked a score of 0.2, status %d", code)
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.InjectMethod("json", zetascantest.Burst(http.StatusForbidden, -1))
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	mw := middleware.New(myzetascan)
+
+	if code, _ := serve(mw, "192.0.2.1:1234"); code != http.StatusForbidden {
+		t.Errorf("fail closed: status = %d, want 403", code)
+	}
+
+	mw.FailOpen = true
+
+	code, verdict := serve(mw, "192.0.2.1:1234")
+
+	if code != http.StatusOK || verdict.Err == nil || verdict.Blocked {
+		t.Errorf("fail open: status = %d, verdict = %+v", code, verdict)
+	}
+}
+
+func TestBlockHandler(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	mw := middleware.New(myzetascan)
+
+	mw.BlockHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		verdict, ok := middleware.FromContext(r.Context())
+
+		if !ok || verdict.IP != "127.9.9.1" || !verdict.Blocked {
+			t.Errorf("blocked verdict = %+v, %v", verdict, ok)
+		}
+
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	if code, _ := serve(mw, "127.9.9.1:1234"); code != http.StatusTeapot {
+		t.Errorf("custom BlockHandler: status = %d, want 418", code)
+	}
+
+	// A nil BlockHandler falls back to the default 403
+	mw.BlockHandler = nil
+
+	if code, _ := serve(mw, "127.9.9.1:1234"); code != http.StatusForbidden {
+		t.Errorf("nil BlockHandler: status = %d, want 403", code)
+	}
+
+	// As does a zero Middleware
+	zero := &middleware.Middleware{Api: myzetascan}
+
+	if code, _ := serve(zero, "127.9.9.1:1234"); code != http.StatusForbidden {
+		t.Errorf("zero Middleware: status = %d, want 403", code)
+	}
+}
@@ -0,0 +1,120 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Headers carrying addresses whose domains are checked
+var addressHeaders = []string{"From", "Sender", "Reply-To", "Return-Path"}
+
+// urlPattern matches http(s) URLs and bare www. hostnames in text or HTML
+var urlPattern = regexp.MustCompile(`(?i)(?:https?://|\bwww\.)[^\s<>"'()\[\]{}]+`)
+
+// Limit nested MIME parts, to avoid abuse
+const maxMIMEDepth = 10
+
+// headerAddresses return the addresses in the From, Sender, Reply-To and Return-Path headers
+func headerAddresses(header textproto.MIMEHeader) (addresses []string) {
+
+	for _, name := range addressHeaders {
+		for _, value := range header.Values(name) {
+
+			addrs, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+
+			for _, addr := range addrs {
+				addresses = append(addresses, addr.Address)
+			}
+		}
+	}
+
+	return addresses
+}
+
+// bodyHosts return the hostnames of URLs in a message body, decoding MIME parts as required
+func bodyHosts(header textproto.MIMEHeader, body []byte) (hosts []string) {
+
+	var text bytes.Buffer
+	decodePart(&text, header, body, 0)
+
+	for _, match := range urlPattern.FindAllString(text.String(), -1) {
+
+		if !strings.Contains(match, "://") {
+			match = "http://" + match
+		}
+
+		// Drop trailing punctuation from the end of a sentence
+		match = strings.TrimRight(match, ".,;:!?")
+
+		item, err := zetascan.ParseItem(match)
+
+		if err != nil {
+			continue
+		}
+
+		hosts = append(hosts, item.Host)
+	}
+
+	return hosts
+}
+
+// decodePart writes the decoded text of a MIME part to w, recursing into multiparts
+func decodePart(w *bytes.Buffer, header textproto.MIMEHeader, body []byte, depth int) {
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < maxMIMEDepth {
+
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+		for {
+			part, err := mr.NextRawPart()
+			if err != nil {
+				break
+			}
+
+			data, _ := io.ReadAll(part)
+			decodePart(w, part.Header, data, depth+1)
+		}
+
+		return
+	}
+
+	// Only scan text, skipping attachments such as images
+	if !strings.HasPrefix(mediaType, "text/") {
+		return
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "quoted-printable":
+		data, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+		if err == nil || len(data) > 0 {
+			body = data
+		}
+	case "base64":
+		data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(body)))
+		if err == nil || len(data) > 0 {
+			body = data
+		}
+	}
+
+	w.Write(body)
+	w.WriteByte('\n')
+}
@@ -0,0 +1,683 @@
+// Package milter implements a milter (libmilter wire protocol) server for
+// Sendmail and Postfix, checking the connecting IP, envelope and header
+// domains and the URLs in message bodies against zetascan.
+package milter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Config controls which stages are checked and the action taken for each score
+type Config struct {
+	CheckConnect  bool // Query the connecting IP
+	CheckEnvelope bool // Query the MAIL FROM and RCPT TO domains
+	CheckHeaders  bool // Query the From, Sender, Reply-To and Return-Path domains
+	CheckBody     bool // Query the hostnames of URLs in the body
+
+	// Also query the registered domain of each URL hostname
+	RegisteredDomains bool
+
+	// Scores (MTA score) at or above which a message is rejected or quarantined, 0 to disable
+	RejectScore     float64
+	QuarantineScore float64
+
+	// Add X-Zetascan-Score and X-Zetascan-Sources headers to messages
+	AddHeaders bool
+
+	// Temporarily fail messages when a lookup fails, otherwise they are accepted
+	TempFailOnError bool
+
+	// Limits on the body scanned, and the URL hostnames queried per message
+	MaxBodySize int
+	MaxURLs     int
+
+	// Maximum time for the lookups of a single stage
+	Timeout time.Duration
+}
+
+// DefaultConfig checks all stages, rejecting scores of 0.9+, quarantining 0.5+ and adding headers
+var DefaultConfig = Config{
+	CheckConnect:    true,
+	CheckEnvelope:   true,
+	CheckHeaders:    true,
+	CheckBody:       true,
+	RejectScore:     0.9,
+	QuarantineScore: 0.5,
+	AddHeaders:      true,
+	MaxBodySize:     1 << 20,
+	MaxURLs:         50,
+	Timeout:         10 * time.Second,
+}
+
+// Server accepts milter connections from the MTA
+type Server struct {
+	Api    zetascan.Api
+	Config Config
+
+	// ErrorLog receives connection and lookup errors, if set
+	ErrorLog func(err error)
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closing   bool
+	wg        sync.WaitGroup // Open connections
+}
+
+// NewServer return a milter server using the default configuration
+func NewServer(api zetascan.Api) *Server {
+	return &Server{Api: api, Config: DefaultConfig}
+}
+
+// ListenAndServe listens on a tcp or unix socket, e.g ("tcp", "127.0.0.1:8891")
+func (s *Server) ListenAndServe(network, address string) error {
+
+	l, err := net.Listen(network, address)
+
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed
+func (s *Server) Serve(l net.Listener) error {
+
+	s.mu.Lock()
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+
+		sess := &session{server: s, conn: conn}
+		go sess.serve()
+	}
+}
+
+// track adds an open connection, unless shutting down
+func (s *Server) track(conn net.Conn) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+
+	s.conns[conn] = true
+	s.wg.Add(1)
+
+	return true
+}
+
+// untrack removes a closed connection
+func (s *Server) untrack(conn net.Conn) {
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.wg.Done()
+}
+
+// Close stops all listeners, open connections are closed by the MTA
+func (s *Server) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Shutdown stops all listeners, and waits for open connections to answer the
+// command being checked until ctx is done, then closes them. The MTA applies
+// its milter default action to messages in progress.
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	err := s.Close()
+
+	s.mu.Lock()
+	s.closing = true
+
+	// Connections waiting for their next command stop reading at once
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// shuttingDown return if Shutdown has been called
+func (s *Server) shuttingDown() bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+func (s *Server) logError(err error) {
+
+	if s.ErrorLog != nil {
+		s.ErrorLog(err)
+	}
+}
+
+// hit is a listed item found while processing a message
+type hit struct {
+	stage  string
+	item   string
+	result zetascan.JsonResult
+}
+
+// session is the state of one MTA connection
+type session struct {
+	server *Server
+	conn   net.Conn
+
+	actions uint32
+
+	// Connection state, kept across messages
+	connHits []hit
+	failed   bool
+
+	// Message state, reset after each message
+	hits    []hit
+	msgFail bool
+	seen    map[string]bool
+	header  textproto.MIMEHeader
+	body    bytes.Buffer
+}
+
+// serve reads and answers commands until the MTA quits
+func (sess *session) serve() {
+
+	defer sess.server.untrack(sess.conn)
+	defer sess.conn.Close()
+
+	r := bufio.NewReader(sess.conn)
+	sess.resetMessage()
+
+	for {
+		cmd, data, err := readPacket(r)
+
+		if err != nil {
+			if err != io.EOF && !sess.server.shuttingDown() {
+				sess.server.logError(err)
+			}
+			return
+		}
+
+		resp, respData, quit := sess.handle(cmd, data)
+
+		if quit {
+			return
+		}
+
+		// Macros and aborts are not answered
+		if resp == 0 {
+			continue
+		}
+
+		if err := writePacket(sess.conn, resp, respData); err != nil {
+			sess.server.logError(err)
+			return
+		}
+	}
+}
+
+// handle processes a command, returning the response (0 for none)
+func (sess *session) handle(cmd byte, data []byte) (resp byte, respData []byte, quit bool) {
+
+	config := sess.server.Config
+
+	switch cmd {
+
+	case cmdOptNeg:
+		return sess.negotiate(data)
+
+	case cmdMacro:
+		return 0, nil, false
+
+	case cmdConnect:
+		sess.connHits = nil
+		sess.failed = false
+
+		if !config.CheckConnect {
+			return respContinue, nil, false
+		}
+
+		addr := connectAddress(data)
+
+		if addr == "" {
+			return respContinue, nil, false
+		}
+
+		hits, failed := sess.lookup("connect", []string{addr})
+		sess.connHits = hits
+		sess.failed = failed
+
+		// A whitelisted client is trusted, skip all further checks
+		for _, h := range hits {
+			if h.result.Wl {
+				return respAccept, nil, false
+			}
+		}
+
+		return sess.early(hits)
+
+	case cmdHelo:
+		return respContinue, nil, false
+
+	case cmdMail, cmdRcpt:
+		args := splitStrings(data)
+
+		if !config.CheckEnvelope || len(args) == 0 {
+			return respContinue, nil, false
+		}
+
+		stage := "mail"
+		if cmd == cmdRcpt {
+			stage = "rcpt"
+		}
+
+		addr := strings.Trim(args[0], "<>")
+
+		if addr == "" {
+			return respContinue, nil, false
+		}
+
+		hits, failed := sess.lookup(stage, []string{addr})
+		sess.hits = append(sess.hits, hits...)
+		sess.msgFail = sess.msgFail || failed
+
+		return sess.early(hits)
+
+	case cmdHeader:
+		fields := splitStrings(data)
+
+		if len(fields) == 2 {
+			sess.header.Add(textproto.CanonicalMIMEHeaderKey(fields[0]), strings.TrimSpace(fields[1]))
+		}
+
+		return respContinue, nil, false
+
+	case cmdEOH:
+		if !config.CheckHeaders {
+			return respContinue, nil, false
+		}
+
+		hits, failed := sess.lookup("header", headerAddresses(sess.header))
+		sess.hits = append(sess.hits, hits...)
+		sess.msgFail = sess.msgFail || failed
+
+		return respContinue, nil, false
+
+	case cmdBody:
+		if config.CheckBody {
+			if config.MaxBodySize > 0 && sess.body.Len()+len(data) > config.MaxBodySize {
+				data = data[:max(0, config.MaxBodySize-sess.body.Len())]
+			}
+			sess.body.Write(data)
+		}
+
+		return respContinue, nil, false
+
+	case cmdEOB:
+		return sess.endOfMessage()
+
+	case cmdAbort:
+		sess.resetMessage()
+		return 0, nil, false
+
+	case cmdQuitNC:
+		sess.connHits = nil
+		sess.failed = false
+		sess.resetMessage()
+		return 0, nil, false
+
+	case cmdQuit:
+		return 0, nil, true
+
+	}
+
+	// Data, unknown commands and anything else
+	return respContinue, nil, false
+}
+
+// negotiate agrees the protocol version, actions and steps with the MTA
+func (sess *session) negotiate(data []byte) (resp byte, respData []byte, quit bool) {
+
+	if len(data) < 12 {
+		sess.server.logError(errors.New("milter option negotiation too short"))
+		return 0, nil, true
+	}
+
+	version := binary.BigEndian.Uint32(data[0:4])
+	actions := binary.BigEndian.Uint32(data[4:8])
+	protocol := binary.BigEndian.Uint32(data[8:12])
+
+	if version > protocolVersion {
+		version = protocolVersion
+	}
+
+	sess.actions = actions & (actionAddHeaders | actionQuarantine)
+
+	respData = make([]byte, 12)
+	binary.BigEndian.PutUint32(respData[0:4], version)
+	binary.BigEndian.PutUint32(respData[4:8], sess.actions)
+	binary.BigEndian.PutUint32(respData[8:12], protocol&(protoNoUnknown|protoNoData))
+
+	return respOptNeg, respData, false
+}
+
+// endOfMessage queries the body URLs, then decides the action for the message
+func (sess *session) endOfMessage() (resp byte, respData []byte, quit bool) {
+
+	config := sess.server.Config
+	defer sess.resetMessage()
+
+	if config.CheckBody {
+
+		hosts := bodyHosts(sess.header, sess.body.Bytes())
+
+		if config.RegisteredDomains {
+			hosts = withRegistered(hosts)
+		}
+
+		if config.MaxURLs > 0 && len(hosts) > config.MaxURLs {
+			hosts = hosts[:config.MaxURLs]
+		}
+
+		hits, failed := sess.lookup("body", hosts)
+		sess.hits = append(sess.hits, hits...)
+		sess.msgFail = sess.msgFail || failed
+	}
+
+	hits := append(append([]hit{}, sess.connHits...), sess.hits...)
+	worst, score := worstHit(hits)
+
+	if worst != nil && config.RejectScore > 0 && score >= config.RejectScore {
+		return reject(worst)
+	}
+
+	if (sess.failed || sess.msgFail) && config.TempFailOnError {
+		return respTempFail, nil, false
+	}
+
+	// Header and quarantine modifications are sent before the final response
+	if config.AddHeaders && sess.actions&actionAddHeaders != 0 {
+
+		var sources []string
+		seen := make(map[string]bool)
+
+		for _, h := range hits {
+			for _, source := range h.result.Sources {
+				if source != "" && !seen[source] {
+					seen[source] = true
+					sources = append(sources, source)
+				}
+			}
+		}
+
+		sort.Strings(sources)
+
+		if err := sess.modify(respAddHeader, joinStrings("X-Zetascan-Score", strconv.FormatFloat(score, 'f', -1, 64))); err != nil {
+			return 0, nil, true
+		}
+
+		if len(sources) > 0 {
+			if err := sess.modify(respAddHeader, joinStrings("X-Zetascan-Sources", strings.Join(sources, ", "))); err != nil {
+				return 0, nil, true
+			}
+		}
+	}
+
+	if worst != nil && config.QuarantineScore > 0 && score >= config.QuarantineScore && sess.actions&actionQuarantine != 0 {
+		if err := sess.modify(respQuarantine, joinStrings(describe(worst))); err != nil {
+			return 0, nil, true
+		}
+	}
+
+	return respContinue, nil, false
+}
+
+// modify sends a modification action during end of message
+func (sess *session) modify(cmd byte, data []byte) error {
+
+	err := writePacket(sess.conn, cmd, data)
+
+	if err != nil {
+		sess.server.logError(err)
+	}
+
+	return err
+}
+
+// early rejects at the current stage if a hit meets the reject score
+func (sess *session) early(hits []hit) (resp byte, respData []byte, quit bool) {
+
+	config := sess.server.Config
+	worst, score := worstHit(hits)
+
+	if worst != nil && config.RejectScore > 0 && score >= config.RejectScore {
+		return reject(worst)
+	}
+
+	return respContinue, nil, false
+}
+
+// lookup queries items not already queried for this message, returning listed hits
+func (sess *session) lookup(stage string, items []string) (hits []hit, failed bool) {
+
+	var query []string
+
+	for _, raw := range items {
+
+		item, err := zetascan.ParseItem(raw)
+
+		if err != nil || sess.seen[item.Host] {
+			continue
+		}
+
+		sess.seen[item.Host] = true
+		query = append(query, item.Host)
+	}
+
+	if len(query) == 0 {
+		return nil, false
+	}
+
+	ctx := context.Background()
+
+	if sess.server.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sess.server.Config.Timeout)
+		defer cancel()
+	}
+
+	for _, result := range sess.server.Api.QueryBatch(ctx, query, 0) {
+
+		if result.Err != nil {
+			sess.server.logError(fmt.Errorf("%s %s: %w", stage, result.Item, result.Err))
+			failed = true
+			continue
+		}
+
+		if len(result.Record.Results) == 0 {
+			continue
+		}
+
+		r := result.Record.Results[0]
+
+		if r.Found || r.Wl {
+			hits = append(hits, hit{stage: stage, item: result.Item, result: r})
+		}
+	}
+
+	return hits, failed
+}
+
+func (sess *session) resetMessage() {
+
+	sess.hits = nil
+	sess.msgFail = false
+	sess.seen = make(map[string]bool)
+	sess.header = make(textproto.MIMEHeader)
+	sess.body.Reset()
+}
+
+// worstHit return the blacklisted hit with the highest score
+func worstHit(hits []hit) (worst *hit, score float64) {
+
+	for i := range hits {
+
+		h := &hits[i]
+
+		if h.result.Found && !h.result.Wl && (worst == nil || h.result.Score > score) {
+			worst = h
+			score = h.result.Score
+		}
+	}
+
+	return worst, score
+}
+
+// reject return a 550 reply code for a listed item
+func reject(h *hit) (resp byte, respData []byte, quit bool) {
+	return respReplyCode, joinStrings("550 5.7.1 Rejected, " + describe(h)), false
+}
+
+// describe return a single line reason for a listed item
+func describe(h *hit) string {
+
+	reason := fmt.Sprintf("%s %s listed by Zetascan", h.stage, h.item)
+
+	var sources []string
+	for _, source := range h.result.Sources {
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+
+	if len(sources) > 0 {
+		reason += " in " + strings.Join(sources, ",")
+	}
+
+	return reason
+}
+
+// withRegistered adds the registered domain of each host, deduplicated
+func withRegistered(hosts []string) (out []string) {
+
+	seen := make(map[string]bool)
+
+	for _, host := range hosts {
+
+		labels, err := zetascan.DomainLabels(host, false)
+
+		if err != nil {
+			labels = []string{host}
+		}
+
+		for _, label := range labels {
+			if !seen[label] {
+				seen[label] = true
+				out = append(out, label)
+			}
+		}
+	}
+
+	return out
+}
+
+// connectAddress return the IP address from SMFIC_CONNECT data
+func connectAddress(data []byte) string {
+
+	// hostname\0 family [port address\0]
+	i := bytes.IndexByte(data, 0)
+
+	if i < 0 || len(data) < i+4 {
+		return ""
+	}
+
+	family := data[i+1]
+
+	if family != '4' && family != '6' {
+		return ""
+	}
+
+	addr := strings.TrimSuffix(string(data[i+4:]), "\x00")
+
+	// Sendmail prefixes IPv6 addresses with IPv6:
+	return strings.TrimPrefix(addr, "IPv6:")
+}
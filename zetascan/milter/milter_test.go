@@ -0,0 +1,437 @@
+package milter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/textproto"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+// converse serves one end of a pipe, returning the other
+func converse(t *testing.T, s *Server) net.Conn {
+
+	client, conn := net.Pipe()
+
+	if !s.track(conn) {
+		t.Fatal("server is shut down")
+	}
+
+	sess := &session{server: s, conn: conn}
+	go sess.serve()
+
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// mta is the MTA end of a milter connection
+type mta struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newMTA(t *testing.T, s *Server) *mta {
+
+	conn := converse(t, s)
+	return &mta{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes a command without waiting for a response
+func (m *mta) send(cmd byte, data []byte) {
+
+	m.conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := writePacket(m.conn, cmd, data); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+// read return the next response
+func (m *mta) read() (resp byte, data []byte) {
+
+	resp, data, err := readPacket(m.r)
+
+	if err != nil {
+		m.t.Fatal(err)
+	}
+
+	return resp, data
+}
+
+// ask sends a command and return the response
+func (m *mta) ask(cmd byte, data []byte) (resp byte, respData []byte) {
+
+	m.send(cmd, data)
+	return m.read()
+}
+
+// negotiate offers a protocol version, actions and steps
+func (m *mta) negotiate(version, actions, protocol uint32) (uint32, uint32, uint32) {
+
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data[0:4], version)
+	binary.BigEndian.PutUint32(data[4:8], actions)
+	binary.BigEndian.PutUint32(data[8:12], protocol)
+
+	resp, respData := m.ask(cmdOptNeg, data)
+
+	if resp != respOptNeg || len(respData) != 12 {
+		m.t.Fatalf("negotiate response %q %q", resp, respData)
+	}
+
+	return binary.BigEndian.Uint32(respData[0:4]), binary.BigEndian.Uint32(respData[4:8]), binary.BigEndian.Uint32(respData[8:12])
+}
+
+// connectData encodes SMFIC_CONNECT data for an address
+func connectData(family byte, addr string) []byte {
+	return append([]byte("mx.example.net\x00"+string(family)+"\x00\x19"), joinStrings(addr)...)
+}
+
+// newServer return a milter querying the emulator via json
+func newServer(t *testing.T) (*Server, *zetascantest.Server) {
+
+	emulator := zetascantest.NewServer(nil)
+	t.Cleanup(func() { emulator.Close() })
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	return NewServer(myzetascan), emulator
+}
+
+func TestNegotiate(t *testing.T) {
+
+	s, _ := newServer(t)
+
+	tests := []struct {
+		name                string
+		version, actions    uint32
+		protocol            uint32
+		wantVersion         uint32
+		wantActions, wantPr uint32
+	}{
+		{"all offered", 6, 0x1ff, 0x1fffff, 6, actionAddHeaders | actionQuarantine, protoNoUnknown | protoNoData},
+		{"newer version", 9, 0x1ff, 0, 6, actionAddHeaders | actionQuarantine, 0},
+		{"older version", 2, actionAddHeaders, protoNoData, 2, actionAddHeaders, protoNoData},
+		{"no actions", 6, 0, 0, 6, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			version, actions, protocol := newMTA(t, s).negotiate(test.version, test.actions, test.protocol)
+
+			if version != test.wantVersion || actions != test.wantActions || protocol != test.wantPr {
+				t.Errorf("negotiated %d %#x %#x, want %d %#x %#x", version, actions, protocol, test.wantVersion, test.wantActions, test.wantPr)
+			}
+		})
+	}
+
+	// A short negotiation closes the connection
+	m := newMTA(t, s)
+	m.send(cmdOptNeg, []byte{0, 0, 0, 6})
+
+	if _, _, err := readPacket(m.r); err != io.EOF {
+		t.Errorf("short negotiation err = %v, want EOF", err)
+	}
+}
+
+func TestConnectAddress(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"IPv4", connectData('4', "127.9.9.1"), "127.9.9.1"},
+		{"IPv6", connectData('6', "2001:db8::1"), "2001:db8::1"},
+		{"Sendmail IPv6", connectData('6', "IPv6:2001:db8::1"), "2001:db8::1"},
+		{"unix socket", connectData('L', "/var/run/smtp"), ""},
+		{"unknown", []byte("mx.example.net\x00U"), ""},
+		{"truncated", []byte("mx.example.net\x004"), ""},
+		{"no hostname", []byte("mx.example.net"), ""},
+	}
+
+	for _, test := range tests {
+		if got := connectAddress(test.data); got != test.want {
+			t.Errorf("%s: connectAddress = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHeaderAddresses(t *testing.T) {
+
+	header := make(textproto.MIMEHeader)
+	header.Add("From", `"User" <user@baddomain.org>`)
+	header.Add("Reply-To", "a@okdomain.org, b@example.net")
+	header.Add("To", "to@example.com")
+	header.Add("Sender", "not an address")
+
+	want := []string{"user@baddomain.org", "a@okdomain.org", "b@example.net"}
+
+	if got := headerAddresses(header); !slices.Equal(got, want) {
+		t.Errorf("headerAddresses = %q, want %q", got, want)
+	}
+}
+
+func TestBodyHosts(t *testing.T) {
+
+	body := strings.Join([]string{
+		"--b1",
+		"Content-Type: multipart/alternative; boundary=b2",
+		"",
+		"--b2",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Visit http://www.baddomain.org/=",
+		"offer today.",
+		"--b2",
+		"Content-Type: text/html",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"PGEgaHJlZj0iaHR0cHM6Ly8xMjcuOS45LjMvIj5jbGljazwvYT4=",
+		"--b2--",
+		"--b1",
+		"Content-Type: image/png",
+		"",
+		"http://image.example.com/",
+		"--b1",
+		"Content-Type: text/plain",
+		"",
+		"See www.okdomain.org, and http://bad_host/.",
+		"--b1--",
+		"",
+	}, "\r\n")
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", `multipart/mixed; boundary="b1"`)
+
+	// The HTML part is <a href="https://127.9.9.3/">click</a>
+	want := []string{"www.baddomain.org", "127.9.9.3", "www.okdomain.org"}
+
+	if got := bodyHosts(header, []byte(body)); !slices.Equal(got, want) {
+		t.Errorf("bodyHosts = %q, want %q", got, want)
+	}
+
+	// Without a Content-Type the body is plain text
+	if got := bodyHosts(make(textproto.MIMEHeader), []byte("http://baddomain.org.")); !slices.Equal(got, []string{"baddomain.org"}) {
+		t.Errorf("plain bodyHosts = %q", got)
+	}
+}
+
+func TestServer(t *testing.T) {
+
+	s, _ := newServer(t)
+
+	const all = 0x1ff
+
+	tests := []struct {
+		name     string
+		actions  uint32
+		commands [][2]string // Command and data, each answered with continue
+		last     [2]string   // Command answered with the responses in want
+		want     []string    // Response and data, in order
+	}{
+		{
+			name: "reject connect",
+			last: [2]string{"C", "127.9.9.1"},
+			want: []string{"y550 5.7.1 Rejected, connect 127.9.9.1 listed by Zetascan in shXBL,shSBL\x00"},
+		},
+		{
+			name: "whitelisted connect",
+			last: [2]string{"C", "127.9.9.4"},
+			want: []string{"a"},
+		},
+		{
+			name:     "reject sender",
+			commands: [][2]string{{"C", "192.0.2.1"}, {"H", "mx.example.net\x00"}},
+			last:     [2]string{"M", "<user@baddomain.org>\x00SIZE=100\x00"},
+			want:     []string{"y550 5.7.1 Rejected, mail baddomain.org listed by Zetascan in shDBL,ubRed,ubGold,ubGrey,ubBlack\x00"},
+		},
+		{
+			name:     "reject header at end of message",
+			commands: [][2]string{{"C", "192.0.2.1"}, {"M", "<>\x00"}, {"R", "<to@example.com>\x00"}, {"L", "From\x00 User <user@baddomain.org>\x00"}, {"N", ""}, {"B", "Hello\r\n"}},
+			last:     [2]string{"E", ""},
+			want:     []string{"y550 5.7.1 Rejected, header baddomain.org listed by Zetascan in shDBL,ubRed,ubGold,ubGrey,ubBlack\x00"},
+		},
+		{
+			name:     "quarantine body",
+			actions:  all,
+			commands: [][2]string{{"C", "192.0.2.1"}, {"M", "<user@example.com>\x00"}, {"L", "Subject\x00hi\x00"}, {"N", ""}, {"B", "See http://127.9.9.3/ now\r\n"}},
+			last:     [2]string{"E", ""},
+			want:     []string{"hX-Zetascan-Score\x000.8\x00", "hX-Zetascan-Sources\x00shSBL\x00", "qbody 127.9.9.3 listed by Zetascan in shSBL\x00", "c"},
+		},
+		{
+			name:     "no quarantine action",
+			actions:  actionAddHeaders,
+			commands: [][2]string{{"C", "192.0.2.1"}, {"N", ""}, {"B", "See http://127.9.9.3/ now\r\n"}},
+			last:     [2]string{"E", ""},
+			want:     []string{"hX-Zetascan-Score\x000.8\x00", "hX-Zetascan-Sources\x00shSBL\x00", "c"},
+		},
+		{
+			name:     "whitelisted sources",
+			actions:  all,
+			commands: [][2]string{{"C", "127.9.9.2"}, {"M", "<user@okdomain.org>\x00"}, {"N", ""}},
+			last:     [2]string{"E", ""},
+			want:     []string{"hX-Zetascan-Score\x000.2\x00", "hX-Zetascan-Sources\x00shPBL, white\x00", "c"},
+		},
+		{
+			name:     "not listed",
+			actions:  all,
+			commands: [][2]string{{"C", "192.0.2.1"}, {"N", ""}, {"B", "Nothing here\r\n"}},
+			last:     [2]string{"E", ""},
+			want:     []string{"hX-Zetascan-Score\x000\x00", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			m := newMTA(t, s)
+			m.negotiate(protocolVersion, test.actions, 0)
+
+			// Macros are never answered
+			m.send(cmdMacro, []byte("Cj\x00mx.example.net\x00"))
+
+			for _, c := range append(test.commands, test.last) {
+
+				data := []byte(c[1])
+				if c[0] == "C" {
+					data = connectData('4', c[1])
+				}
+
+				if c == test.last {
+					m.send(c[0][0], data)
+					break
+				}
+
+				if resp, respData := m.ask(c[0][0], data); resp != respContinue {
+					t.Fatalf("%s: response %q %q, want continue", c[0], resp, respData)
+				}
+			}
+
+			for _, want := range test.want {
+				if resp, respData := m.read(); string(resp)+string(respData) != want {
+					t.Errorf("response %q, want %q", string(resp)+string(respData), want)
+				}
+			}
+		})
+	}
+}
+
+// Messages on one connection are checked separately, the connecting IP for each
+func TestServerMessages(t *testing.T) {
+
+	s, _ := newServer(t)
+	s.Config.RejectScore = 0.5
+
+	m := newMTA(t, s)
+	m.negotiate(protocolVersion, 0, 0)
+
+	if resp, _ := m.ask(cmdConnect, connectData('4', "127.9.9.2")); resp != respContinue {
+		t.Fatalf("connect response %q", resp)
+	}
+
+	// The first message is rejected for its body
+	m.ask(cmdBody, []byte("http://127.9.9.3/"))
+
+	if resp, data := m.ask(cmdEOB, nil); resp != respReplyCode || !strings.Contains(string(data), "body 127.9.9.3") {
+		t.Errorf("first message %q %q, want reject", resp, data)
+	}
+
+	// An aborted message is forgotten
+	m.ask(cmdBody, []byte("http://127.9.9.3/"))
+	m.send(cmdAbort, nil)
+
+	if resp, data := m.ask(cmdEOB, nil); resp != respContinue {
+		t.Errorf("after abort %q %q, want continue", resp, data)
+	}
+
+	// Quit closes the connection
+	m.send(cmdQuit, nil)
+
+	if _, _, err := readPacket(m.r); err != io.EOF {
+		t.Errorf("after quit err = %v, want EOF", err)
+	}
+}
+
+func TestServerTempFail(t *testing.T) {
+
+	s, emulator := newServer(t)
+	emulator.InjectMethod("json", zetascantest.Fault{Status: 503, Count: -1})
+
+	m := newMTA(t, s)
+	m.negotiate(protocolVersion, 0, 0)
+
+	// Lookup errors are accepted by default
+	m.ask(cmdConnect, connectData('4', "127.9.9.1"))
+
+	if resp, _ := m.ask(cmdEOB, nil); resp != respContinue {
+		t.Errorf("response %q, want continue", resp)
+	}
+
+	s.Config.TempFailOnError = true
+
+	m = newMTA(t, s)
+	m.negotiate(protocolVersion, 0, 0)
+	m.ask(cmdConnect, connectData('4', "127.9.9.1"))
+
+	if resp, _ := m.ask(cmdEOB, nil); resp != respTempFail {
+		t.Errorf("TempFailOnError response %q, want tempfail", resp)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+
+	s, emulator := newServer(t)
+	emulator.InjectItem("127.9.9.1", zetascantest.Fault{Latency: 200 * time.Millisecond})
+
+	idle := newMTA(t, s)
+	idle.negotiate(protocolVersion, 0, 0)
+
+	busy := newMTA(t, s)
+	busy.negotiate(protocolVersion, 0, 0)
+	busy.send(cmdConnect, connectData('4', "127.9.9.1"))
+
+	answered := make(chan byte, 1)
+	go func() {
+		resp, _, _ := readPacket(busy.r)
+		answered <- resp
+	}()
+
+	// Wait for the lookup to be in flight
+	for emulator.Requests("127.9.9.1") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-answered; got != respReplyCode {
+		t.Errorf("in-flight response %q, want reply code", got)
+	}
+
+	// The idle connection was closed
+	if _, _, err := readPacket(idle.r); err != io.EOF {
+		t.Errorf("idle connection read err = %v, want EOF", err)
+	}
+
+	// New connections are refused
+	if _, conn := net.Pipe(); s.track(conn) {
+		t.Error("connection tracked after Shutdown")
+	}
+}
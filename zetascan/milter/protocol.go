@@ -0,0 +1,117 @@
+package milter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Milter protocol version spoken (libmilter 8.14+)
+const protocolVersion = 6
+
+// Commands from the MTA
+const (
+	cmdAbort   = 'A'
+	cmdBody    = 'B'
+	cmdConnect = 'C'
+	cmdMacro   = 'D'
+	cmdEOB     = 'E'
+	cmdHelo    = 'H'
+	cmdQuitNC  = 'K'
+	cmdHeader  = 'L'
+	cmdMail    = 'M'
+	cmdEOH     = 'N'
+	cmdOptNeg  = 'O'
+	cmdQuit    = 'Q'
+	cmdRcpt    = 'R'
+	cmdData    = 'T'
+	cmdUnknown = 'U'
+)
+
+// Responses to the MTA
+const (
+	respAccept     = 'a'
+	respContinue   = 'c'
+	respAddHeader  = 'h'
+	respOptNeg     = 'O'
+	respQuarantine = 'q'
+	respReject     = 'r'
+	respTempFail   = 't'
+	respReplyCode  = 'y'
+)
+
+// Actions the milter may take, negotiated with the MTA
+const (
+	actionAddHeaders = 0x01
+	actionQuarantine = 0x20
+)
+
+// Protocol steps the milter does not need
+const (
+	protoNoUnknown = 0x100
+	protoNoData    = 0x200
+)
+
+// Largest packet accepted, body chunks are at most 64KB
+const maxPacketSize = 1 << 20
+
+var errPacketSize = errors.New("milter packet too large")
+
+// readPacket reads a length prefixed command and its data
+func readPacket(r *bufio.Reader) (cmd byte, data []byte, err error) {
+
+	var size uint32
+
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return 0, nil, err
+	}
+
+	if size == 0 || size > maxPacketSize {
+		return 0, nil, errPacketSize
+	}
+
+	packet := make([]byte, size)
+
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+
+	return packet[0], packet[1:], nil
+}
+
+// writePacket writes a length prefixed response and its data
+func writePacket(w io.Writer, cmd byte, data []byte) error {
+
+	packet := make([]byte, 5+len(data))
+	binary.BigEndian.PutUint32(packet, uint32(len(data)+1))
+	packet[4] = cmd
+	copy(packet[5:], data)
+
+	_, err := w.Write(packet)
+	return err
+}
+
+// splitStrings splits NUL terminated strings
+func splitStrings(data []byte) (strs []string) {
+
+	for _, s := range bytes.Split(bytes.TrimSuffix(data, []byte{0}), []byte{0}) {
+		strs = append(strs, string(s))
+	}
+
+	return strs
+}
+
+// joinStrings encodes each string NUL terminated
+func joinStrings(strs ...string) []byte {
+
+	var buf bytes.Buffer
+
+	for _, s := range strs {
+		buf.WriteString(s)
+		buf.WriteByte(0)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,89 @@
+// Package oteltrace is an OpenTelemetry adapter for zetascan.Tracer.
+//
+//	myzetascan.Tracer = oteltrace.New(nil) // the global TracerProvider
+//
+// Spans are children of the span in the context passed to QueryContext,
+// QueryBatch or QueryEach, so a slow request can be followed end to end.
+package oteltrace
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// InstrumentationName is the name of the OpenTelemetry tracer
+const InstrumentationName = "github.com/zetascan/go-zetascan/zetascan"
+
+// Tracer implements zetascan.Tracer with an OpenTelemetry tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New return a Tracer using the provider, or the global TracerProvider if nil
+func New(provider trace.TracerProvider) *Tracer {
+
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return &Tracer{tracer: provider.Tracer(InstrumentationName)}
+}
+
+// Start implements zetascan.Tracer
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, zetascan.Span) {
+
+	kind := trace.SpanKindInternal
+
+	switch name {
+	case zetascan.SpanHTTP, zetascan.SpanDNS:
+		kind = trace.SpanKindClient
+	}
+
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind))
+
+	return ctx, otelSpan{span}
+}
+
+// otelSpan implements zetascan.Span
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttribute(key string, value any) {
+
+	var kv attribute.KeyValue
+
+	switch v := value.(type) {
+	case string:
+		kv = attribute.String(key, v)
+	case bool:
+		kv = attribute.Bool(key, v)
+	case int:
+		kv = attribute.Int(key, v)
+	case int64:
+		kv = attribute.Int64(key, v)
+	case float64:
+		kv = attribute.Float64(key, v)
+	default:
+		kv = attribute.String(key, fmt.Sprint(v))
+	}
+
+	s.span.SetAttributes(kv)
+}
+
+func (s otelSpan) RecordError(err error) {
+
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
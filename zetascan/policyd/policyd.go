@@ -0,0 +1,491 @@
+// Package policyd implements the Postfix SMTP access policy delegation protocol,
+// answering from zetascan lookups of the client, HELO, sender and recipient.
+//
+// See http://www.postfix.org/SMTPD_POLICY_README.html for the protocol.
+package policyd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Actions returned to Postfix
+const (
+	ActionDunno         = "DUNNO"
+	ActionReject        = "REJECT"
+	ActionDeferIfPermit = "DEFER_IF_PERMIT"
+	ActionPrepend       = "PREPEND"
+)
+
+// Config controls which attributes are checked and the score thresholds for each action
+type Config struct {
+	CheckClient    bool // Query client_address
+	CheckHelo      bool // Query helo_name
+	CheckSender    bool // Query the domain of sender
+	CheckRecipient bool // Query the domain of recipient
+
+	// Scores (MTA score) at or above which a message is rejected or deferred, 0 to disable
+	RejectScore float64
+	DeferScore  float64
+
+	// Prepend a X-Zetascan-Score header for any listed item below the defer/reject thresholds
+	Prepend bool
+
+	// Action returned when a lookup fails, defaults to DUNNO
+	FailAction string
+
+	// Maximum time to answer a single policy request
+	Timeout time.Duration
+}
+
+// DefaultConfig checks all attributes, rejecting scores of 0.9+ and deferring 0.5+
+var DefaultConfig = Config{
+	CheckClient:    true,
+	CheckHelo:      true,
+	CheckSender:    true,
+	CheckRecipient: true,
+	RejectScore:    0.9,
+	DeferScore:     0.5,
+	Prepend:        true,
+	FailAction:     ActionDunno,
+	Timeout:        10 * time.Second,
+}
+
+// Server answers policy requests from Postfix
+type Server struct {
+	Api    zetascan.Api
+	Config Config
+
+	// ErrorLog receives connection and lookup errors, if set
+	ErrorLog func(err error)
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closing   bool
+	wg        sync.WaitGroup // Open connections
+}
+
+// Limits of a policy request, Postfix sends about 30 short attributes
+const (
+	MaxLineLength = 8192
+	MaxAttributes = 256
+)
+
+// NewServer return a policy server using the default configuration
+func NewServer(api zetascan.Api) *Server {
+	return &Server{Api: api, Config: DefaultConfig}
+}
+
+// ListenAndServe listens on a tcp or unix socket, e.g ("tcp", "127.0.0.1:10040")
+func (s *Server) ListenAndServe(network, address string) error {
+
+	l, err := net.Listen(network, address)
+
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// RemoveSocket removes a unix socket left by a previous run, refusing to
+// remove any other file
+func RemoveSocket(path string) error {
+
+	info, err := os.Lstat(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return errors.New("Not removing " + path + ", it is not a socket")
+	}
+
+	return os.Remove(path)
+}
+
+// Serve accepts connections on l until it is closed
+func (s *Server) Serve(l net.Listener) error {
+
+	s.mu.Lock()
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// track adds an open connection, unless shutting down
+func (s *Server) track(conn net.Conn) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+
+	s.conns[conn] = true
+	s.wg.Add(1)
+
+	return true
+}
+
+// untrack removes a closed connection
+func (s *Server) untrack(conn net.Conn) {
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.wg.Done()
+}
+
+// Close stops all listeners, open connections finish their current request
+func (s *Server) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Shutdown stops all listeners, and waits for open connections to answer the
+// request being checked until ctx is done, then closes them
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	err := s.Close()
+
+	s.mu.Lock()
+	s.closing = true
+
+	// Connections waiting for their next request stop reading at once
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// serveConn handles one Postfix connection, which may carry many requests
+func (s *Server) serveConn(conn net.Conn) {
+
+	defer s.untrack(conn)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		attrs, err := ReadRequest(r)
+
+		if err != nil {
+			if err != io.EOF && !s.shuttingDown() {
+				s.logError(err)
+			}
+			return
+		}
+
+		action := s.Check(context.Background(), attrs)
+
+		if _, err := fmt.Fprintf(conn, "action=%s\n\n", action); err != nil {
+			s.logError(err)
+			return
+		}
+	}
+}
+
+// shuttingDown return if Shutdown has been called
+func (s *Server) shuttingDown() bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+// ReadRequest reads one name=value block, terminated by an empty line. Lines
+// are limited to MaxLineLength, and requests to MaxAttributes.
+func ReadRequest(r *bufio.Reader) (attrs map[string]string, err error) {
+
+	attrs = make(map[string]string)
+
+	for {
+		line, err := readLine(r)
+
+		if err != nil {
+			if err == io.EOF && (len(attrs) > 0 || line != "") {
+				return attrs, io.ErrUnexpectedEOF
+			}
+			return attrs, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		// Empty line ends the request
+		if line == "" {
+			return attrs, nil
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+
+		if !ok {
+			return attrs, errors.New("malformed policy attribute: " + line)
+		}
+
+		if _, dup := attrs[name]; !dup && len(attrs) >= MaxAttributes {
+			return attrs, errors.New("too many policy attributes")
+		}
+
+		attrs[name] = value
+	}
+}
+
+// readLine reads a line of up to MaxLineLength, including the newline
+func readLine(r *bufio.Reader) (string, error) {
+
+	var line []byte
+
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		if len(line) > MaxLineLength {
+			return "", errors.New("policy attribute too long")
+		}
+
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// lookup is an item queried for a request, and the attribute it came from
+type lookup struct {
+	attr   string
+	item   string
+	record zetascan.JsonRecord
+	err    error
+}
+
+// Check return the action for a policy request
+func (s *Server) Check(ctx context.Context, attrs map[string]string) string {
+
+	if attrs["request"] != "smtpd_access_policy" {
+		return ActionDunno
+	}
+
+	if s.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Config.Timeout)
+		defer cancel()
+	}
+
+	lookups := s.lookups(attrs)
+
+	// Query all items at once, Postfix is waiting
+	var wg sync.WaitGroup
+	for i := range lookups {
+		wg.Add(1)
+		go func(l *lookup) {
+			defer wg.Done()
+			l.record, l.err = s.Api.QueryContext(ctx, l.item)
+		}(&lookups[i])
+	}
+	wg.Wait()
+
+	return s.decide(lookups)
+}
+
+// lookups return the unique items to query for a request
+func (s *Server) lookups(attrs map[string]string) (lookups []lookup) {
+
+	seen := make(map[string]bool)
+
+	add := func(attr, value string) {
+
+		item, err := zetascan.ParseItem(value)
+
+		// Skip empty (e.g bounce sender) or unusable (e.g HELO localhost) values
+		if err != nil || seen[item.Host] {
+			return
+		}
+
+		seen[item.Host] = true
+		lookups = append(lookups, lookup{attr: attr, item: item.Host})
+	}
+
+	if s.Config.CheckClient {
+		add("client_address", attrs["client_address"])
+	}
+
+	if s.Config.CheckHelo {
+		add("helo_name", attrs["helo_name"])
+	}
+
+	if s.Config.CheckSender {
+		add("sender", attrs["sender"])
+	}
+
+	if s.Config.CheckRecipient {
+		add("recipient", attrs["recipient"])
+	}
+
+	return lookups
+}
+
+// decide return the action from the highest scoring listed item
+func (s *Server) decide(lookups []lookup) string {
+
+	var worst *lookup
+	var score float64
+	failed := false
+
+	for i := range lookups {
+
+		l := &lookups[i]
+
+		if l.err != nil {
+			s.logError(fmt.Errorf("%s %s: %w", l.attr, l.item, l.err))
+			failed = true
+			continue
+		}
+
+		if len(l.record.Results) == 0 {
+			continue
+		}
+
+		result := l.record.Results[0]
+
+		// A whitelisted client is trusted, regardless of other items
+		if l.attr == "client_address" && result.Wl {
+			return ActionDunno
+		}
+
+		if result.Found && !result.Wl && (worst == nil || result.Score > score) {
+			worst = l
+			score = result.Score
+		}
+	}
+
+	if worst == nil {
+		if failed && s.Config.FailAction != "" {
+			return s.Config.FailAction
+		}
+		return ActionDunno
+	}
+
+	reason := describe(worst)
+
+	switch {
+	case s.Config.RejectScore > 0 && score >= s.Config.RejectScore:
+		return ActionReject + " 5.7.1 Rejected, " + reason
+	case s.Config.DeferScore > 0 && score >= s.Config.DeferScore:
+		return ActionDeferIfPermit + " 4.7.1 Deferred, " + reason
+	case s.Config.Prepend:
+		return fmt.Sprintf("%s X-Zetascan-Score: %g (%s)", ActionPrepend, score, reason)
+	}
+
+	return ActionDunno
+}
+
+// describe return a single line reason for a listed item
+func describe(l *lookup) string {
+
+	result := l.record.Results[0]
+
+	var sources []string
+	for _, source := range result.Sources {
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+
+	reason := fmt.Sprintf("%s %s listed by Zetascan", l.attr, l.item)
+
+	if len(sources) > 0 {
+		reason += " in " + strings.Join(sources, ",")
+	}
+
+	return reason
+}
+
+func (s *Server) logError(err error) {
+
+	if s.ErrorLog != nil {
+		s.ErrorLog(err)
+	}
+}
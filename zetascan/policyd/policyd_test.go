@@ -0,0 +1,259 @@
+package policyd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+func TestReadRequest(t *testing.T) {
+
+	r := bufio.NewReader(strings.NewReader("request=smtpd_access_policy\r\nclient_address=127.9.9.1\nsender=\n\nrequest=smtpd_access_policy\n\n"))
+
+	attrs, err := ReadRequest(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(attrs) != 3 || attrs["client_address"] != "127.9.9.1" || attrs["sender"] != "" {
+		t.Errorf("first request = %v", attrs)
+	}
+
+	if attrs, err = ReadRequest(r); err != nil || len(attrs) != 1 {
+		t.Errorf("second request = %v, %v", attrs, err)
+	}
+
+	if _, err = ReadRequest(r); err != io.EOF {
+		t.Errorf("after the last request err = %v, want EOF", err)
+	}
+}
+
+func TestReadRequestErrors(t *testing.T) {
+
+	var many strings.Builder
+	for i := 0; i <= MaxAttributes; i++ {
+		fmt.Fprintf(&many, "name%d=value\n", i)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"malformed", "request\n\n", "malformed"},
+		{"unexpected EOF", "request=smtpd_access_policy\n", io.ErrUnexpectedEOF.Error()},
+		{"unterminated line", "request=smtpd_access_policy", io.ErrUnexpectedEOF.Error()},
+		{"line too long", "sender=" + strings.Repeat("a", MaxLineLength) + "\n\n", "too long"},
+		{"too many attributes", many.String() + "\n", "too many"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			_, err := ReadRequest(bufio.NewReaderSize(strings.NewReader(test.input), 16))
+
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("err = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// converse serves one end of a pipe, returning the other
+func converse(t *testing.T, s *Server) net.Conn {
+
+	client, conn := net.Pipe()
+
+	if !s.track(conn) {
+		t.Fatal("server is shut down")
+	}
+
+	go s.serveConn(conn)
+
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// send writes a request
+func send(t *testing.T, conn net.Conn, attrs ...string) {
+
+	request := "request=smtpd_access_policy\n" + strings.Join(attrs, "\n") + "\n\n"
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ask sends a request and return the action
+func ask(t *testing.T, conn net.Conn, r *bufio.Reader, attrs ...string) string {
+
+	send(t, conn, attrs...)
+
+	answer, err := ReadRequest(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return answer["action"]
+}
+
+func TestServer(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	s := NewServer(myzetascan)
+	conn := converse(t, s)
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		name  string
+		attrs []string
+		want  string
+	}{
+		{"reject", []string{"client_address=127.9.9.1"}, "REJECT 5.7.1 Rejected, client_address 127.9.9.1 listed by Zetascan in shSBL,shXBL"},
+		{"defer", []string{"client_address=127.9.9.3"}, "DEFER_IF_PERMIT 4.7.1 Deferred, client_address 127.9.9.3 listed by Zetascan in shSBL"},
+		{"prepend", []string{"client_address=127.9.9.2"}, "PREPEND X-Zetascan-Score: 0.2 (client_address 127.9.9.2 listed by Zetascan in shPBL)"},
+		{"highest score", []string{"client_address=127.9.9.3", "sender=user@baddomain.org"}, "REJECT 5.7.1 Rejected, sender baddomain.org listed by Zetascan in shDBL,ubBlack,ubGold,ubGrey,ubRed"},
+		{"whitelisted client", []string{"client_address=127.9.9.4", "sender=user@baddomain.org"}, ActionDunno},
+		{"not listed", []string{"client_address=192.0.2.1", "helo_name=localhost", "sender="}, ActionDunno},
+	}
+
+	// Each request on the same connection
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ask(t, conn, r, test.attrs...); got != test.want {
+				t.Errorf("action = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestServerFailAction(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.InjectMethod("json", zetascantest.Fault{Status: 503, Count: -1})
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	s := NewServer(myzetascan)
+	s.Config.FailAction = ActionDeferIfPermit + " 4.7.1 Zetascan lookup failed"
+
+	conn := converse(t, s)
+
+	if got := ask(t, conn, bufio.NewReader(conn), "client_address=127.9.9.1"); got != s.Config.FailAction {
+		t.Errorf("action = %q, want %q", got, s.Config.FailAction)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	emulator.InjectItem("127.9.9.1", zetascantest.Fault{Latency: 200 * time.Millisecond})
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	s := NewServer(myzetascan)
+
+	idle := converse(t, s)
+	busy := converse(t, s)
+
+	send(t, busy, "client_address=127.9.9.1")
+
+	answered := make(chan string, 1)
+	go func() {
+		answer, _ := ReadRequest(bufio.NewReader(busy))
+		answered <- answer["action"]
+	}()
+
+	// Wait for the request to be in flight
+	for emulator.Requests("127.9.9.1") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-answered; !strings.HasPrefix(got, ActionReject) {
+		t.Errorf("in-flight action = %q, want REJECT", got)
+	}
+
+	// The idle connection was closed
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("idle connection read err = %v, want EOF", err)
+	}
+
+	// New connections are refused
+	if _, conn := net.Pipe(); s.track(conn) {
+		t.Error("connection tracked after Shutdown")
+	}
+}
+
+func TestRemoveSocket(t *testing.T) {
+
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "policyd.sock")
+
+	l, err := net.Listen("unix", socket)
+
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// Leave the socket file behind, as a crash would
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	if err := RemoveSocket(socket); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Lstat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket not removed: %v", err)
+	}
+
+	// A missing socket is not an error
+	if err := RemoveSocket(socket); err != nil {
+		t.Errorf("missing socket: %v", err)
+	}
+
+	// Other files are left alone
+	file := filepath.Join(dir, "main.cf")
+	os.WriteFile(file, nil, 0o600)
+
+	if err := RemoveSocket(file); err == nil {
+		t.Error("removed a regular file")
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("regular file: %v", err)
+	}
+}
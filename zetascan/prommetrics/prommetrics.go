@@ -0,0 +1,141 @@
+// Package prommetrics is a Prometheus adapter for zetascan.Metrics.
+//
+//	collector := prommetrics.New()
+//	prometheus.MustRegister(collector)
+//
+//	myzetascan.Metrics = collector
+//
+// The metrics exported are:
+//
+//	zetascan_queries_total{method,endpoint,verdict,error}
+//	zetascan_query_duration_seconds{method,endpoint}
+//	zetascan_retries_total{method,endpoint,reason}
+//	zetascan_failovers_total{method,from,to}
+//	zetascan_cache_lookups_total{method,result}   # result is hit or miss
+package prommetrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Collector implements zetascan.Metrics and prometheus.Collector
+type Collector struct {
+	queries   *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	retries   *prometheus.CounterVec
+	failovers *prometheus.CounterVec
+	cache     *prometheus.CounterVec
+}
+
+// DefaultBuckets are the query latency histogram buckets, in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// New return a collector with the zetascan namespace
+func New() *Collector {
+
+	return &Collector{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "zetascan",
+			Name:      "queries_total",
+			Help:      "Zetascan queries by method, endpoint, verdict and error type.",
+		}, []string{"method", "endpoint", "verdict", "error"}),
+
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "zetascan",
+			Name:      "query_duration_seconds",
+			Help:      "Zetascan query latency, including retries and failover.",
+			Buckets:   DefaultBuckets,
+		}, []string{"method", "endpoint"}),
+
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "zetascan",
+			Name:      "retries_total",
+			Help:      "Zetascan DNS queries retried, by reason.",
+		}, []string{"method", "endpoint", "reason"}),
+
+		failovers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "zetascan",
+			Name:      "failovers_total",
+			Help:      "Zetascan web queries failed over to the next endpoint.",
+		}, []string{"method", "from", "to"}),
+
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "zetascan",
+			Name:      "cache_lookups_total",
+			Help:      "Zetascan result cache lookups, by hit or miss.",
+		}, []string{"method", "result"}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+
+	c.queries.Describe(ch)
+	c.latency.Describe(ch)
+	c.retries.Describe(ch)
+	c.failovers.Describe(ch)
+	c.cache.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+
+	c.queries.Collect(ch)
+	c.latency.Collect(ch)
+	c.retries.Collect(ch)
+	c.failovers.Collect(ch)
+	c.cache.Collect(ch)
+}
+
+// ObserveQuery implements zetascan.Metrics
+func (c *Collector) ObserveQuery(q zetascan.QueryMetric) {
+
+	c.queries.WithLabelValues(q.Method, q.Endpoint, q.Verdict, q.Error).Inc()
+	c.latency.WithLabelValues(q.Method, q.Endpoint).Observe(q.Duration.Seconds())
+}
+
+// ObserveRetry implements zetascan.Metrics
+func (c *Collector) ObserveRetry(method, endpoint, reason string) {
+	c.retries.WithLabelValues(method, endpoint, reason).Inc()
+}
+
+// ObserveFailover implements zetascan.Metrics
+func (c *Collector) ObserveFailover(method, from, to string) {
+	c.failovers.WithLabelValues(method, from, to).Inc()
+}
+
+// ObserveCache implements zetascan.Metrics
+func (c *Collector) ObserveCache(method string, hit bool) {
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	c.cache.WithLabelValues(method, result).Inc()
+}
+
+// Handler return a /metrics handler for the collector alone, along with the
+// Go runtime and process metrics
+func (c *Collector) Handler() http.Handler {
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c, collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves the collector on addr at /metrics, blocking until it fails
+func (c *Collector) ListenAndServe(addr string) error {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.Handler())
+
+	return http.ListenAndServe(addr, mux)
+}
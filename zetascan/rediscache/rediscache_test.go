@@ -0,0 +1,191 @@
+package rediscache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+// newCache return a cache in an in-process store, and the store
+func newCache(t *testing.T) (*Cache, *miniredis.Miniredis) {
+
+	store := miniredis.RunT(t)
+
+	c := New(redis.NewClient(&redis.Options{Addr: store.Addr()}), DefaultConfig)
+	t.Cleanup(func() { c.Close() })
+
+	return c, store
+}
+
+// entry return an entry stored now, expiring after ttl and stale for stale longer
+func entry(ttl time.Duration, stale time.Duration) zetascan.CacheEntry {
+
+	now := time.Now()
+
+	e := zetascan.CacheEntry{
+		Record:  zetascan.JsonRecord{Results: []zetascan.JsonResult{{Item: "127.9.9.1", Found: true, Score: 0.95}}},
+		Method:  "json",
+		Stored:  now,
+		Expires: now.Add(ttl),
+	}
+
+	if stale > 0 {
+		e.StaleUntil = e.Expires.Add(stale)
+	}
+
+	return e
+}
+
+func TestGetSet(t *testing.T) {
+
+	c, store := newCache(t)
+	ctx := context.Background()
+
+	if _, ok, err := c.Get(ctx, "json/127.9.9.1"); ok || err != nil {
+		t.Errorf("empty cache: ok = %v, err = %v", ok, err)
+	}
+
+	if err := c.Set(ctx, "json/127.9.9.1", entry(time.Hour, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok, err := c.Get(ctx, "json/127.9.9.1")
+
+	if err != nil || !ok || e.Method != "json" || len(e.Record.Results) != 1 || e.Record.Results[0].Score != 0.95 {
+		t.Errorf("Get = %+v, %v, %v", e, ok, err)
+	}
+
+	if !store.Exists("zetascan:json/127.9.9.1") {
+		t.Errorf("keys = %q, want zetascan:json/127.9.9.1", store.Keys())
+	}
+
+	// Unreadable entries are an error, not a hit
+	store.Set("zetascan:json/127.9.9.2", "{")
+
+	if _, ok, err := c.Get(ctx, "json/127.9.9.2"); ok || err == nil {
+		t.Errorf("invalid entry: ok = %v, err = %v", ok, err)
+	}
+}
+
+// Entries expire from the store when they can no longer be served
+func TestTTL(t *testing.T) {
+
+	c, store := newCache(t)
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "fresh", entry(time.Hour, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Set(ctx, "stale", entry(time.Hour, 24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]time.Duration{"fresh": time.Hour, "stale": 25 * time.Hour} {
+		if ttl := store.TTL("zetascan:" + key); ttl <= want-time.Minute || ttl > want {
+			t.Errorf("%s TTL = %v, want %v", key, ttl, want)
+		}
+	}
+
+	// Expired, but the stale entry can still be served
+	store.FastForward(2 * time.Hour)
+
+	if _, ok, _ := c.Get(ctx, "fresh"); ok {
+		t.Error("fresh entry not removed after its TTL")
+	}
+
+	if _, ok, _ := c.Get(ctx, "stale"); !ok {
+		t.Error("stale entry removed before StaleUntil")
+	}
+
+	store.FastForward(24 * time.Hour)
+
+	if _, ok, _ := c.Get(ctx, "stale"); ok {
+		t.Error("stale entry not removed after StaleUntil")
+	}
+}
+
+// Entries that can't be served aren't stored
+func TestSetExpired(t *testing.T) {
+
+	c, store := newCache(t)
+
+	if err := c.Set(context.Background(), "expired", entry(-time.Second, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.Keys()) != 0 {
+		t.Errorf("keys = %q, want none", store.Keys())
+	}
+}
+
+// A stale result is served while it's refreshed in the background
+func TestStaleWhileRevalidate(t *testing.T) {
+
+	c, _ := newCache(t)
+	ctx := context.Background()
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+	myzetascan.Cache = c
+	myzetascan.CacheTTL = time.Hour
+	myzetascan.CacheStale = time.Hour
+
+	// Entries of other endpoints aren't served
+	key := myzetascan.CacheKey("127.9.9.1")
+
+	if key != emulator.URL+"/json/127.9.9.1" {
+		t.Errorf("CacheKey = %q, want the emulator's endpoint", key)
+	}
+
+	// An expired entry, still served for an hour, claiming it's not listed
+	stale := entry(-time.Minute, time.Hour)
+	stale.Record.Results[0].Found = false
+
+	if err := c.Set(ctx, key, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := myzetascan.QueryContext(ctx, "127.9.9.1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Results[0].Found {
+		t.Error("stale result not served")
+	}
+
+	// Refreshed by the emulator's answer
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		e, ok, err := c.Get(ctx, key)
+
+		if err == nil && ok && !e.Expired(time.Now()) {
+			if !e.Record.Results[0].Found {
+				t.Errorf("refreshed result = %+v, want listed", e.Record.Results[0])
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("stale result not refreshed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if emulator.Requests("127.9.9.1") != 1 {
+		t.Errorf("%d requests, want 1", emulator.Requests("127.9.9.1"))
+	}
+}
@@ -0,0 +1,60 @@
+package zetascan
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Redacted replaces secrets in errors, logs and String representations
+const Redacted = "REDACTED"
+
+// Secret is a string, such as an API key, that is redacted when printed with
+// fmt, logged with slog or marshalled as JSON, YAML or text. Use Reveal for the
+// value itself.
+type Secret string
+
+// Reveal return the secret value
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String return REDACTED, or an empty string if the secret is not set
+func (s Secret) String() string {
+
+	if s == "" {
+		return ""
+	}
+
+	return Redacted
+}
+
+// GoString redacts the secret for %#v
+func (s Secret) GoString() string {
+	return "zetascan.Secret(" + fmt.Sprintf("%q", s.String()) + ")"
+}
+
+// Format redacts the secret for every fmt verb, including %x and %d
+func (s Secret) Format(f fmt.State, verb rune) {
+
+	if verb == 'v' && f.Flag('#') {
+		f.Write([]byte(s.GoString()))
+		return
+	}
+
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", s.String())
+		return
+	}
+
+	f.Write([]byte(s.String()))
+}
+
+// LogValue redacts the secret for slog
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// MarshalText redacts the secret for JSON, YAML and other encodings
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/zetascan/go-zetascan/zetascan/server/serverpb"
+)
+
+// Maximum size of a request body, or of a stream line
+const maxBody = 16 << 20
+
+// Responses include unset fields, e.g "found": false
+var marshalJSON = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// Handler return the JSON gateway and probes:
+//
+//	POST /v1/check        a CheckRequest, answered with a CheckResponse
+//	GET  /v1/check/{item} with optional ?method=dns,jsonx&enrich=true
+//	POST /v1/batch        a CheckBatchRequest, answered with a CheckBatchResponse
+//	POST /v1/stream       CheckRequest JSON lines, answered with CheckResponse lines as each completes
+//	GET  /healthz         200 while running (liveness)
+//	GET  /readyz          200 when ready for lookups, otherwise 503 (readiness)
+//
+// Messages are the proto3 JSON form of serverpb, e.g {"item": "example.com"},
+// and errors are answered as {"error": "..."}. The /v1 paths need a token.
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	mux.Handle("POST /v1/check", s.requireToken(http.HandlerFunc(s.postCheck)))
+	mux.Handle("GET /v1/check/{item...}", s.requireToken(http.HandlerFunc(s.getCheck)))
+	mux.Handle("POST /v1/batch", s.requireToken(http.HandlerFunc(s.postBatch)))
+	mux.Handle("POST /v1/stream", s.requireToken(http.HandlerFunc(s.postStream)))
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+
+		if !s.Ready() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+
+	return mux
+}
+
+// requireToken answers 401 without a valid token
+func (s *Server) requireToken(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !s.authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, status.Error(codes.Unauthenticated, "Invalid or missing token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// postCheck answers a CheckRequest
+func (s *Server) postCheck(w http.ResponseWriter, r *http.Request) {
+
+	req := &serverpb.CheckRequest{}
+
+	if err := readProto(w, r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp, err := s.Check(r.Context(), req)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeProto(w, resp)
+}
+
+// getCheck answers the item in the path
+func (s *Server) getCheck(w http.ResponseWriter, r *http.Request) {
+
+	req := &serverpb.CheckRequest{Item: r.PathValue("item"), Method: r.URL.Query().Get("method")}
+
+	if v := r.URL.Query().Get("enrich"); v != "" {
+
+		enrich, err := strconv.ParseBool(v)
+
+		if err != nil {
+			writeError(w, status.Error(codes.InvalidArgument, "Invalid enrich: "+v))
+			return
+		}
+
+		req.Enrich = enrich
+	}
+
+	resp, err := s.Check(r.Context(), req)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeProto(w, resp)
+}
+
+// postBatch answers a CheckBatchRequest
+func (s *Server) postBatch(w http.ResponseWriter, r *http.Request) {
+
+	req := &serverpb.CheckBatchRequest{}
+
+	if err := readProto(w, r, req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp, err := s.CheckBatch(r.Context(), req)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeProto(w, resp)
+}
+
+// postStream answers each CheckRequest line as it completes, like CheckStream
+func (s *Server) postStream(w http.ResponseWriter, r *http.Request) {
+
+	rc := http.NewResponseController(w)
+
+	// Answer while still reading requests, HTTP/2 is always full duplex
+	if err := rc.EnableFullDuplex(); err != nil && r.ProtoMajor < 2 {
+		writeError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	sem := make(chan struct{}, s.concurrency())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	// send writes a line, stopping the stream if the client has gone
+	send := func(m proto.Message) {
+
+		line, err := marshalJSON.Marshal(m)
+
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			cancel()
+			return
+		}
+
+		rc.Flush()
+	}
+
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64<<10), maxBody)
+
+	for scanner.Scan() {
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		req := &serverpb.CheckRequest{}
+
+		if err := protojson.Unmarshal(scanner.Bytes(), req); err != nil {
+			send(&serverpb.CheckResponse{Verdict: "error", Error: "Invalid request: " + err.Error(), ErrorType: "invalid_request"})
+			continue
+		}
+
+		opts, err := callOptions(req.Method, req.Enrich)
+
+		if err != nil {
+			send(&serverpb.CheckResponse{Id: req.Id, Item: req.Item, Verdict: "error", Error: err.Error(), ErrorType: "invalid_request"})
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		wg.Add(1)
+		go func(req *serverpb.CheckRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			send(s.check(ctx, req.Id, req.Item, opts))
+		}(req)
+	}
+}
+
+// readProto decodes the JSON body of a request
+func readProto(w http.ResponseWriter, r *http.Request, m proto.Message) error {
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return status.Error(codes.ResourceExhausted, "Request too large")
+		}
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := protojson.Unmarshal(body, m); err != nil {
+		return status.Error(codes.InvalidArgument, "Invalid request: "+err.Error())
+	}
+
+	return nil
+}
+
+// writeProto answers with the JSON of a message
+func writeProto(w http.ResponseWriter, m proto.Message) {
+
+	body, err := marshalJSON.Marshal(m)
+
+	if err != nil {
+		writeError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// writeError answers with the HTTP status of a gRPC error
+func writeError(w http.ResponseWriter, err error) {
+
+	st := status.Convert(err)
+
+	code := http.StatusInternalServerError
+
+	switch st.Code() {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		code = http.StatusRequestEntityTooLarge
+	case codes.DeadlineExceeded:
+		code = http.StatusGatewayTimeout
+	case codes.Canceled:
+		code = 499 // Client closed request
+	}
+
+	writeJSON(w, code, map[string]string{"error": st.Message()})
+}
+
+// writeJSON answers with a status and JSON body
+func writeJSON(w http.ResponseWriter, code int, v any) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,742 @@
+// Package server serves zetascan lookups to other services, over gRPC (see
+// serverpb/zetascan.proto) and as JSON over HTTP, with the policy decision for
+// each result. Lookups go through a zetascan.Client, so the servers share its
+// cache, failover and method fallback.
+//
+//	client := zetascan.NewClient(myzetascan, zetascan.DefaultClientConfig)
+//	s := server.NewServer(client)
+//	s.Config.Tokens = []zetascan.Secret{"YOURTOKEN"}
+//
+//	go s.ListenAndServeGRPC("127.0.0.1:9090")
+//	log.Fatal(s.ListenAndServeGateway("127.0.0.1:8080"))
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/server/serverpb"
+)
+
+// ServiceName is the gRPC service, for health checks
+const ServiceName = "zetascan.v1.Zetascan"
+
+// Config sets the score thresholds for each action, and the limits of the server
+type Config struct {
+	// Scores (MTA score) at or above which an item is rejected, deferred or
+	// quarantined, 0 to disable
+	RejectScore     float64
+	DeferScore      float64
+	QuarantineScore float64
+
+	// Action when a lookup fails, defaults to allow
+	FailAction serverpb.Action
+
+	// Maximum time for a single lookup
+	Timeout time.Duration
+
+	// Maximum items in a CheckBatch, and parallel lookups per batch or stream
+	MaxBatch    int
+	Concurrency int
+
+	// Tokens accepted as "Authorization: Bearer <token>". Without tokens every
+	// call is refused, unless AllowAnyClient is set.
+	Tokens         []zetascan.Secret
+	AllowAnyClient bool
+
+	// ProbeItem is looked up every ProbeInterval for the readiness probe,
+	// bypassing the cache so the endpoint is checked, "" to be ready without
+	// probing
+	ProbeItem     string
+	ProbeInterval time.Duration
+}
+
+// DefaultConfig rejects scores of 0.9+ and quarantines 0.5+, allowing items when the lookup fails
+var DefaultConfig = Config{
+	RejectScore:     0.9,
+	DeferScore:      0,
+	QuarantineScore: 0.5,
+	FailAction:      serverpb.Action_ACTION_ALLOW,
+	Timeout:         10 * time.Second,
+	MaxBatch:        1000,
+	Concurrency:     zetascan.DefaultConcurrency,
+	ProbeItem:       "127.9.9.1",
+	ProbeInterval:   30 * time.Second,
+}
+
+// Server answers gRPC and HTTP lookups
+type Server struct {
+	serverpb.UnimplementedZetascanServer
+
+	Client *zetascan.Client
+	Config Config
+
+	// Logger receives failed lookups and probes, if set
+	Logger *slog.Logger
+
+	health   *health.Server
+	ready    atomic.Bool
+	closing  atomic.Bool
+	once     sync.Once // Starts the probe
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	mu          sync.Mutex
+	grpcServers []*grpc.Server
+	httpServers []*http.Server
+}
+
+// NewServer return a server using the default configuration
+func NewServer(client *zetascan.Client) *Server {
+
+	s := &Server{Client: client, Config: DefaultConfig, health: health.NewServer(), stop: make(chan struct{})}
+
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	s.health.SetServingStatus(ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return s
+}
+
+// Check implements serverpb.ZetascanServer
+func (s *Server) Check(ctx context.Context, req *serverpb.CheckRequest) (*serverpb.CheckResponse, error) {
+
+	opts, err := callOptions(req.Method, req.Enrich)
+
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if _, err := zetascan.ParseItem(req.Item); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return s.check(ctx, req.Id, req.Item, opts), nil
+}
+
+// CheckBatch implements serverpb.ZetascanServer
+func (s *Server) CheckBatch(ctx context.Context, req *serverpb.CheckBatchRequest) (*serverpb.CheckBatchResponse, error) {
+
+	opts, err := callOptions(req.Method, req.Enrich)
+
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if s.Config.MaxBatch > 0 && len(req.Items) > s.Config.MaxBatch {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Too many items, at most %d per batch", s.Config.MaxBatch))
+	}
+
+	results := make([]*serverpb.CheckResponse, len(req.Items))
+	sem := make(chan struct{}, s.concurrency())
+
+	var wg sync.WaitGroup
+
+	for i, item := range req.Items {
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+
+		wg.Add(1)
+		go func(i int, item string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = s.check(ctx, "", item, opts)
+		}(i, item)
+	}
+
+	wg.Wait()
+
+	return &serverpb.CheckBatchResponse{Results: results}, nil
+}
+
+// CheckStream implements serverpb.ZetascanServer
+func (s *Server) CheckStream(stream serverpb.Zetascan_CheckStreamServer) error {
+
+	ctx := stream.Context()
+	sem := make(chan struct{}, s.concurrency())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sendErr error
+
+	defer wg.Wait()
+
+	for {
+		req, err := stream.Recv()
+
+		mu.Lock()
+		failed := sendErr
+		mu.Unlock()
+
+		if failed != nil {
+			return failed
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		opts, err := callOptions(req.Method, req.Enrich)
+
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+
+		wg.Add(1)
+		go func(req *serverpb.CheckRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			resp := s.check(ctx, req.Id, req.Item, opts)
+
+			// Sends must not be concurrent
+			mu.Lock()
+			defer mu.Unlock()
+
+			if sendErr == nil {
+				sendErr = stream.Send(resp)
+			}
+		}(req)
+	}
+}
+
+// check looks up an item, returning the result and decision or the error
+func (s *Server) check(ctx context.Context, id string, query string, opts []zetascan.CallOption) *serverpb.CheckResponse {
+
+	resp := &serverpb.CheckResponse{Id: id, Item: query}
+
+	item, err := zetascan.ParseItem(query)
+
+	if err != nil {
+		resp.Verdict = "error"
+		resp.Error = err.Error()
+		resp.ErrorType = zetascan.ErrorType(err)
+		return resp
+	}
+
+	resp.Item = item.String()
+	resp.ItemType = item.Type.String()
+
+	if s.Config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Config.Timeout)
+		defer cancel()
+	}
+
+	m, err := s.Client.Query(ctx, resp.Item, opts...)
+
+	if err != nil {
+		s.logger().WarnContext(ctx, "zetascan lookup failed", "item", resp.Item, "error", err)
+
+		resp.Verdict = "error"
+		resp.Error = err.Error()
+		resp.ErrorType = zetascan.ErrorType(err)
+		resp.Decision = &serverpb.Decision{Action: s.failAction(), Reason: "Zetascan lookup failed"}
+		return resp
+	}
+
+	resp.Method = m.Method
+	resp.Verdict = verdict(m)
+	resp.Result = newResult(m)
+	resp.Decision = s.Decide(resp.Item, m)
+
+	return resp
+}
+
+// Decide return the action for the result of an item, from the score thresholds
+func (s *Server) Decide(item string, m zetascan.JsonRecord) *serverpb.Decision {
+
+	allow := &serverpb.Decision{Action: serverpb.Action_ACTION_ALLOW}
+
+	if len(m.Results) == 0 {
+		return allow
+	}
+
+	result := m.Results[0]
+
+	if result.Wl {
+		allow.Reason = item + " whitelisted by Zetascan"
+		return allow
+	}
+
+	if !result.Found {
+		return allow
+	}
+
+	reason := describe(item, result)
+
+	switch {
+	case s.Config.RejectScore > 0 && result.Score >= s.Config.RejectScore:
+		return &serverpb.Decision{Action: serverpb.Action_ACTION_REJECT, Reason: reason}
+	case s.Config.DeferScore > 0 && result.Score >= s.Config.DeferScore:
+		return &serverpb.Decision{Action: serverpb.Action_ACTION_DEFER, Reason: reason}
+	case s.Config.QuarantineScore > 0 && result.Score >= s.Config.QuarantineScore:
+		return &serverpb.Decision{Action: serverpb.Action_ACTION_QUARANTINE, Reason: reason}
+	}
+
+	allow.Reason = reason
+	return allow
+}
+
+// GRPCServer return a gRPC server of the Zetascan and health services,
+// requiring a token for the Zetascan service
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(s.unaryAuth),
+		grpc.ChainStreamInterceptor(s.streamAuth))
+
+	g := grpc.NewServer(opts...)
+
+	serverpb.RegisterZetascanServer(g, s)
+	healthpb.RegisterHealthServer(g, s.health)
+
+	return g
+}
+
+// ServeGRPC serves gRPC on l until closed
+func (s *Server) ServeGRPC(l net.Listener, opts ...grpc.ServerOption) error {
+
+	g := s.GRPCServer(opts...)
+
+	s.mu.Lock()
+	s.grpcServers = append(s.grpcServers, g)
+	s.mu.Unlock()
+
+	s.startProbe()
+
+	return g.Serve(l)
+}
+
+// ListenAndServeGRPC serves gRPC on a tcp address, e.g 127.0.0.1:9090
+func (s *Server) ListenAndServeGRPC(address string, opts ...grpc.ServerOption) error {
+
+	l, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	return s.ServeGRPC(l, opts...)
+}
+
+// ServeGateway serves the JSON gateway and probes on l until closed
+func (s *Server) ServeGateway(l net.Listener) error {
+	return s.serveHTTP(l, "", "")
+}
+
+// ServeGatewayTLS serves the JSON gateway and probes over TLS on l until closed
+func (s *Server) ServeGatewayTLS(l net.Listener, certFile, keyFile string) error {
+	return s.serveHTTP(l, certFile, keyFile)
+}
+
+// ListenAndServeGateway serves the JSON gateway and probes on a tcp address, e.g 127.0.0.1:8080
+func (s *Server) ListenAndServeGateway(address string) error {
+
+	l, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	return s.ServeGateway(l)
+}
+
+// serveHTTP serves the handler on l, over TLS if certFile is set
+func (s *Server) serveHTTP(l net.Listener, certFile, keyFile string) error {
+
+	h := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	s.mu.Lock()
+	s.httpServers = append(s.httpServers, h)
+	s.mu.Unlock()
+
+	s.startProbe()
+
+	var err error
+
+	if certFile != "" {
+		err = h.ServeTLS(l, certFile, keyFile)
+	} else {
+		err = h.Serve(l)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Ready return if the server is ready for lookups, the last probe succeeded
+// and it's not shutting down
+func (s *Server) Ready() bool {
+	return s.ready.Load() && !s.closing.Load()
+}
+
+// Shutdown stops the probes and reports not ready, then lets in-flight calls
+// finish until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	s.closing.Store(true)
+	s.health.Shutdown()
+
+	// Don't start the probe after shutting down
+	s.once.Do(func() {})
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	s.mu.Lock()
+	grpcServers, httpServers := s.grpcServers, s.httpServers
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+
+	for _, g := range grpcServers {
+
+		wg.Add(1)
+		go func(g *grpc.Server) {
+			defer wg.Done()
+
+			stopped := make(chan struct{})
+			go func() { g.GracefulStop(); close(stopped) }()
+
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				g.Stop()
+			}
+		}(g)
+	}
+
+	var err error
+	var errMu sync.Mutex
+
+	for _, h := range httpServers {
+
+		wg.Add(1)
+		go func(h *http.Server) {
+			defer wg.Done()
+
+			if herr := h.Shutdown(ctx); herr != nil {
+				errMu.Lock()
+				err = herr
+				errMu.Unlock()
+			}
+		}(h)
+	}
+
+	wg.Wait()
+
+	return err
+}
+
+// Close stops all listeners immediately
+func (s *Server) Close() error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return s.Shutdown(ctx)
+}
+
+// startProbe starts probing for readiness, once
+func (s *Server) startProbe() {
+
+	s.once.Do(func() {
+
+		if s.Config.ProbeItem == "" {
+			s.setReady(true)
+			return
+		}
+
+		go s.probe()
+	})
+}
+
+// probe looks up ProbeItem every ProbeInterval, until shut down
+func (s *Server) probe() {
+
+	interval := s.Config.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultConfig.ProbeInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		_, err := s.Client.Query(ctx, s.Config.ProbeItem, zetascan.WithNoCache())
+		cancel()
+
+		if err != nil {
+			s.logger().Warn("zetascan readiness probe failed", "item", s.Config.ProbeItem, "error", err)
+		}
+
+		s.setReady(err == nil)
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setReady updates the readiness, and the gRPC health status
+func (s *Server) setReady(ready bool) {
+
+	if s.closing.Load() {
+		return
+	}
+
+	s.ready.Store(ready)
+
+	state := healthpb.HealthCheckResponse_NOT_SERVING
+	if ready {
+		state = healthpb.HealthCheckResponse_SERVING
+	}
+
+	s.health.SetServingStatus("", state)
+	s.health.SetServingStatus(ServiceName, state)
+}
+
+// authorized return if an Authorization header value holds one of the tokens,
+// or any client is allowed
+func (s *Server) authorized(header string) bool {
+
+	if len(s.Config.Tokens) == 0 {
+		return s.Config.AllowAnyClient
+	}
+
+	token, ok := strings.CutPrefix(header, "Bearer ")
+
+	if !ok || token == "" {
+		return false
+	}
+
+	for _, t := range s.Config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Reveal())) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unaryAuth requires a token for the Zetascan service
+func (s *Server) unaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+
+	if err := s.authorizeGRPC(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// streamAuth requires a token for the Zetascan service
+func (s *Server) streamAuth(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	if err := s.authorizeGRPC(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
+
+// authorizeGRPC checks the token of a call, health checks need none
+func (s *Server) authorizeGRPC(ctx context.Context, method string) error {
+
+	if strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+		return nil
+	}
+
+	if len(s.Config.Tokens) == 0 && s.Config.AllowAnyClient {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	for _, header := range md.Get("authorization") {
+		if s.authorized(header) {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "Invalid or missing token")
+}
+
+// concurrency return the parallel lookups per batch or stream
+func (s *Server) concurrency() int {
+
+	if s.Config.Concurrency > 0 {
+		return s.Config.Concurrency
+	}
+
+	return zetascan.DefaultConcurrency
+}
+
+// failAction return the action when a lookup fails
+func (s *Server) failAction() serverpb.Action {
+
+	if s.Config.FailAction == serverpb.Action_ACTION_UNSPECIFIED {
+		return serverpb.Action_ACTION_ALLOW
+	}
+
+	return s.Config.FailAction
+}
+
+func (s *Server) logger() *slog.Logger {
+
+	if s.Logger != nil {
+		return s.Logger
+	}
+
+	return slog.New(slog.DiscardHandler)
+}
+
+// callOptions return the options for a method, or fallback chain, and enrich
+func callOptions(method string, enrich bool) (opts []zetascan.CallOption, err error) {
+
+	if method != "" {
+
+		methods, err := zetascan.ParseMethods(method)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, zetascan.WithMethod(methods[0]), zetascan.WithFallback(methods[1:]...))
+	}
+
+	if enrich {
+		opts = append(opts, zetascan.WithEnrich())
+	}
+
+	return opts, nil
+}
+
+// verdict return listed, whitelisted or not listed
+func verdict(m zetascan.JsonRecord) string {
+
+	var myzetascan zetascan.Api
+
+	switch {
+	case len(m.Results) == 0:
+		return "not listed"
+	case myzetascan.IsBlackList(&m):
+		return "listed"
+	case myzetascan.IsWhiteList(&m):
+		return "whitelisted"
+	}
+
+	return "not listed"
+}
+
+// newResult return the normalised first result
+func newResult(m zetascan.JsonRecord) *serverpb.Result {
+
+	if len(m.Results) == 0 {
+		return &serverpb.Result{}
+	}
+
+	r := m.Results[0]
+
+	var sources []string
+	for _, source := range r.Sources {
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+
+	result := &serverpb.Result{
+		Found:         r.Found,
+		Score:         r.Score,
+		WebScore:      r.WebScore,
+		FromSubnet:    r.FromSubnet,
+		Sources:       sources,
+		Whitelisted:   r.Wl,
+		WhitelistData: r.Wldata,
+		Matched:       r.Matched,
+	}
+
+	if r.Extended != (zetascan.JsonExtended{}) {
+
+		x := r.Extended
+
+		result.Extended = &serverpb.Extended{
+			AsNumber: x.ASNum,
+			Route:    x.Route,
+			Country:  x.Country,
+			Domain:   x.Domain,
+			State:    x.State,
+			Time:     x.Time,
+			Reason: &serverpb.Reason{
+				Class:       x.Reason.Class,
+				Rule:        x.Reason.Rule,
+				Type:        x.Reason.Type,
+				Name:        x.Reason.Name,
+				Source:      x.Reason.Source,
+				Port:        x.Reason.Port,
+				SourcePort:  x.Reason.SourcePort,
+				Destination: x.Reason.Destination,
+			},
+		}
+	}
+
+	return result
+}
+
+// describe return a single line reason for a listed item
+func describe(item string, result zetascan.JsonResult) string {
+
+	var sources []string
+	for _, source := range result.Sources {
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+
+	reason := item + " listed by Zetascan"
+
+	if len(sources) > 0 {
+		reason += " in " + strings.Join(sources, ",")
+	}
+
+	return reason
+}
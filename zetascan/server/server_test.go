@@ -0,0 +1,465 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/server/serverpb"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+// newServer return a server querying the emulator via json, with a memory cache
+func newServer(t *testing.T) (*Server, *zetascantest.Server) {
+
+	emulator := zetascantest.NewServer(nil)
+	t.Cleanup(func() { emulator.Close() })
+
+	myzetascan := emulator.Api("")
+	myzetascan.ApiMethod = "json"
+
+	client := zetascan.NewClient(myzetascan, zetascan.ClientConfig{CacheTTL: time.Hour})
+	t.Cleanup(func() { client.Close() })
+
+	return NewServer(client), emulator
+}
+
+func TestAuthorized(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tokens   []zetascan.Secret
+		anyone   bool
+		header   string
+		want     int
+		wantGRPC codes.Code
+	}{
+		{"no tokens", nil, false, "", http.StatusUnauthorized, codes.Unauthenticated},
+		{"no tokens with a token", nil, false, "Bearer YOURTOKEN", http.StatusUnauthorized, codes.Unauthenticated},
+		{"any client", nil, true, "", http.StatusOK, codes.OK},
+		{"token", []zetascan.Secret{"YOURTOKEN"}, false, "Bearer YOURTOKEN", http.StatusOK, codes.OK},
+		{"wrong token", []zetascan.Secret{"YOURTOKEN"}, false, "Bearer OTHERTOKEN", http.StatusUnauthorized, codes.Unauthenticated},
+		{"missing token", []zetascan.Secret{"YOURTOKEN"}, true, "", http.StatusUnauthorized, codes.Unauthenticated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			s, _ := newServer(t)
+			s.Config.Tokens = test.tokens
+			s.Config.AllowAnyClient = test.anyone
+
+			r := httptest.NewRequest("GET", "/v1/check/127.9.9.1", nil)
+			if test.header != "" {
+				r.Header.Set("Authorization", test.header)
+			}
+
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("HTTP status = %d, want %d: %s", w.Code, test.want, w.Body)
+			}
+
+			ctx := context.Background()
+			if test.header != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", test.header))
+			}
+
+			if got := status.Code(s.authorizeGRPC(ctx, "/"+ServiceName+"/Check")); got != test.wantGRPC {
+				t.Errorf("gRPC code = %v, want %v", got, test.wantGRPC)
+			}
+
+			// Health checks need no token
+			if err := s.authorizeGRPC(context.Background(), "/"+healthpb.Health_ServiceDesc.ServiceName+"/Check"); err != nil {
+				t.Errorf("health check: %v", err)
+			}
+		})
+	}
+}
+
+// The probe queries the endpoint, not the cache
+func TestProbe(t *testing.T) {
+
+	s, emulator := newServer(t)
+	s.Config.ProbeInterval = 10 * time.Millisecond
+
+	// Cached before probing
+	if _, err := s.Client.Query(context.Background(), s.Config.ProbeItem); err != nil {
+		t.Fatal(err)
+	}
+
+	s.startProbe()
+	defer s.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for emulator.Requests(s.Config.ProbeItem) < 3 || !s.Ready() {
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests, ready %v, want probes of the emulator", emulator.Requests(s.Config.ProbeItem), s.Ready())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDecide(t *testing.T) {
+
+	listed := func(score float64) zetascan.JsonRecord {
+		return zetascan.JsonRecord{Results: zetascan.JsonResults{{Found: true, Score: score, Sources: []string{"ubRed", "", "shDBL"}}}}
+	}
+
+	tests := []struct {
+		name   string
+		config func(*Config)
+		record zetascan.JsonRecord
+		action serverpb.Action
+		reason string
+	}{
+		{"reject", nil, listed(0.95), serverpb.Action_ACTION_REJECT, "example.com listed by Zetascan in shDBL,ubRed"},
+		{"at the reject score", nil, listed(0.9), serverpb.Action_ACTION_REJECT, "example.com listed by Zetascan in shDBL,ubRed"},
+		{"quarantine", nil, listed(0.8), serverpb.Action_ACTION_QUARANTINE, "example.com listed by Zetascan in shDBL,ubRed"},
+		{"below the thresholds", nil, listed(0.2), serverpb.Action_ACTION_ALLOW, "example.com listed by Zetascan in shDBL,ubRed"},
+		{"defer", func(c *Config) { c.DeferScore = 0.7 }, listed(0.8), serverpb.Action_ACTION_DEFER, "example.com listed by Zetascan in shDBL,ubRed"},
+		{"reject disabled", func(c *Config) { c.RejectScore = 0; c.DeferScore = 0.7 }, listed(0.95), serverpb.Action_ACTION_DEFER, "example.com listed by Zetascan in shDBL,ubRed"},
+		{"all disabled", func(c *Config) { c.RejectScore = 0; c.QuarantineScore = 0 }, listed(0.95), serverpb.Action_ACTION_ALLOW, "example.com listed by Zetascan in shDBL,ubRed"},
+		{"no sources", nil, zetascan.JsonRecord{Results: zetascan.JsonResults{{Found: true, Score: 1}}}, serverpb.Action_ACTION_REJECT, "example.com listed by Zetascan"},
+		{"whitelisted", nil, zetascan.JsonRecord{Results: zetascan.JsonResults{{Found: true, Wl: true, Score: 0.95}}}, serverpb.Action_ACTION_ALLOW, "example.com whitelisted by Zetascan"},
+		{"not listed", nil, zetascan.JsonRecord{Results: zetascan.JsonResults{{Score: 0.95}}}, serverpb.Action_ACTION_ALLOW, ""},
+		{"no results", nil, zetascan.JsonRecord{}, serverpb.Action_ACTION_ALLOW, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			s := NewServer(nil)
+			if test.config != nil {
+				test.config(&s.Config)
+			}
+
+			d := s.Decide("example.com", test.record)
+
+			if d.Action != test.action || d.Reason != test.reason {
+				t.Errorf("Decide = %v %q, want %v %q", d.Action, d.Reason, test.action, test.reason)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	s, _ := newServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		item     string
+		want     string
+		itemType string
+		verdict  string
+		action   serverpb.Action
+	}{
+		{"127.9.9.1", "127.9.9.1", "ipv4", "listed", serverpb.Action_ACTION_REJECT},
+		{"127.9.9.3", "127.9.9.3", "ipv4", "listed", serverpb.Action_ACTION_QUARANTINE},
+		{"127.9.9.4", "127.9.9.4", "ipv4", "whitelisted", serverpb.Action_ACTION_ALLOW},
+		{"https://www.baddomain.org/path", "www.baddomain.org", "domain", "not listed", serverpb.Action_ACTION_ALLOW},
+		{"user@baddomain.org", "baddomain.org", "email", "listed", serverpb.Action_ACTION_REJECT},
+		{"192.0.2.1", "192.0.2.1", "ipv4", "not listed", serverpb.Action_ACTION_ALLOW},
+	}
+
+	for _, test := range tests {
+
+		resp, err := s.Check(ctx, &serverpb.CheckRequest{Item: test.item, Id: "1"})
+
+		if err != nil {
+			t.Errorf("%s: %v", test.item, err)
+			continue
+		}
+
+		if resp.Id != "1" || resp.Item != test.want || resp.ItemType != test.itemType || resp.Verdict != test.verdict || resp.Method != "json" {
+			t.Errorf("%s: response %v", test.item, resp)
+		}
+
+		if resp.Decision.GetAction() != test.action || resp.Result == nil {
+			t.Errorf("%s: decision %v, result %v", test.item, resp.Decision, resp.Result)
+		}
+	}
+
+	for _, req := range []*serverpb.CheckRequest{{Item: "1.2.3"}, {Item: ""}, {Item: "127.9.9.1", Method: "smtp"}} {
+		if _, err := s.Check(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%v: err = %v, want InvalidArgument", req, err)
+		}
+	}
+}
+
+// A failed lookup is decided by FailAction
+func TestCheckFailAction(t *testing.T) {
+
+	s, emulator := newServer(t)
+	emulator.InjectMethod("json", zetascantest.Burst(http.StatusForbidden, -1))
+
+	for _, action := range []serverpb.Action{serverpb.Action_ACTION_UNSPECIFIED, serverpb.Action_ACTION_DEFER} {
+
+		s.Config.FailAction = action
+
+		resp, err := s.Check(context.Background(), &serverpb.CheckRequest{Item: "127.9.9.1"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := action
+		if want == serverpb.Action_ACTION_UNSPECIFIED {
+			want = serverpb.Action_ACTION_ALLOW
+		}
+
+		if resp.Verdict != "error" || resp.ErrorType != "forbidden" || resp.Result != nil || resp.Decision.GetAction() != want {
+			t.Errorf("FailAction %v: response %v", action, resp)
+		}
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+
+	s, _ := newServer(t)
+	s.Config.MaxBatch = 4
+	s.Config.Concurrency = 2
+
+	resp, err := s.CheckBatch(context.Background(), &serverpb.CheckBatchRequest{Items: []string{"127.9.9.1", "1.2.3", "127.9.9.4", "baddomain.org"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"listed", "error", "whitelisted", "listed"}
+
+	if len(resp.Results) != len(want) {
+		t.Fatalf("%d results, want %d", len(resp.Results), len(want))
+	}
+
+	// In the order of the items, with invalid items answered individually
+	for i, r := range resp.Results {
+		if r.Verdict != want[i] {
+			t.Errorf("result %d: %v, want %s", i, r, want[i])
+		}
+	}
+
+	if r := resp.Results[1]; r.ErrorType != "invalid_item" || r.Decision != nil {
+		t.Errorf("invalid item %v", r)
+	}
+
+	// Beyond MaxBatch
+	_, err = s.CheckBatch(context.Background(), &serverpb.CheckBatchRequest{Items: []string{"a.com", "b.com", "c.com", "d.com", "e.com"}})
+
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "at most 4") {
+		t.Errorf("err = %v, want InvalidArgument", err)
+	}
+
+	s.Config.AllowAnyClient = true
+
+	r := httptest.NewRequest("POST", "/v1/batch", strings.NewReader(`{"items": ["a.com", "b.com", "c.com", "d.com", "e.com"]}`))
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("HTTP status = %d, want 400: %s", w.Code, w.Body)
+	}
+
+	if _, err := s.CheckBatch(context.Background(), &serverpb.CheckBatchRequest{Items: []string{"a.com"}, Method: "smtp"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("unknown method err = %v", err)
+	}
+}
+
+// dialGRPC serves the gRPC server over an in-memory listener, returning a client
+func dialGRPC(t *testing.T, s *Server) serverpb.ZetascanClient {
+
+	l := bufconn.Listen(1 << 20)
+
+	go s.ServeGRPC(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return serverpb.NewZetascanClient(conn)
+}
+
+func TestCheckStream(t *testing.T) {
+
+	s, _ := newServer(t)
+	s.Config.Tokens = []zetascan.Secret{"YOURTOKEN"}
+
+	client := dialGRPC(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Without a token
+	stream, err := client.CheckStream(ctx)
+
+	if err == nil {
+		_, err = stream.Recv()
+	}
+
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("without a token err = %v, want Unauthenticated", err)
+	}
+
+	stream, err = client.CheckStream(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer YOURTOKEN"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a": "listed", "b": "whitelisted", "c": "error", "d": "not listed"}
+
+	for id, item := range map[string]string{"a": "127.9.9.1", "b": "okdomain.org", "c": "1.2.3", "d": "192.0.2.1"} {
+		if err := stream.Send(&serverpb.CheckRequest{Id: id, Item: item}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Answered as each completes, matched by id
+	got := make(map[string]string)
+
+	for {
+		resp, err := stream.Recv()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got[resp.Id] = resp.Verdict
+	}
+
+	if !maps.Equal(got, want) {
+		t.Errorf("verdicts %v, want %v", got, want)
+	}
+
+	// An invalid method ends the stream
+	stream, err = client.CheckStream(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer YOURTOKEN"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream.Send(&serverpb.CheckRequest{Item: "127.9.9.1", Method: "smtp"})
+
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid method err = %v, want InvalidArgument", err)
+	}
+}
+
+func TestStreamGateway(t *testing.T) {
+
+	s, _ := newServer(t)
+	s.Config.Tokens = []zetascan.Secret{"YOURTOKEN"}
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	body := strings.Join([]string{
+		`{"id": "a", "item": "127.9.9.1"}`,
+		``,
+		`{"id": "b", "item": "baddomain.org", "method": "jsonx"}`,
+		`not json`,
+		`{"id": "c", "item": "127.9.9.1", "method": "smtp"}`,
+		`{"id": "d", "item": "1.2.3"}`,
+	}, "\n")
+
+	r, err := http.NewRequest("POST", ts.URL+"/v1/stream", strings.NewReader(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Authorization", "Bearer YOURTOKEN")
+
+	resp, err := http.DefaultClient.Do(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	got := make(map[string]*serverpb.CheckResponse)
+	scanner := bufio.NewScanner(resp.Body)
+
+	for scanner.Scan() {
+
+		line := &serverpb.CheckResponse{}
+
+		if err := protojson.Unmarshal(scanner.Bytes(), line); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+
+		got[line.Id] = line
+	}
+
+	if len(got) != 5 {
+		t.Fatalf("%d responses, want 5 (one per request line)", len(got))
+	}
+
+	if got["a"].Verdict != "listed" || got["a"].Decision.GetAction() != serverpb.Action_ACTION_REJECT {
+		t.Errorf("a: %v", got["a"])
+	}
+
+	if got["b"].Verdict != "listed" || got["b"].Method != "jsonx" {
+		t.Errorf("b: %v", got["b"])
+	}
+
+	// The malformed line has no id
+	if got[""].ErrorType != "invalid_request" || got["c"].ErrorType != "invalid_request" || got["d"].ErrorType != "invalid_item" {
+		t.Errorf("errors: %v, %v, %v", got[""], got["c"], got["d"])
+	}
+
+	// A token is required
+	resp, err = http.Post(ts.URL+"/v1/stream", "application/x-ndjson", strings.NewReader(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a token status = %d, want 401", resp.StatusCode)
+	}
+}
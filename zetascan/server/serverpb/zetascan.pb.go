@@ -0,0 +1,877 @@
+// The zetascan lookup service, served by zetascan-server over gRPC and as JSON
+// over HTTP (see the zetascan/server package).
+//
+// Regenerate the Go code after changes with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//	    zetascan/server/serverpb/zetascan.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: zetascan/server/serverpb/zetascan.proto
+
+package serverpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Action int32
+
+const (
+	Action_ACTION_UNSPECIFIED Action = 0
+	Action_ACTION_ALLOW       Action = 1
+	Action_ACTION_QUARANTINE  Action = 2
+	Action_ACTION_DEFER       Action = 3
+	Action_ACTION_REJECT      Action = 4
+)
+
+// Enum value maps for Action.
+var (
+	Action_name = map[int32]string{
+		0: "ACTION_UNSPECIFIED",
+		1: "ACTION_ALLOW",
+		2: "ACTION_QUARANTINE",
+		3: "ACTION_DEFER",
+		4: "ACTION_REJECT",
+	}
+	Action_value = map[string]int32{
+		"ACTION_UNSPECIFIED": 0,
+		"ACTION_ALLOW":       1,
+		"ACTION_QUARANTINE":  2,
+		"ACTION_DEFER":       3,
+		"ACTION_REJECT":      4,
+	}
+)
+
+func (x Action) Enum() *Action {
+	p := new(Action)
+	*p = x
+	return p
+}
+
+func (x Action) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Action) Descriptor() protoreflect.EnumDescriptor {
+	return file_zetascan_server_serverpb_zetascan_proto_enumTypes[0].Descriptor()
+}
+
+func (Action) Type() protoreflect.EnumType {
+	return &file_zetascan_server_serverpb_zetascan_proto_enumTypes[0]
+}
+
+func (x Action) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Action.Descriptor instead.
+func (Action) EnumDescriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{0}
+}
+
+type CheckRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// A domain, IPv4 or IPv6 address, email address or URL
+	Item string `protobuf:"bytes,1,opt,name=item,proto3" json:"item,omitempty"`
+	// The query method (http, text, json, jsonx or dns), or a comma separated
+	// fallback chain e.g "dns,jsonx", the server's method if empty
+	Method string `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	// Look up listed items via jsonx for the extended data
+	Enrich bool `protobuf:"varint,3,opt,name=enrich,proto3" json:"enrich,omitempty"`
+	// Returned in the response, to match stream responses to requests
+	Id            string `protobuf:"bytes,4,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CheckRequest) GetItem() string {
+	if x != nil {
+		return x.Item
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetEnrich() bool {
+	if x != nil {
+		return x.Enrich
+	}
+	return false
+}
+
+func (x *CheckRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type CheckBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []string               `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	Method        string                 `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	Enrich        bool                   `protobuf:"varint,3,opt,name=enrich,proto3" json:"enrich,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckBatchRequest) Reset() {
+	*x = CheckBatchRequest{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckBatchRequest) ProtoMessage() {}
+
+func (x *CheckBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckBatchRequest.ProtoReflect.Descriptor instead.
+func (*CheckBatchRequest) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CheckBatchRequest) GetItems() []string {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+func (x *CheckBatchRequest) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *CheckBatchRequest) GetEnrich() bool {
+	if x != nil {
+		return x.Enrich
+	}
+	return false
+}
+
+type CheckBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*CheckResponse       `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckBatchResponse) Reset() {
+	*x = CheckBatchResponse{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckBatchResponse) ProtoMessage() {}
+
+func (x *CheckBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckBatchResponse.ProtoReflect.Descriptor instead.
+func (*CheckBatchResponse) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CheckBatchResponse) GetResults() []*CheckResponse {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type CheckResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The normalised item queried, e.g the host of a URL, and its type
+	// (ipv4, ipv6, domain or email)
+	Item     string `protobuf:"bytes,2,opt,name=item,proto3" json:"item,omitempty"`
+	ItemType string `protobuf:"bytes,3,opt,name=item_type,json=itemType,proto3" json:"item_type,omitempty"`
+	// listed, whitelisted, not listed or error
+	Verdict string `protobuf:"bytes,4,opt,name=verdict,proto3" json:"verdict,omitempty"`
+	// Not set if the lookup failed
+	Result *Result `protobuf:"bytes,5,opt,name=result,proto3" json:"result,omitempty"`
+	// Not set if the item is invalid
+	Decision *Decision `protobuf:"bytes,6,opt,name=decision,proto3" json:"decision,omitempty"`
+	// The method that answered
+	Method string `protobuf:"bytes,7,opt,name=method,proto3" json:"method,omitempty"`
+	// Why the lookup failed, and its type e.g timeout or forbidden
+	Error         string `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+	ErrorType     string `protobuf:"bytes,9,opt,name=error_type,json=errorType,proto3" json:"error_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CheckResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetItem() string {
+	if x != nil {
+		return x.Item
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetItemType() string {
+	if x != nil {
+		return x.ItemType
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetVerdict() string {
+	if x != nil {
+		return x.Verdict
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetResult() *Result {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *CheckResponse) GetDecision() *Decision {
+	if x != nil {
+		return x.Decision
+	}
+	return nil
+}
+
+func (x *CheckResponse) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetErrorType() string {
+	if x != nil {
+		return x.ErrorType
+	}
+	return ""
+}
+
+// Result is the zetascan answer for an item
+type Result struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Found         bool                   `protobuf:"varint,1,opt,name=found,proto3" json:"found,omitempty"`
+	Score         float64                `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+	WebScore      float64                `protobuf:"fixed64,3,opt,name=web_score,json=webScore,proto3" json:"web_score,omitempty"`
+	FromSubnet    bool                   `protobuf:"varint,4,opt,name=from_subnet,json=fromSubnet,proto3" json:"from_subnet,omitempty"`
+	Sources       []string               `protobuf:"bytes,5,rep,name=sources,proto3" json:"sources,omitempty"`
+	Whitelisted   bool                   `protobuf:"varint,6,opt,name=whitelisted,proto3" json:"whitelisted,omitempty"`
+	WhitelistData string                 `protobuf:"bytes,7,opt,name=whitelist_data,json=whitelistData,proto3" json:"whitelist_data,omitempty"`
+	// The label that matched, for registered domain lookups
+	Matched string `protobuf:"bytes,8,opt,name=matched,proto3" json:"matched,omitempty"`
+	// Only from the jsonx method, or with enrich
+	Extended      *Extended `protobuf:"bytes,9,opt,name=extended,proto3" json:"extended,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Result) GetFound() bool {
+	if x != nil {
+		return x.Found
+	}
+	return false
+}
+
+func (x *Result) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Result) GetWebScore() float64 {
+	if x != nil {
+		return x.WebScore
+	}
+	return 0
+}
+
+func (x *Result) GetFromSubnet() bool {
+	if x != nil {
+		return x.FromSubnet
+	}
+	return false
+}
+
+func (x *Result) GetSources() []string {
+	if x != nil {
+		return x.Sources
+	}
+	return nil
+}
+
+func (x *Result) GetWhitelisted() bool {
+	if x != nil {
+		return x.Whitelisted
+	}
+	return false
+}
+
+func (x *Result) GetWhitelistData() string {
+	if x != nil {
+		return x.WhitelistData
+	}
+	return ""
+}
+
+func (x *Result) GetMatched() string {
+	if x != nil {
+		return x.Matched
+	}
+	return ""
+}
+
+func (x *Result) GetExtended() *Extended {
+	if x != nil {
+		return x.Extended
+	}
+	return nil
+}
+
+type Extended struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AsNumber      string                 `protobuf:"bytes,1,opt,name=as_number,json=asNumber,proto3" json:"as_number,omitempty"`
+	Route         string                 `protobuf:"bytes,2,opt,name=route,proto3" json:"route,omitempty"`
+	Country       string                 `protobuf:"bytes,3,opt,name=country,proto3" json:"country,omitempty"`
+	Domain        string                 `protobuf:"bytes,4,opt,name=domain,proto3" json:"domain,omitempty"`
+	State         string                 `protobuf:"bytes,5,opt,name=state,proto3" json:"state,omitempty"`
+	Time          string                 `protobuf:"bytes,6,opt,name=time,proto3" json:"time,omitempty"`
+	Reason        *Reason                `protobuf:"bytes,7,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Extended) Reset() {
+	*x = Extended{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Extended) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Extended) ProtoMessage() {}
+
+func (x *Extended) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Extended.ProtoReflect.Descriptor instead.
+func (*Extended) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Extended) GetAsNumber() string {
+	if x != nil {
+		return x.AsNumber
+	}
+	return ""
+}
+
+func (x *Extended) GetRoute() string {
+	if x != nil {
+		return x.Route
+	}
+	return ""
+}
+
+func (x *Extended) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+func (x *Extended) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *Extended) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Extended) GetTime() string {
+	if x != nil {
+		return x.Time
+	}
+	return ""
+}
+
+func (x *Extended) GetReason() *Reason {
+	if x != nil {
+		return x.Reason
+	}
+	return nil
+}
+
+type Reason struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Class         string                 `protobuf:"bytes,1,opt,name=class,proto3" json:"class,omitempty"`
+	Rule          string                 `protobuf:"bytes,2,opt,name=rule,proto3" json:"rule,omitempty"`
+	Type          string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	Name          string                 `protobuf:"bytes,4,opt,name=name,proto3" json:"name,omitempty"`
+	Source        string                 `protobuf:"bytes,5,opt,name=source,proto3" json:"source,omitempty"`
+	Port          string                 `protobuf:"bytes,6,opt,name=port,proto3" json:"port,omitempty"`
+	SourcePort    string                 `protobuf:"bytes,7,opt,name=source_port,json=sourcePort,proto3" json:"source_port,omitempty"`
+	Destination   string                 `protobuf:"bytes,8,opt,name=destination,proto3" json:"destination,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Reason) Reset() {
+	*x = Reason{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Reason) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reason) ProtoMessage() {}
+
+func (x *Reason) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reason.ProtoReflect.Descriptor instead.
+func (*Reason) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Reason) GetClass() string {
+	if x != nil {
+		return x.Class
+	}
+	return ""
+}
+
+func (x *Reason) GetRule() string {
+	if x != nil {
+		return x.Rule
+	}
+	return ""
+}
+
+func (x *Reason) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Reason) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Reason) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Reason) GetPort() string {
+	if x != nil {
+		return x.Port
+	}
+	return ""
+}
+
+func (x *Reason) GetSourcePort() string {
+	if x != nil {
+		return x.SourcePort
+	}
+	return ""
+}
+
+func (x *Reason) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+// Decision is the policy action for the result, from the server's score
+// thresholds
+type Decision struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Action Action                 `protobuf:"varint,1,opt,name=action,proto3,enum=zetascan.v1.Action" json:"action,omitempty"`
+	// A single line reason, e.g "example.com listed by Zetascan in ubBlack"
+	Reason        string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Decision) Reset() {
+	*x = Decision{}
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Decision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Decision) ProtoMessage() {}
+
+func (x *Decision) ProtoReflect() protoreflect.Message {
+	mi := &file_zetascan_server_serverpb_zetascan_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Decision.ProtoReflect.Descriptor instead.
+func (*Decision) Descriptor() ([]byte, []int) {
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Decision) GetAction() Action {
+	if x != nil {
+		return x.Action
+	}
+	return Action_ACTION_UNSPECIFIED
+}
+
+func (x *Decision) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_zetascan_server_serverpb_zetascan_proto protoreflect.FileDescriptor
+
+const file_zetascan_server_serverpb_zetascan_proto_rawDesc = "" +
+	"\n" +
+	"'zetascan/server/serverpb/zetascan.proto\x12\vzetascan.v1\"b\n" +
+	"\fCheckRequest\x12\x12\n" +
+	"\x04item\x18\x01 \x01(\tR\x04item\x12\x16\n" +
+	"\x06method\x18\x02 \x01(\tR\x06method\x12\x16\n" +
+	"\x06enrich\x18\x03 \x01(\bR\x06enrich\x12\x0e\n" +
+	"\x02id\x18\x04 \x01(\tR\x02id\"Y\n" +
+	"\x11CheckBatchRequest\x12\x14\n" +
+	"\x05items\x18\x01 \x03(\tR\x05items\x12\x16\n" +
+	"\x06method\x18\x02 \x01(\tR\x06method\x12\x16\n" +
+	"\x06enrich\x18\x03 \x01(\bR\x06enrich\"J\n" +
+	"\x12CheckBatchResponse\x124\n" +
+	"\aresults\x18\x01 \x03(\v2\x1a.zetascan.v1.CheckResponseR\aresults\"\x97\x02\n" +
+	"\rCheckResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04item\x18\x02 \x01(\tR\x04item\x12\x1b\n" +
+	"\titem_type\x18\x03 \x01(\tR\bitemType\x12\x18\n" +
+	"\averdict\x18\x04 \x01(\tR\averdict\x12+\n" +
+	"\x06result\x18\x05 \x01(\v2\x13.zetascan.v1.ResultR\x06result\x121\n" +
+	"\bdecision\x18\x06 \x01(\v2\x15.zetascan.v1.DecisionR\bdecision\x12\x16\n" +
+	"\x06method\x18\a \x01(\tR\x06method\x12\x14\n" +
+	"\x05error\x18\b \x01(\tR\x05error\x12\x1d\n" +
+	"\n" +
+	"error_type\x18\t \x01(\tR\terrorType\"\xa2\x02\n" +
+	"\x06Result\x12\x14\n" +
+	"\x05found\x18\x01 \x01(\bR\x05found\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\x01R\x05score\x12\x1b\n" +
+	"\tweb_score\x18\x03 \x01(\x01R\bwebScore\x12\x1f\n" +
+	"\vfrom_subnet\x18\x04 \x01(\bR\n" +
+	"fromSubnet\x12\x18\n" +
+	"\asources\x18\x05 \x03(\tR\asources\x12 \n" +
+	"\vwhitelisted\x18\x06 \x01(\bR\vwhitelisted\x12%\n" +
+	"\x0ewhitelist_data\x18\a \x01(\tR\rwhitelistData\x12\x18\n" +
+	"\amatched\x18\b \x01(\tR\amatched\x121\n" +
+	"\bextended\x18\t \x01(\v2\x15.zetascan.v1.ExtendedR\bextended\"\xc6\x01\n" +
+	"\bExtended\x12\x1b\n" +
+	"\tas_number\x18\x01 \x01(\tR\basNumber\x12\x14\n" +
+	"\x05route\x18\x02 \x01(\tR\x05route\x12\x18\n" +
+	"\acountry\x18\x03 \x01(\tR\acountry\x12\x16\n" +
+	"\x06domain\x18\x04 \x01(\tR\x06domain\x12\x14\n" +
+	"\x05state\x18\x05 \x01(\tR\x05state\x12\x12\n" +
+	"\x04time\x18\x06 \x01(\tR\x04time\x12+\n" +
+	"\x06reason\x18\a \x01(\v2\x13.zetascan.v1.ReasonR\x06reason\"\xc9\x01\n" +
+	"\x06Reason\x12\x14\n" +
+	"\x05class\x18\x01 \x01(\tR\x05class\x12\x12\n" +
+	"\x04rule\x18\x02 \x01(\tR\x04rule\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x12\n" +
+	"\x04name\x18\x04 \x01(\tR\x04name\x12\x16\n" +
+	"\x06source\x18\x05 \x01(\tR\x06source\x12\x12\n" +
+	"\x04port\x18\x06 \x01(\tR\x04port\x12\x1f\n" +
+	"\vsource_port\x18\a \x01(\tR\n" +
+	"sourcePort\x12 \n" +
+	"\vdestination\x18\b \x01(\tR\vdestination\"O\n" +
+	"\bDecision\x12+\n" +
+	"\x06action\x18\x01 \x01(\x0e2\x13.zetascan.v1.ActionR\x06action\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason*n\n" +
+	"\x06Action\x12\x16\n" +
+	"\x12ACTION_UNSPECIFIED\x10\x00\x12\x10\n" +
+	"\fACTION_ALLOW\x10\x01\x12\x15\n" +
+	"\x11ACTION_QUARANTINE\x10\x02\x12\x10\n" +
+	"\fACTION_DEFER\x10\x03\x12\x11\n" +
+	"\rACTION_REJECT\x10\x042\xe3\x01\n" +
+	"\bZetascan\x12>\n" +
+	"\x05Check\x12\x19.zetascan.v1.CheckRequest\x1a\x1a.zetascan.v1.CheckResponse\x12M\n" +
+	"\n" +
+	"CheckBatch\x12\x1e.zetascan.v1.CheckBatchRequest\x1a\x1f.zetascan.v1.CheckBatchResponse\x12H\n" +
+	"\vCheckStream\x12\x19.zetascan.v1.CheckRequest\x1a\x1a.zetascan.v1.CheckResponse(\x010\x01B:Z8github.com/zetascan/go-zetascan/zetascan/server/serverpbb\x06proto3"
+
+var (
+	file_zetascan_server_serverpb_zetascan_proto_rawDescOnce sync.Once
+	file_zetascan_server_serverpb_zetascan_proto_rawDescData []byte
+)
+
+func file_zetascan_server_serverpb_zetascan_proto_rawDescGZIP() []byte {
+	file_zetascan_server_serverpb_zetascan_proto_rawDescOnce.Do(func() {
+		file_zetascan_server_serverpb_zetascan_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_zetascan_server_serverpb_zetascan_proto_rawDesc), len(file_zetascan_server_serverpb_zetascan_proto_rawDesc)))
+	})
+	return file_zetascan_server_serverpb_zetascan_proto_rawDescData
+}
+
+var file_zetascan_server_serverpb_zetascan_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_zetascan_server_serverpb_zetascan_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_zetascan_server_serverpb_zetascan_proto_goTypes = []any{
+	(Action)(0),                // 0: zetascan.v1.Action
+	(*CheckRequest)(nil),       // 1: zetascan.v1.CheckRequest
+	(*CheckBatchRequest)(nil),  // 2: zetascan.v1.CheckBatchRequest
+	(*CheckBatchResponse)(nil), // 3: zetascan.v1.CheckBatchResponse
+	(*CheckResponse)(nil),      // 4: zetascan.v1.CheckResponse
+	(*Result)(nil),             // 5: zetascan.v1.Result
+	(*Extended)(nil),           // 6: zetascan.v1.Extended
+	(*Reason)(nil),             // 7: zetascan.v1.Reason
+	(*Decision)(nil),           // 8: zetascan.v1.Decision
+}
+var file_zetascan_server_serverpb_zetascan_proto_depIdxs = []int32{
+	4, // 0: zetascan.v1.CheckBatchResponse.results:type_name -> zetascan.v1.CheckResponse
+	5, // 1: zetascan.v1.CheckResponse.result:type_name -> zetascan.v1.Result
+	8, // 2: zetascan.v1.CheckResponse.decision:type_name -> zetascan.v1.Decision
+	6, // 3: zetascan.v1.Result.extended:type_name -> zetascan.v1.Extended
+	7, // 4: zetascan.v1.Extended.reason:type_name -> zetascan.v1.Reason
+	0, // 5: zetascan.v1.Decision.action:type_name -> zetascan.v1.Action
+	1, // 6: zetascan.v1.Zetascan.Check:input_type -> zetascan.v1.CheckRequest
+	2, // 7: zetascan.v1.Zetascan.CheckBatch:input_type -> zetascan.v1.CheckBatchRequest
+	1, // 8: zetascan.v1.Zetascan.CheckStream:input_type -> zetascan.v1.CheckRequest
+	4, // 9: zetascan.v1.Zetascan.Check:output_type -> zetascan.v1.CheckResponse
+	3, // 10: zetascan.v1.Zetascan.CheckBatch:output_type -> zetascan.v1.CheckBatchResponse
+	4, // 11: zetascan.v1.Zetascan.CheckStream:output_type -> zetascan.v1.CheckResponse
+	9, // [9:12] is the sub-list for method output_type
+	6, // [6:9] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_zetascan_server_serverpb_zetascan_proto_init() }
+func file_zetascan_server_serverpb_zetascan_proto_init() {
+	if File_zetascan_server_serverpb_zetascan_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_zetascan_server_serverpb_zetascan_proto_rawDesc), len(file_zetascan_server_serverpb_zetascan_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_zetascan_server_serverpb_zetascan_proto_goTypes,
+		DependencyIndexes: file_zetascan_server_serverpb_zetascan_proto_depIdxs,
+		EnumInfos:         file_zetascan_server_serverpb_zetascan_proto_enumTypes,
+		MessageInfos:      file_zetascan_server_serverpb_zetascan_proto_msgTypes,
+	}.Build()
+	File_zetascan_server_serverpb_zetascan_proto = out.File
+	file_zetascan_server_serverpb_zetascan_proto_goTypes = nil
+	file_zetascan_server_serverpb_zetascan_proto_depIdxs = nil
+}
@@ -0,0 +1,219 @@
+// The zetascan lookup service, served by zetascan-server over gRPC and as JSON
+// over HTTP (see the zetascan/server package).
+//
+// Regenerate the Go code after changes with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//	    zetascan/server/serverpb/zetascan.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: zetascan/server/serverpb/zetascan.proto
+
+package serverpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Zetascan_Check_FullMethodName       = "/zetascan.v1.Zetascan/Check"
+	Zetascan_CheckBatch_FullMethodName  = "/zetascan.v1.Zetascan/CheckBatch"
+	Zetascan_CheckStream_FullMethodName = "/zetascan.v1.Zetascan/CheckStream"
+)
+
+// ZetascanClient is the client API for Zetascan service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Zetascan looks up domains, IPs and email addresses, returning the result and
+// the policy decision for it. Calls need an "authorization: Bearer <token>"
+// header if the server has tokens.
+type ZetascanClient interface {
+	// Check looks up one item. An invalid item fails with INVALID_ARGUMENT, a
+	// failed lookup is answered with its error and the server's fail action.
+	Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckResponse, error)
+	// CheckBatch looks up many items in parallel, answering in the same order
+	CheckBatch(ctx context.Context, in *CheckBatchRequest, opts ...grpc.CallOption) (*CheckBatchResponse, error)
+	// CheckStream looks up each item as it's received, answering as each
+	// completes, in any order. Use the request id to match them.
+	CheckStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CheckRequest, CheckResponse], error)
+}
+
+type zetascanClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewZetascanClient(cc grpc.ClientConnInterface) ZetascanClient {
+	return &zetascanClient{cc}
+}
+
+func (c *zetascanClient) Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CheckResponse)
+	err := c.cc.Invoke(ctx, Zetascan_Check_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zetascanClient) CheckBatch(ctx context.Context, in *CheckBatchRequest, opts ...grpc.CallOption) (*CheckBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CheckBatchResponse)
+	err := c.cc.Invoke(ctx, Zetascan_CheckBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zetascanClient) CheckStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CheckRequest, CheckResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Zetascan_ServiceDesc.Streams[0], Zetascan_CheckStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CheckRequest, CheckResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Zetascan_CheckStreamClient = grpc.BidiStreamingClient[CheckRequest, CheckResponse]
+
+// ZetascanServer is the server API for Zetascan service.
+// All implementations must embed UnimplementedZetascanServer
+// for forward compatibility.
+//
+// Zetascan looks up domains, IPs and email addresses, returning the result and
+// the policy decision for it. Calls need an "authorization: Bearer <token>"
+// header if the server has tokens.
+type ZetascanServer interface {
+	// Check looks up one item. An invalid item fails with INVALID_ARGUMENT, a
+	// failed lookup is answered with its error and the server's fail action.
+	Check(context.Context, *CheckRequest) (*CheckResponse, error)
+	// CheckBatch looks up many items in parallel, answering in the same order
+	CheckBatch(context.Context, *CheckBatchRequest) (*CheckBatchResponse, error)
+	// CheckStream looks up each item as it's received, answering as each
+	// completes, in any order. Use the request id to match them.
+	CheckStream(grpc.BidiStreamingServer[CheckRequest, CheckResponse]) error
+	mustEmbedUnimplementedZetascanServer()
+}
+
+// UnimplementedZetascanServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedZetascanServer struct{}
+
+func (UnimplementedZetascanServer) Check(context.Context, *CheckRequest) (*CheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Check not implemented")
+}
+func (UnimplementedZetascanServer) CheckBatch(context.Context, *CheckBatchRequest) (*CheckBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckBatch not implemented")
+}
+func (UnimplementedZetascanServer) CheckStream(grpc.BidiStreamingServer[CheckRequest, CheckResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method CheckStream not implemented")
+}
+func (UnimplementedZetascanServer) mustEmbedUnimplementedZetascanServer() {}
+func (UnimplementedZetascanServer) testEmbeddedByValue()                  {}
+
+// UnsafeZetascanServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ZetascanServer will
+// result in compilation errors.
+type UnsafeZetascanServer interface {
+	mustEmbedUnimplementedZetascanServer()
+}
+
+func RegisterZetascanServer(s grpc.ServiceRegistrar, srv ZetascanServer) {
+	// If the following call pancis, it indicates UnimplementedZetascanServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Zetascan_ServiceDesc, srv)
+}
+
+func _Zetascan_Check_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZetascanServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zetascan_Check_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZetascanServer).Check(ctx, req.(*CheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zetascan_CheckBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZetascanServer).CheckBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Zetascan_CheckBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZetascanServer).CheckBatch(ctx, req.(*CheckBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Zetascan_CheckStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ZetascanServer).CheckStream(&grpc.GenericServerStream[CheckRequest, CheckResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Zetascan_CheckStreamServer = grpc.BidiStreamingServer[CheckRequest, CheckResponse]
+
+// Zetascan_ServiceDesc is the grpc.ServiceDesc for Zetascan service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Zetascan_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "zetascan.v1.Zetascan",
+	HandlerType: (*ZetascanServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Check",
+			Handler:    _Zetascan_Check_Handler,
+		},
+		{
+			MethodName: "CheckBatch",
+			Handler:    _Zetascan_CheckBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CheckStream",
+			Handler:       _Zetascan_CheckStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "zetascan/server/serverpb/zetascan.proto",
+}
@@ -0,0 +1,78 @@
+package zetascan
+
+import "context"
+
+// Tracer starts spans around queries, see Api.Tracer. Spans are children of
+// any span in the caller's context. zetascan/oteltrace is an adapter for
+// OpenTelemetry.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer
+type Span interface {
+	// SetAttribute sets a string, bool, int or float64 attribute
+	SetAttribute(key string, value any)
+
+	// RecordError marks the span as failed
+	RecordError(err error)
+
+	End()
+}
+
+// Span names and attribute keys
+const (
+	SpanQuery      = "zetascan.Query"      // A query, via any method
+	SpanBatch      = "zetascan.QueryBatch" // QueryBatch and QueryEach
+	SpanHTTP       = "zetascan.http"       // A web request to one endpoint
+	SpanDNS        = "zetascan.dns"        // A DNS exchange, including retries
+	SpanRevalidate = "zetascan.revalidate" // The background refresh of a stale cached result
+
+	AttrItemType    = "zetascan.item.type" // ipv4, ipv6, domain or email
+	AttrItem        = "zetascan.item"      // Only if Api.TraceItems is set
+	AttrMethod      = "zetascan.method"
+	AttrEndpoint    = "zetascan.endpoint"
+	AttrDNSServer   = "zetascan.dns.server"
+	AttrDNSNetwork  = "zetascan.dns.network" // udp or tcp
+	AttrDNSRcode    = "zetascan.dns.rcode"
+	AttrAttempt     = "zetascan.attempt"      // 0 for the first attempt
+	AttrRetry       = "zetascan.retry.reason" // Why the attempt is retried
+	AttrStatusCode  = "http.status_code"
+	AttrVerdict     = "zetascan.verdict"
+	AttrScore       = "zetascan.score"
+	AttrCacheHit    = "zetascan.cache_hit"
+	AttrCacheStale  = "zetascan.cache_stale" // A stale result was served, and is being refreshed
+	AttrBatchSize   = "zetascan.batch.size"
+	AttrConcurrency = "zetascan.batch.concurrency"
+)
+
+// startSpan starts a span if a tracer is set
+func (myapi Api) startSpan(ctx context.Context, name string) (context.Context, Span) {
+
+	if myapi.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return myapi.Tracer.Start(ctx, name)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value any) {}
+func (noopSpan) RecordError(err error)              {}
+func (noopSpan) End()                               {}
+
+// endQuerySpan sets the outcome of a query on its span
+func (myapi Api) endQuerySpan(span Span, endpoint string, m JsonRecord, err error) {
+
+	span.SetAttribute(AttrEndpoint, endpoint)
+	span.SetAttribute(AttrVerdict, myapi.verdictOf(m, err))
+
+	if err != nil {
+		span.RecordError(err)
+	} else if len(m.Results) > 0 {
+		span.SetAttribute(AttrScore, m.Results[0].Score)
+	}
+
+	span.End()
+}
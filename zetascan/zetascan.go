@@ -1,16 +1,18 @@
 package zetascan
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -18,20 +20,68 @@ import (
 
 // Api struct for key, URL and method
 type Api struct {
-	apiKey      string
+	apiKey      Secret
 	apiURL      string
 	ApiMethod   string
 	apiVersion  string
 	apiProtocol string
 	DnsMethod   string
-	DnsType     string
+	DnsType     string // A for listing codes, or TXT for the text format answer
+	DnsServer   string
+	apiFallback []string
+
+	// MethodFallback are methods queried in order when ApiMethod fails, e.g
+	// jsonx after dns. EnrichOnHit fills in the extended data of items listed
+	// via another method with a jsonx query.
+	MethodFallback []string
+	EnrichOnHit    bool
+
+	// Keys supplies the API keys, overriding the key given to Init, so they can
+	// be rotated or differ by endpoint. A forbidden key falls back to the next.
+	Keys KeyProvider
+
+	// The last key that answered each endpoint, shared by copies of the Api
+	lastKey *lastKeys
+
+	// HeaderAuth sends the API key in the X-Api-Key header rather than the
+	// URL, for end-points supporting it, so it is not written to access logs
+	HeaderAuth bool
+
+	// Metrics receives query counts, latencies, retries and failovers, if set
+	Metrics Metrics
+
+	// Logger receives debug logs of requests and DNS answers, and warnings of
+	// retries, failovers and parse failures. Nothing is logged if nil.
+	Logger *slog.Logger
+
+	// Tracer starts spans for queries, attempts and batches, if set.
+	// The raw item is only added to spans if TraceItems is set.
+	Tracer     Tracer
+	TraceItems bool
+
+	// Cache stores results for CacheTTL (DefaultCacheTTL if 0), if set. Expired
+	// results are served for up to CacheStale longer while they're refreshed in
+	// the background (stale-while-revalidate), 0 to query them again.
+	Cache      Cache
+	CacheTTL   time.Duration
+	CacheStale time.Duration
+
+	// The cache keys being refreshed in the background, by this Api and its
+	// copies, or a Client
+	revalidating *sync.Map
+
+	// Connection pool shared by the copies of a Client's Api, see NewClient
+	client *http.Client
 }
 
 type Query struct {
-	apiKey   string
+	apiKey   Secret
 	apiQuery string
 }
 
+// KeyHeader is the request header carrying the API key if Api.HeaderAuth is set
+const KeyHeader = "X-Api-Key"
+
 // Format for JSON and JSONX responses
 
 type JsonReason struct {
@@ -55,7 +105,7 @@ type JsonExtended struct {
 	Reason  JsonReason `json:"reason"`
 }
 
-type JsonResults []struct {
+type JsonResult struct {
 	Item       string       `json:"item"`
 	Found      bool         `json:"found"`
 	Score      float64      `json:"score"`
@@ -65,12 +115,16 @@ type JsonResults []struct {
 	Wl         bool         `json:"wl"`
 	Wldata     string       `json:"wldata"`
 	Extended   JsonExtended `json:"extended"`
+	Matched    string       `json:"matched,omitempty"` // Label that matched, for registered domain lookups
 }
 
+type JsonResults []JsonResult
+
 type JsonRecord struct {
 	Results       JsonResults `json:"results"`
 	ExecutionTime int64       `json:"executionTime"`
 	Status        string      `json:"status"`
+	Method        string      `json:"method,omitempty"` // Method that answered, set by QueryContext
 }
 
 type Results struct {
@@ -85,8 +139,7 @@ type Results struct {
 func (myapi Api) Init(apiKey string, ipcheck bool) (myapi2 Api, err error) {
 
 	if apiKey != "" {
-		myapi.apiKey = apiKey
-		//return myapi, errors.New("API Key must be specified")
+		myapi.apiKey = Secret(apiKey)
 	}
 
 	// TODO: Change to new zetascan URL
@@ -105,7 +158,13 @@ func (myapi Api) Init(apiKey string, ipcheck bool) (myapi2 Api, err error) {
 	myapi.DnsMethod = "nameserver"
 
 	// Support lookups with A records or txt
-	myapi.DnsType = "A"
+	myapi.DnsType = RecordA
+
+	// Nameserver used for DNS queries, host:port
+	myapi.DnsServer = "api.zetascan.com:53"
+
+	myapi.lastKey = &lastKeys{}
+	myapi.revalidating = &sync.Map{}
 
 	// Check if https required
 	if myapi.apiProtocol == "http" && apiKey != "" && ipcheck == false {
@@ -117,66 +176,281 @@ func (myapi Api) Init(apiKey string, ipcheck bool) (myapi2 Api, err error) {
 
 // Query a domain/IP via any method (text, html, json, jsonx, dns)
 func (myapi Api) Query(query string) (m JsonRecord, err error) {
+	return myapi.QueryContext(context.Background(), query)
+}
+
+// QueryContext a domain/IP via any method, cancelled when ctx is done. Failed
+// queries fall back to each method of MethodFallback in turn, and with
+// EnrichOnHit listed items are looked up via jsonx for the extended data.
+func (myapi Api) QueryContext(ctx context.Context, query string) (m JsonRecord, err error) {
+
+	m, err = myapi.queryChain(ctx, query)
+
+	if err == nil && myapi.EnrichOnHit {
+		m = myapi.enrich(ctx, query, m)
+	}
+
+	return m, err
+}
+
+// queryMethod a domain/IP via ApiMethod
+func (myapi Api) queryMethod(ctx context.Context, query string) (m JsonRecord, err error) {
+
+	start := time.Now()
+	endpoint := myapi.endpointFor(myapi.ApiMethod)
+
+	ctx, span := myapi.startSpan(ctx, SpanQuery)
+	span.SetAttribute(AttrMethod, myapi.ApiMethod)
+
+	defer func() {
+		myapi.observeQuery(start, endpoint, m, err)
+		myapi.endQuerySpan(span, endpoint, m, err)
+	}()
+
+	// Classify and normalise the item, rejecting anything unusable
+	item, err := ParseItem(query)
+
+	if err != nil {
+		return m, err
+	}
+
+	span.SetAttribute(AttrItemType, item.Type.String())
+
+	if myapi.TraceItems {
+		span.SetAttribute(AttrItem, item.String())
+	}
+
+	// Answer from the client's cache, if any
+	if cached, ok := myapi.cached(ctx, span, item); ok {
+		endpoint = "cache"
+		return cached, nil
+	}
+
+	m, endpoint, err = myapi.lookup(ctx, item)
+
+	return m, err
+}
+
+// lookup queries an item via the method, caching the result. The endpoint
+// queried is returned.
+func (myapi Api) lookup(ctx context.Context, item Item) (m JsonRecord, endpoint string, err error) {
+
+	endpoint = myapi.endpointFor(myapi.ApiMethod)
 
 	// If DNS, run a specific function, otherwise all web queries via http.Get
-	if myapi.ApiMethod == "dns" {
-		results, _ := myapi.QueryDNS(query, 3)
+	if myapi.ApiMethod == "dns" && strings.EqualFold(myapi.DnsType, RecordTXT) {
+		m, err = myapi.queryTXT(ctx, item.DNSName(), 3)
+
+		if err != nil {
+			return m, endpoint, err
+		}
+
+	} else if myapi.ApiMethod == "dns" {
+		results, err := myapi.queryDNS(ctx, item.DNSName(), 3)
+
+		if err != nil {
+			return m, endpoint, err
+		}
+
 		m, _ = myapi.ParseDNS(results)
 
 	} else {
-		res, err := http.Get(myapi.getUrl(query))
+		m, endpoint, err = myapi.queryHTTP(ctx, url.PathEscape(item.String()))
+
+		if err != nil {
+			return m, endpoint, err
+		}
+
+	}
+
+	// Only json(x) return the item, fill it in for the other methods
+	if len(m.Results) > 0 && m.Results[0].Item == "" {
+		m.Results[0].Item = item.String()
+	}
+
+	m.Method = myapi.ApiMethod
+
+	myapi.store(ctx, item, m)
+
+	return m, endpoint, nil
+}
+
+// queryHTTP runs a web query, failing over to the next endpoint on network errors,
+// 5xx or 429 responses. The endpoint last queried is returned.
+func (myapi Api) queryHTTP(ctx context.Context, query string) (m JsonRecord, endpoint string, err error) {
+
+	endpoints := myapi.endpoints()
+
+	for i := range endpoints {
+
+		endpoint = endpoints[i]
 
-		// URL malformed? Return an error
-		if res.StatusCode == 404 {
-			return m, errors.New("Invalid request, check URL not malformed: " + myapi.getUrl(query))
+		var retry bool
+		m, retry, err = myapi.queryEndpoint(ctx, endpoint, query)
+
+		// Success, or an error another endpoint will not fix
+		if err == nil || !retry || ctx.Err() != nil || i == len(endpoints)-1 {
+			break
 		}
 
-		// Forbidden? Return an error
-		if res.StatusCode == 403 {
-			return m, errors.New("Request forbidden, check API key or IP for authorization: " + myapi.getUrl(query))
+		myapi.logger().WarnContext(ctx, "zetascan endpoint failed, failing over", "method", myapi.ApiMethod, "endpoint", endpoint, "next", endpoints[i+1], "error", err)
+
+		if myapi.Metrics != nil {
+			myapi.Metrics.ObserveFailover(myapi.ApiMethod, endpoint, endpoints[i+1])
 		}
+	}
 
-		//fmt.Println(myapi.getUrl(query), res, err)
+	return m, endpoint, err
+}
 
-		if err != nil {
-			return m, err
+// queryEndpoint runs a web query against one endpoint, and if the error is worth retrying elsewhere.
+// Each key for the endpoint is tried in turn while the request is forbidden,
+// starting from the last key that answered.
+func (myapi Api) queryEndpoint(ctx context.Context, endpoint string, query string) (m JsonRecord, retry bool, err error) {
+
+	keys := myapi.keysFor(endpoint)
+	last := myapi.lastKey.For(endpoint)
+	start := int(last.Load()) % len(keys)
+
+	for n := range keys {
+
+		i := (start + n) % len(keys)
+
+		myapi.apiKey = keys[i]
+		m, retry, err = myapi.queryKey(ctx, endpoint, query)
+
+		if err == nil {
+			last.Store(int32(i))
 		}
 
-		m, err = myapi.parseResult(res)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.Code != http.StatusForbidden || ctx.Err() != nil || n == len(keys)-1 {
+			break
+		}
+
+		myapi.logger().WarnContext(ctx, "zetascan key forbidden, trying the next key", "method", myapi.ApiMethod, "endpoint", endpoint, "key", i+1, "keys", len(keys))
+
+		if myapi.Metrics != nil {
+			myapi.Metrics.ObserveRetry(myapi.ApiMethod, endpoint, "forbidden")
+		}
+	}
+
+	return m, retry, err
+}
+
+// keysFor return the keys to try for an endpoint, from Keys or the key given to Init
+func (myapi Api) keysFor(endpoint string) []Secret {
+
+	if myapi.Keys != nil {
+		if keys := myapi.Keys.Keys(endpoint); len(keys) > 0 {
+			return keys
+		}
+	}
+
+	return []Secret{myapi.apiKey}
+}
+
+// StatusError is returned for an HTTP status other than 200 or 204 from an endpoint
+type StatusError struct {
+	Code    int // e.g 403
+	Message string
+}
 
-		//fmt.Println(err)
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// queryKey runs a web query against one endpoint with the current key
+func (myapi Api) queryKey(ctx context.Context, endpoint string, query string) (m JsonRecord, retry bool, err error) {
+
+	ctx, span := myapi.startSpan(ctx, SpanHTTP)
+	span.SetAttribute(AttrEndpoint, endpoint)
 
+	defer func() {
 		if err != nil {
-			return m, err
+			span.RecordError(err)
 		}
+		span.End()
+	}()
+
+	myapi.apiProtocol, myapi.apiURL, _ = strings.Cut(endpoint, "://")
+
+	logger := myapi.logger()
+	logger.DebugContext(ctx, "zetascan request", "url", myapi.redactedUrl(query))
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, myapi.getUrl(query), nil)
+
+	if err != nil {
+		return m, false, myapi.redactError(err, query)
+	}
+
+	if myapi.HeaderAuth && myapi.apiKey != "" {
+		req.Header.Set(KeyHeader, myapi.apiKey.Reveal())
 	}
 
-	return m, nil
+	res, err := myapi.httpClient().Do(req)
+
+	if err != nil {
+		return m, true, myapi.redactError(err, query)
+	}
+
+	defer res.Body.Close()
+
+	span.SetAttribute(AttrStatusCode, res.StatusCode)
+
+	// URL malformed? Return an error
+	if res.StatusCode == 404 {
+		return m, false, &StatusError{Code: res.StatusCode, Message: "Invalid request, check URL not malformed: " + myapi.redactedUrl(query)}
+	}
+
+	// Forbidden? Return an error
+	if res.StatusCode == 403 {
+		return m, false, &StatusError{Code: res.StatusCode, Message: "Request forbidden, check API key or IP for authorization: " + myapi.redactedUrl(query)}
+	}
+
+	// Rate limited or server error, another endpoint may answer
+	if res.StatusCode == 429 || res.StatusCode >= 500 {
+		return m, true, &StatusError{Code: res.StatusCode, Message: "Request failed with status " + res.Status + " from " + endpoint}
+	}
+
+	if res.StatusCode != 200 && res.StatusCode != 204 {
+		return m, false, &StatusError{Code: res.StatusCode, Message: "Unexpected response status " + res.Status + " from " + endpoint}
+	}
+
+	m, err = myapi.parseResult(res)
+
+	if err != nil {
+		logger.ErrorContext(ctx, "zetascan response could not be parsed", "url", myapi.redactedUrl(query), "status", res.StatusCode, "error", err)
+	}
 
+	return m, false, err
 }
 
-// Verify a query to zetascan is returning valid data
+// endpoints return the primary endpoint followed by any fallbacks
+func (myapi Api) endpoints() []string {
+	return append([]string{myapi.apiProtocol + "://" + myapi.apiURL}, myapi.apiFallback...)
+}
+
+// Verify a query to zetascan is returning valid data. Progress is logged to
+// Api.Logger at debug level, or info level if verbose.
+//
+// Deprecated: Use Diagnose, which returns a structured report
 func (myapi Api) Verify(status bool, verbose bool) (totalResults []Results, err error) {
 
-	tests := make(map[string]bool)
+	// Verbose progress is logged at info level, otherwise debug
+	level := slog.LevelDebug
+	if verbose == true {
+		level = slog.LevelInfo
+	}
 
-	// Records that will pass (whitelist)
-	tests["okdomain.org"] = false
-	tests["127.9.9.4"] = false
+	logger := myapi.logger()
 
-	// Records that will fail (blacklisted)
-	tests["baddomain.org"] = true
-	tests["127.9.9.1"] = true
-	tests["127.9.9.2"] = true
-	tests["127.9.9.3"] = true
+	// Run the documented test items in order, see Diagnose for a structured report
+	for _, test := range TestItems {
 
-	//for i := 0; i < len(tests); i++ {
-	for key, value := range tests {
+		key, value := test.Item, test.Listed
 
-		if verbose == true {
-			fmt.Println("Testing", key, value)
-		}
+		logger.Log(context.Background(), level, "zetascan verify", "item", key, "listed", value, "method", myapi.ApiMethod)
 
 		// Time the query length
 		startTime := time.Now()
@@ -187,32 +461,14 @@ func (myapi Api) Verify(status bool, verbose bool) (totalResults []Results, err
 		m := time.Duration(time.Since(startTime))
 		durationTime := int64(m / time.Millisecond)
 
-		if verbose == true {
-			fmt.Println("Response =>", response)
-		}
-
 		if err != nil {
-			fmt.Println(err)
+			logger.Warn("zetascan verify failed", "item", key, "method", myapi.ApiMethod, "error", err)
+		} else {
+			logger.Log(context.Background(), level, "zetascan verify response", "item", key, "response", response)
 		}
 
 		// Does it match?
-		match := myapi.IsMatch(&response)
-
-		/*
-			if match == true && value != true {
-				fmt.Println(key, ": Failed (", durationTime, ")")
-			}
-
-			if match == true {
-				fmt.Println(key, ": Matched (", durationTime, ")")
-			} else {
-				fmt.Println(key, ": No hit (", durationTime, ")")
-			}
-
-			if verbose == true {
-				fmt.Println("Resp => ", res, "\n")
-			}
-		*/
+		match := err == nil && len(response.Results) > 0 && myapi.IsMatch(&response)
 
 		// Store the results and return the group in a struct, regardless of the method
 		result := Results{
@@ -231,15 +487,39 @@ func (myapi Api) Verify(status bool, verbose bool) (totalResults []Results, err
 	return totalResults, nil
 }
 
+// redactedUrl return the query URL with the API key hidden, for errors and logs
+func (myapi Api) redactedUrl(domain string) string {
+
+	if myapi.apiKey != "" {
+		myapi.apiKey = Redacted
+	}
+
+	return myapi.getUrl(domain)
+}
+
+// redactError hides the API key in the URL of an HTTP client error
+func (myapi Api) redactError(err error, domain string) error {
+
+	var uerr *url.Error
+
+	if errors.As(err, &uerr) {
+		redacted := *uerr
+		redacted.URL = myapi.redactedUrl(domain)
+		return &redacted
+	}
+
+	return err
+}
+
 // getUrl Return a URL to query zetascan
 func (myapi Api) getUrl(domain string) string {
 
 	// Encode the apiKey if specified
 	v := url.Values{}
 
-	// If the API key is specified, add the query URI
-	if myapi.apiKey != "" {
-		v.Set("key", myapi.apiKey)
+	// If the API key is specified, and not sent as a header, add the query URI
+	if myapi.apiKey != "" && !myapi.HeaderAuth {
+		v.Set("key", myapi.apiKey.Reveal())
 	}
 
 	// TODO: Improve
@@ -251,19 +531,9 @@ func (myapi Api) getUrl(domain string) string {
 // parseResult returns a struct with the zetascan response, regardless of the query method
 func (myapi Api) parseResult(resp *http.Response) (data JsonRecord, err error) {
 
-	// Init our object (Results is a []struct must be manually created)
+	// Init our object with a single empty result
 	data = JsonRecord{
-		Results: []struct {
-			Item       string       `json:"item"`
-			Found      bool         `json:"found"`
-			Score      float64      `json:"score"`
-			WebScore   float64      `json:"webscore"`
-			FromSubnet bool         `json:"fromSubnet"`
-			Sources    []string     `json:"sources"`
-			Wl         bool         `json:"wl"`
-			Wldata     string       `json:"wldata"`
-			Extended   JsonExtended `json:"extended"`
-		}{
+		Results: JsonResults{
 			{},
 		},
 	}
@@ -312,11 +582,13 @@ func (myapi Api) parseResult(resp *http.Response) (data JsonRecord, err error) {
 			*/
 
 			// Populate our struct with details of the request
-			data.Results[0].Score, _ = strconv.ParseFloat(resp.Header.Get("x-zetascan-score"), 32)
-			data.Results[0].WebScore, _ = strconv.ParseFloat(resp.Header.Get("x-zetascan-webscore"), 32)
+			data.Results[0].Score, _ = strconv.ParseFloat(resp.Header.Get("x-zetascan-score"), 64)
+			data.Results[0].WebScore, _ = strconv.ParseFloat(resp.Header.Get("x-zetascan-webscore"), 64)
 
 			// Populate our struct with details of the request
-			data.Results[0].Sources = strings.Split(";", resp.Header.Get("x-zetascan-sources"))
+			if sources := resp.Header.Get("x-zetascan-sources"); sources != "" {
+				data.Results[0].Sources = strings.Split(sources, ";")
+			}
 
 			// TODO: Clarify, since JSON wl and wl-data differ from HTTP query
 			//wl := resp.Header.Get("x-zetascan-wl")
@@ -329,9 +601,11 @@ func (myapi Api) parseResult(resp *http.Response) (data JsonRecord, err error) {
 			}
 
 			// Todo, Split based on ; similar to Sources?
-			data.Results[0].Wldata = resp.Header.Get("x-zetascan-wl")
+			if wl := resp.Header.Get("x-zetascan-wl"); wl != "null" {
+				data.Results[0].Wldata = wl
+			}
 
-			data.Status = resp.Header.Get("x-zetascan-success")
+			data.Status = resp.Header.Get("x-zetascan-status")
 
 			// TODO: Workaround, since HTTP missing the found header
 			if data.Results[0].Wl == true {
@@ -343,57 +617,7 @@ func (myapi Api) parseResult(resp *http.Response) (data JsonRecord, err error) {
 		}
 
 	case "text":
-		{
-
-			// Read the body and split from the specified API formatting
-			bodyString := string(body)
-			head := strings.Split(bodyString, ":")
-			str := strings.Split(head[1], ",")
-
-			/*
-				http://docs.zetascan.io/?php#http-format
-				item:bool,bool,wldata,score,source
-
-				Where:
-
-				the first bool is true, if found in any black list,
-				the second bool is true, if found in any white list,
-				wldata contains the data from the white list, and
-				score is followed by the list of sources where the item was found.
-
-				Updated for v2
-
-				baddomain.org:true,false,,1,0.6,dbl,red,gold,grey,black okdomain.org:true,true,,-0.1,-0.1,white 127.9.9.1:true,false,,0.95,0.6,xbl,sbl
-
-				okdomain.org:false,true,,-0.1,-0.1,white
-
-			*/
-
-			// Are we included in a blacklist?
-			if str[0] == "true" {
-				data.Results[0].Found = true
-			} else {
-				data.Results[0].Found = false
-			}
-
-			// Are we included in a whitelist?
-			if str[1] == "true" {
-				data.Results[0].Wl = true
-			} else {
-				data.Results[0].Wl = false
-			}
-
-			data.Results[0].Wldata = str[2]
-
-			// TODO: Should be a float32 vs float64
-			data.Results[0].Score, _ = strconv.ParseFloat(str[3], 32)
-
-			// TODO: Group together all sources into a response array
-			if len(str) > 3 {
-				//data.Results[0].Sources = str[4:len(str)]
-			}
-
-		}
+		return parseText(string(body))
 
 	case "json", "jsonx":
 		{
@@ -438,6 +662,95 @@ func (myapi Api) parseResult(resp *http.Response) (data JsonRecord, err error) {
 
 }
 
+// parseText parses a text format response, as returned by the text method and DNS TXT records
+func parseText(body string) (data JsonRecord, err error) {
+
+	// Init our object with a single empty result
+	data = JsonRecord{
+		Results: JsonResults{
+			{},
+		},
+	}
+
+	// Split from the specified API formatting, the item may contain a
+	// colon (IPv6) so split on the last one before the fields
+	bodyString := strings.TrimSpace(body)
+	comma := strings.Index(bodyString, ",")
+	colon := -1
+
+	if comma >= 0 {
+		colon = strings.LastIndex(bodyString[:comma], ":")
+	}
+
+	if colon < 0 {
+		return data, errors.New("Malformed text response: " + bodyString)
+	}
+
+	str := strings.Split(bodyString[colon+1:], ",")
+
+	if len(str) < 4 {
+		return data, errors.New("Malformed text response: " + bodyString)
+	}
+
+	/*
+		http://docs.zetascan.io/?php#http-format
+		item:bool,bool,wldata,score,source
+
+		Where:
+
+		the first bool is true, if found in any black list,
+		the second bool is true, if found in any white list,
+		wldata contains the data from the white list, and
+		score is followed by the list of sources where the item was found.
+
+		Updated for v2
+
+		baddomain.org:true,false,,1,0.6,dbl,red,gold,grey,black okdomain.org:true,true,,-0.1,-0.1,white 127.9.9.1:true,false,,0.95,0.6,xbl,sbl
+
+		okdomain.org:false,true,,-0.1,-0.1,white
+
+	*/
+
+	// Are we included in a blacklist?
+	if str[0] == "true" {
+		data.Results[0].Found = true
+	} else {
+		data.Results[0].Found = false
+	}
+
+	// Are we included in a whitelist?
+	if str[1] == "true" {
+		data.Results[0].Wl = true
+	} else {
+		data.Results[0].Wl = false
+	}
+
+	data.Results[0].Wldata = str[2]
+
+	data.Results[0].Score, _ = strconv.ParseFloat(str[3], 64)
+
+	// v2 adds the webscore before the sources
+	sources := str[4:]
+
+	if len(sources) > 0 {
+		if webscore, err := strconv.ParseFloat(sources[0], 64); err == nil {
+			data.Results[0].WebScore = webscore
+			sources = sources[1:]
+		}
+	}
+
+	// Group together all sources into a response array
+	for _, source := range sources {
+		if source != "" {
+			data.Results[0].Sources = append(data.Results[0].Sources, source)
+		}
+	}
+
+	data.Status = "success"
+
+	return data, nil
+}
+
 // TODO: getInfo returns a struct with expanded information on why the result listed
 func (myapi Api) getInfo(resp *http.Response) (status bool, err error) {
 
@@ -505,7 +818,8 @@ func (myapi Api) WebScore(response *JsonRecord) (score float64) {
 
 }
 
-// Toggle SSL support
+// Toggle SSL support, returning the protocol. Api methods have value receivers,
+// so the Api it is called on is unchanged, use SetEndpoint to switch protocol.
 func (myapi Api) ToggleSSL(ssl bool) (str string) {
 
 	if ssl == false {
@@ -518,29 +832,110 @@ func (myapi Api) ToggleSSL(ssl bool) (str string) {
 
 }
 
-// Return the API key used
+// SetEndpoint query another zetascan end-point, e.g on-prem or a local emulator.
+// The endpoint is a host[:port], optionally prefixed with http:// or https://
+//
+// Fallback endpoints are queried in order when an endpoint fails with a
+// network error, a 5xx or 429 response.
+func (myapi Api) SetEndpoint(endpoint string, fallbacks ...string) (myapi2 Api, err error) {
+
+	protocol, host, err := parseEndpoint(endpoint, myapi.apiProtocol)
+
+	if err != nil {
+		return myapi, err
+	}
+
+	myapi.apiProtocol = protocol
+	myapi.apiURL = host
+	myapi.apiFallback = nil
+
+	for _, fallback := range fallbacks {
+
+		protocol, host, err := parseEndpoint(fallback, myapi.apiProtocol)
+
+		if err != nil {
+			return myapi, err
+		}
+
+		myapi.apiFallback = append(myapi.apiFallback, protocol+"://"+host)
+	}
+
+	return myapi, nil
+}
+
+// parseEndpoint splits an endpoint into the protocol and host, using protocol if not specified
+func parseEndpoint(endpoint string, protocol string) (string, string, error) {
+
+	if strings.Contains(endpoint, "://") {
+
+		u, err := url.Parse(endpoint)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", "", errors.New("Endpoint must use http or https: " + endpoint)
+		}
+
+		protocol = u.Scheme
+		endpoint = u.Host
+	}
+
+	if endpoint == "" || strings.ContainsAny(endpoint, "/?#") {
+		return "", "", errors.New("Invalid endpoint, expected host[:port]: " + endpoint)
+	}
+
+	if protocol == "" {
+		protocol = "https"
+	}
+
+	return protocol, endpoint, nil
+}
+
+// GetEndpoint return the URL of the end-point used for web queries
+func (myapi Api) GetEndpoint() string {
+	return myapi.apiProtocol + "://" + myapi.apiURL
+}
+
+// Return the API key used, redacted
+//
+// Deprecated: Use Key, the key is no longer returned in plain text
 func (myapi Api) GetConf() string {
 
+	return myapi.apiKey.String()
+}
+
+// Key return the API key used, as a Secret
+func (myapi Api) Key() Secret {
 	return myapi.apiKey
 }
 
+// String describes the Api with the API key redacted
+func (myapi Api) String() string {
+
+	auth := "ip"
+	if myapi.Keys != nil {
+		auth = "key provider"
+	} else if myapi.apiKey != "" {
+		auth = "key=" + myapi.apiKey.String()
+	}
+
+	return "zetascan.Api{endpoint=" + myapi.GetEndpoint() + " method=" + myapi.ApiMethod + " auth=" + auth + "}"
+}
+
+// GoString redacts the API key for %#v
+func (myapi Api) GoString() string {
+	return myapi.String()
+}
+
 // Preform a DNS query against the zetascan API
 func (myapi Api) ParseDNS(results []net.IP) (data JsonRecord, err error) {
 
 	// Move to a function to init?
-	// Init our object (Results is a []struct must be manually created)
+	// Init our object with a single empty result
 	data = JsonRecord{
-		Results: []struct {
-			Item       string       `json:"item"`
-			Found      bool         `json:"found"`
-			Score      float64      `json:"score"`
-			WebScore   float64      `json:"webscore"`
-			FromSubnet bool         `json:"fromSubnet"`
-			Sources    []string     `json:"sources"`
-			Wl         bool         `json:"wl"`
-			Wldata     string       `json:"wldata"`
-			Extended   JsonExtended `json:"extended"`
-		}{
+		Results: JsonResults{
 			{},
 		},
 	}
@@ -555,24 +950,9 @@ func (myapi Api) ParseDNS(results []net.IP) (data JsonRecord, err error) {
 			data.Results[0].Found = true
 		}
 
-		// Spamhaus
-		if strings.HasPrefix(match.String(), "127.0.0") {
-			//fmt.Println("Spamhaus hit")
-		}
-
-		// Spamhaus abuse
-		if strings.HasPrefix(match.String(), "127.0.1") {
-			//fmt.Println("Spamhaus abuse")
-		}
-
-		// URIBL match
-		if strings.HasPrefix(match.String(), "127.1.0") {
-			//fmt.Println("URIBL abuse")
-		}
-
 		// IP White lists from DNSWL
 		if strings.HasPrefix(match.String(), "127.8.0") {
-			//fmt.Println("DNSWL whitelist")
+			data.Results[0].Wl = true
 		}
 
 	}
@@ -583,6 +963,98 @@ func (myapi Api) ParseDNS(results []net.IP) (data JsonRecord, err error) {
 
 // Preform a DNS query against the zetascan API
 func (myapi Api) QueryDNS(query string, retry int) (json []net.IP, err error) {
+	return myapi.queryDNS(context.Background(), query, retry)
+}
+
+// observeRetry reports a DNS retry to the metrics hook, if any
+func (myapi Api) observeRetry(server string, reason string) {
+
+	if myapi.Metrics != nil {
+		myapi.Metrics.ObserveRetry("dns", "dns://"+server, reason)
+	}
+}
+
+// exchangeDNS sends a single DNS query over udp or tcp
+func (myapi Api) exchangeDNS(ctx context.Context, msg *dns.Msg, server string, network string, attempt int) (in *dns.Msg, err error) {
+
+	ctx, span := myapi.startSpan(ctx, SpanDNS)
+	span.SetAttribute(AttrDNSServer, server)
+	span.SetAttribute(AttrDNSNetwork, network)
+	span.SetAttribute(AttrAttempt, attempt)
+
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+		} else {
+			span.SetAttribute(AttrDNSRcode, dns.RcodeToString[in.Rcode])
+
+			if in.Truncated {
+				span.SetAttribute(AttrRetry, "truncated")
+			}
+		}
+		span.End()
+	}()
+
+	client := &dns.Client{Net: network}
+	in, _, err = client.ExchangeContext(ctx, msg, server)
+
+	return in, err
+}
+
+// queryDNS performs the DNS A query, retrying on timeouts until ctx is done
+func (myapi Api) queryDNS(ctx context.Context, query string, retry int) (json []net.IP, err error) {
+
+	in, err := myapi.resolve(ctx, query, dns.TypeA, retry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the result(s) into a net.IP struct
+	result := []net.IP{}
+
+	// Append all responses into an array
+	for _, record := range in.Answer {
+		if t, ok := record.(*dns.A); ok {
+			result = append(result, t.A)
+		}
+	}
+
+	myapi.logger().DebugContext(ctx, "zetascan DNS answer", "name", dns.Fqdn(query), "server", myapi.dnsServer(), "rcode", dns.RcodeToString[in.Rcode], "answers", result)
+
+	return result, nil
+}
+
+// queryTXT performs the DNS TXT query, parsing the text format answer. No
+// answer (NXDOMAIN) is not listed.
+func (myapi Api) queryTXT(ctx context.Context, query string, retry int) (m JsonRecord, err error) {
+
+	in, err := myapi.resolve(ctx, query, dns.TypeTXT, retry)
+
+	if err != nil {
+		return m, err
+	}
+
+	var txt []string
+
+	for _, record := range in.Answer {
+		if t, ok := record.(*dns.TXT); ok {
+			txt = append(txt, strings.Join(t.Txt, ""))
+		}
+	}
+
+	myapi.logger().DebugContext(ctx, "zetascan DNS answer", "name", dns.Fqdn(query), "server", myapi.dnsServer(), "rcode", dns.RcodeToString[in.Rcode], "answers", txt)
+
+	if len(txt) == 0 {
+		return myapi.ParseDNS(nil)
+	}
+
+	return parseText(txt[0])
+}
+
+// resolve sends a DNS query, retrying on timeouts until ctx is done, and over
+// TCP if truncated. Any rcode other than success or NXDOMAIN is an error.
+func (myapi Api) resolve(ctx context.Context, query string, qtype uint16, retry int) (in *dns.Msg, err error) {
 
 	// Assemble our DNS query parts
 	msg := new(dns.Msg)
@@ -591,7 +1063,7 @@ func (myapi Api) QueryDNS(query string, retry int) (json []net.IP, err error) {
 	msg.Question = make([]dns.Question, 1)
 
 	// Build the query
-	msg.Question[0] = dns.Question{Name: dns.Fqdn(query), Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	msg.Question[0] = dns.Question{Name: dns.Fqdn(query), Qtype: qtype, Qclass: dns.ClassINET}
 
 	// Use the zetascan DNS server directly for the query
 
@@ -600,30 +1072,65 @@ func (myapi Api) QueryDNS(query string, retry int) (json []net.IP, err error) {
 	// Currenrtly using the v1 method
 	// dig baddomain.org @api.zetascan.com
 
-	in, err := dns.Exchange(msg, "api.zetascan.com:53")
-
-	// Load the result(s) into a net.IP struct
-	result := []net.IP{}
+	server := myapi.dnsServer()
 
 	// Timeout? Try again, max retry times
-	if err != nil {
+	for attempt := 0; ; attempt++ {
+
+		in, err = myapi.exchangeDNS(ctx, msg, server, "udp", attempt)
 
 		// Failed, try again ...
-		if strings.HasSuffix(err.Error(), "i/o timeout") && retry > 0 {
-			retry--
-			return myapi.QueryDNS(query, retry)
+		var nerr net.Error
+		if err != nil && errors.As(err, &nerr) && nerr.Timeout() && attempt < retry && ctx.Err() == nil {
+			myapi.logger().WarnContext(ctx, "zetascan DNS query timed out, retrying", "name", msg.Question[0].Name, "server", server, "attempt", attempt+1)
+			myapi.observeRetry(server, "timeout")
+			continue
 		}
 
-		return nil, err
+		break
+	}
 
+	if err != nil {
+		return nil, err
 	}
 
-	// Append all responses into an array
-	for _, record := range in.Answer {
-		if t, ok := record.(*dns.A); ok {
-			result = append(result, t.A)
+	// Truncated over UDP, try again via TCP
+	if in.Truncated {
+		myapi.logger().DebugContext(ctx, "zetascan DNS answer truncated, retrying over TCP", "name", msg.Question[0].Name, "server", server)
+		myapi.observeRetry(server, "truncated")
+		in, err = myapi.exchangeDNS(ctx, msg, server, "tcp", 0)
+
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return result, nil
+	// NXDOMAIN is not listed, any other failure is an error
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		return nil, errors.New("DNS query failed: " + dns.RcodeToString[in.Rcode])
+	}
+
+	return in, nil
+}
+
+// dnsServer return the nameserver for DNS queries, host:port
+func (myapi Api) dnsServer() string {
+
+	if myapi.DnsServer == "" {
+		return "api.zetascan.com:53"
+	}
+
+	return myapi.DnsServer
+}
+
+// logger return the logger, discarding logs if none is set
+func (myapi Api) logger() *slog.Logger {
+
+	if myapi.Logger == nil {
+		return discardLogger
+	}
+
+	return myapi.Logger
 }
+
+var discardLogger = slog.New(slog.DiscardHandler)
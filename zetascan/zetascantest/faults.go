@@ -0,0 +1,233 @@
+package zetascantest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+// Fault describes how the emulator misbehaves for a request
+type Fault struct {
+	// Count is the number of consecutive requests the fault applies to, 0 for
+	// one request and -1 for every request from then on
+	Count int
+
+	// Latency delays the response (HTTP and DNS)
+	Latency time.Duration
+
+	// Status responds with an HTTP error status instead, e.g 403, 429 or 503
+	Status int
+
+	// Truncate sends only half of the body, with the full Content-Length
+	Truncate bool
+
+	// InvalidJSON replaces the body with malformed data
+	InvalidJSON bool
+
+	// Trickle writes the body a byte at a time, at this interval
+	Trickle time.Duration
+
+	// Drop closes the HTTP connection without a response, or ignores a DNS query.
+	// Note Go's HTTP client retries once when a reused connection is dropped.
+	Drop bool
+}
+
+// Burst return a fault responding with an HTTP status to n consecutive requests
+func Burst(status int, n int) Fault {
+	return Fault{Status: status, Count: n}
+}
+
+// faultScript is a queue of faults for one item or method
+type faultScript struct {
+	faults []Fault
+	used   int // Requests served by faults[0]
+}
+
+// next return the fault for the next request, if any
+func (fs *faultScript) next() (Fault, bool) {
+
+	for len(fs.faults) > 0 {
+
+		fault := fs.faults[0]
+		count := fault.Count
+
+		if count == 0 {
+			count = 1
+		}
+
+		if count < 0 || fs.used < count {
+			fs.used++
+			return fault, true
+		}
+
+		fs.faults = fs.faults[1:]
+		fs.used = 0
+	}
+
+	return Fault{}, false
+}
+
+// faultState holds the scripts and request counts of a server
+type faultState struct {
+	mu       sync.Mutex
+	items    map[string]*faultScript
+	methods  map[string]*faultScript
+	requests map[string]int
+}
+
+// InjectItem scripts faults for queries of an item, applied in order, one per
+// request (see Fault.Count). Item faults take priority over method faults.
+func (s *Server) InjectItem(item string, faults ...Fault) error {
+
+	parsed, err := zetascan.ParseItem(item)
+
+	if err != nil {
+		return err
+	}
+
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+
+	if s.faults.items == nil {
+		s.faults.items = make(map[string]*faultScript)
+	}
+
+	s.faults.items[parsed.Host] = &faultScript{faults: faults}
+
+	return nil
+}
+
+// InjectMethod scripts faults for every query via a method (http, text, json, jsonx or dns)
+func (s *Server) InjectMethod(method string, faults ...Fault) {
+
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+
+	if s.faults.methods == nil {
+		s.faults.methods = make(map[string]*faultScript)
+	}
+
+	s.faults.methods[method] = &faultScript{faults: faults}
+}
+
+// ClearFaults removes all scripted faults
+func (s *Server) ClearFaults() {
+
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+
+	s.faults.items = nil
+	s.faults.methods = nil
+}
+
+// Requests return the number of requests received for an item or method
+func (s *Server) Requests(target string) int {
+
+	if parsed, err := zetascan.ParseItem(target); err == nil {
+		target = parsed.Host
+	}
+
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+
+	return s.faults.requests[target]
+}
+
+// nextFault counts a request, returning the scripted fault for it if any
+func (s *Server) nextFault(method, item string) (Fault, bool) {
+
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+
+	if s.faults.requests == nil {
+		s.faults.requests = make(map[string]int)
+	}
+
+	s.faults.requests[method]++
+	if item != "" {
+		s.faults.requests[item]++
+	}
+
+	if script, ok := s.faults.items[item]; ok {
+		if fault, ok := script.next(); ok {
+			return fault, true
+		}
+	}
+
+	if script, ok := s.faults.methods[method]; ok {
+		if fault, ok := script.next(); ok {
+			return fault, true
+		}
+	}
+
+	return Fault{}, false
+}
+
+// writeFault writes a recorded response to w, misbehaving as described by fault
+func writeFault(w http.ResponseWriter, rec *httptest.ResponseRecorder, fault Fault) {
+
+	if fault.Latency > 0 {
+		time.Sleep(fault.Latency)
+	}
+
+	if fault.Drop {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	if fault.Status != 0 {
+		if fault.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, http.StatusText(fault.Status), fault.Status)
+		return
+	}
+
+	body := rec.Body.Bytes()
+
+	if fault.InvalidJSON {
+		body = []byte(`{"results":[{"item":"`)
+	}
+
+	for key, values := range rec.Header() {
+		w.Header()[key] = values
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	if fault.Truncate {
+		body = body[:len(body)/2]
+	}
+
+	w.WriteHeader(rec.Code)
+
+	if fault.Trickle <= 0 {
+		w.Write(body)
+	} else {
+		flusher, _ := w.(http.Flusher)
+		for i := range body {
+			w.Write(body[i : i+1])
+			if flusher != nil {
+				flusher.Flush()
+			}
+			time.Sleep(fault.Trickle)
+		}
+	}
+
+	// Close the connection rather than leave the client waiting on the missing body
+	if fault.Truncate {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,514 @@
+// Package zetascantest provides a local zetascan emulator for offline testing.
+//
+// The emulator serves the /v2/check/{http,text,json,jsonx}/{item} endpoints and
+// a DNS responder for A and TXT lookups, answering from a set of fixtures. The
+// documented test items (baddomain.org, okdomain.org, 127.9.9.1 - 127.9.9.4)
+// are preloaded by DefaultFixtures.
+package zetascantest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/zetascan/go-zetascan/zetascan"
+)
+
+//go:embed fixtures.json
+var defaultFixtures []byte
+
+// Fixture is the data returned for one item, in every format
+type Fixture struct {
+	Found      bool                  `json:"found"`
+	Score      float64               `json:"score"`
+	WebScore   float64               `json:"webscore"`
+	FromSubnet bool                  `json:"fromSubnet"`
+	Sources    []string              `json:"sources"`
+	Wl         bool                  `json:"wl"`
+	Wldata     string                `json:"wldata"`
+	Extended   zetascan.JsonExtended `json:"extended"`
+
+	// A records returned via DNS, defaults to 127.0.0.2 if found, 127.8.0.1 if whitelisted
+	DNS []string `json:"dns,omitempty"`
+}
+
+// Fixtures maps normalised items (see zetascan.ParseItem) to their fixture
+type Fixtures map[string]Fixture
+
+// DefaultFixtures return the documented zetascan test items
+func DefaultFixtures() Fixtures {
+
+	fixtures, err := ParseFixtures(defaultFixtures)
+
+	if err != nil {
+		panic("zetascantest: invalid embedded fixtures: " + err.Error())
+	}
+
+	return fixtures
+}
+
+// LoadFixtures reads fixtures from a JSON file, keyed by item
+func LoadFixtures(path string) (Fixtures, error) {
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFixtures(data)
+}
+
+// ParseFixtures decodes JSON fixtures, normalising each item
+func ParseFixtures(data []byte) (Fixtures, error) {
+
+	var raw map[string]Fixture
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	fixtures := make(Fixtures, len(raw))
+
+	for key, fixture := range raw {
+
+		item, err := zetascan.ParseItem(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fixtures[item.Host] = fixture
+	}
+
+	return fixtures, nil
+}
+
+// Server is a zetascan emulator, serving HTTP and DNS queries from fixtures
+type Server struct {
+	// URL of the HTTP endpoint, e.g http://127.0.0.1:38231
+	URL string
+
+	// DNSAddr is the host:port of the DNS responder
+	DNSAddr string
+
+	// Key, if set, is required on every HTTP query, in the URL or X-Api-Key header
+	Key string
+
+	mu       sync.RWMutex
+	fixtures Fixtures
+
+	httpServer *http.Server
+	dnsServers []*dns.Server
+
+	faults faultState
+}
+
+// NewServer starts an emulator on random local ports with the given fixtures,
+// DefaultFixtures if nil. The caller should Close it when finished.
+func NewServer(fixtures Fixtures) *Server {
+
+	s := NewUnstartedServer(fixtures)
+
+	if err := s.Start("127.0.0.1:0", "127.0.0.1:0"); err != nil {
+		panic("zetascantest: failed to start server: " + err.Error())
+	}
+
+	return s
+}
+
+// NewUnstartedServer return an emulator that is not yet listening
+func NewUnstartedServer(fixtures Fixtures) *Server {
+
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+
+	return &Server{fixtures: fixtures}
+}
+
+// Start listens for HTTP on httpAddr, and DNS (UDP and TCP) on dnsAddr. Either may be empty to disable
+func (s *Server) Start(httpAddr, dnsAddr string) error {
+
+	if httpAddr != "" {
+
+		l, err := net.Listen("tcp", httpAddr)
+
+		if err != nil {
+			return err
+		}
+
+		s.URL = "http://" + l.Addr().String()
+		s.httpServer = &http.Server{Handler: s}
+
+		go s.httpServer.Serve(l)
+	}
+
+	if dnsAddr != "" {
+
+		pc, err := net.ListenPacket("udp", dnsAddr)
+
+		if err != nil {
+			s.Close()
+			return err
+		}
+
+		s.DNSAddr = pc.LocalAddr().String()
+
+		// Listen for TCP on the same port as UDP
+		l, err := net.Listen("tcp", s.DNSAddr)
+
+		if err != nil {
+			pc.Close()
+			s.Close()
+			return err
+		}
+
+		udp := &dns.Server{PacketConn: pc, Handler: s}
+		tcp := &dns.Server{Listener: l, Handler: s}
+		s.dnsServers = []*dns.Server{udp, tcp}
+
+		started := make(chan struct{}, 2)
+		udp.NotifyStartedFunc = func() { started <- struct{}{} }
+		tcp.NotifyStartedFunc = func() { started <- struct{}{} }
+
+		go udp.ActivateAndServe()
+		go tcp.ActivateAndServe()
+
+		<-started
+		<-started
+	}
+
+	return nil
+}
+
+// Close shuts down all listeners
+func (s *Server) Close() error {
+
+	var err error
+
+	if s.httpServer != nil {
+		err = s.httpServer.Close()
+	}
+
+	for _, server := range s.dnsServers {
+		if serr := server.Shutdown(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return err
+}
+
+// Api return an Api pointed at the emulator for HTTP and DNS queries
+func (s *Server) Api(apiKey string) zetascan.Api {
+
+	var myapi zetascan.Api
+
+	myapi, _ = myapi.Init(apiKey, true)
+
+	if s.URL != "" {
+		myapi, _ = myapi.SetEndpoint(s.URL)
+	}
+
+	if s.DNSAddr != "" {
+		myapi.DnsServer = s.DNSAddr
+	}
+
+	return myapi
+}
+
+// Set adds or replaces the fixture for an item
+func (s *Server) Set(item string, fixture Fixture) error {
+
+	parsed, err := zetascan.ParseItem(item)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.fixtures[parsed.Host] = fixture
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Delete removes the fixture for an item, it will no longer be found
+func (s *Server) Delete(item string) {
+
+	parsed, err := zetascan.ParseItem(item)
+
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.fixtures, parsed.Host)
+	s.mu.Unlock()
+}
+
+// lookup return the fixture for a normalised item, and if it exists
+func (s *Server) lookup(host string) (Fixture, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fixture, ok := s.fixtures[host]
+	return fixture, ok
+}
+
+// ServeHTTP answers /v2/check/{method}/{item}, applying any scripted faults
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 4)
+
+	if r.Method != http.MethodGet || len(parts) != 4 || parts[0] != "v2" || parts[1] != "check" {
+		http.NotFound(w, r)
+		return
+	}
+
+	method, raw := parts[2], parts[3]
+
+	host := raw
+	if item, err := zetascan.ParseItem(raw); err == nil {
+		host = item.Host
+	}
+
+	fault, ok := s.nextFault(method, host)
+
+	if !ok {
+		s.serveCheck(w, r, method, raw)
+		return
+	}
+
+	rec := httptest.NewRecorder()
+	s.serveCheck(rec, r, method, raw)
+	writeFault(w, rec, fault)
+}
+
+// serveCheck writes the response for an item via a method
+func (s *Server) serveCheck(w http.ResponseWriter, r *http.Request, method, raw string) {
+
+	if s.Key != "" && r.URL.Query().Get("key") != s.Key && r.Header.Get(zetascan.KeyHeader) != s.Key {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	item, err := zetascan.ParseItem(raw)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fixture, _ := s.lookup(item.Host)
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	switch method {
+	case "http":
+		writeHTTP(w, item.Host, fixture)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(TextFormat(item.Host, fixture)))
+	case "json", "jsonx":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Record(item.Host, fixture, method == "jsonx"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeHTTP answers the http method, with results in x-zetascan-* headers
+func writeHTTP(w http.ResponseWriter, item string, fixture Fixture) {
+
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Zetascan-Items", item)
+	h.Set("X-Zetascan-Status", "success")
+	h.Set("X-Zetascan-Time", "0")
+
+	// Not found in any list, no content
+	if !fixture.Found && !fixture.Wl {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.Set("X-Zetascan-Score", strconv.FormatFloat(fixture.Score, 'f', -1, 64))
+	h.Set("X-Zetascan-Webscore", strconv.FormatFloat(fixture.WebScore, 'f', -1, 64))
+	h.Set("X-Zetascan-Sources", strings.Join(fixture.Sources, ";"))
+
+	if fixture.Wl {
+		h.Set("X-Zetascan-Wl", fixture.Wldata)
+	} else {
+		h.Set("X-Zetascan-Wl", "null")
+	}
+
+	w.Write([]byte("OK"))
+}
+
+// TextFormat return the text method response, item:found,wl,wldata,score,webscore,sources...
+func TextFormat(item string, fixture Fixture) string {
+
+	fields := []string{
+		strconv.FormatBool(fixture.Found),
+		strconv.FormatBool(fixture.Wl),
+		fixture.Wldata,
+		strconv.FormatFloat(fixture.Score, 'f', -1, 64),
+		strconv.FormatFloat(fixture.WebScore, 'f', -1, 64),
+	}
+
+	fields = append(fields, fixture.Sources...)
+
+	return item + ":" + strings.Join(fields, ",")
+}
+
+// Record return the json (or jsonx with extended data) response for an item
+func Record(item string, fixture Fixture, extended bool) zetascan.JsonRecord {
+
+	result := zetascan.JsonResult{
+		Item:       item,
+		Found:      fixture.Found,
+		Score:      fixture.Score,
+		WebScore:   fixture.WebScore,
+		FromSubnet: fixture.FromSubnet,
+		Sources:    fixture.Sources,
+		Wl:         fixture.Wl,
+		Wldata:     fixture.Wldata,
+	}
+
+	if result.Sources == nil {
+		result.Sources = []string{}
+	}
+
+	if extended {
+		result.Extended = fixture.Extended
+	}
+
+	return zetascan.JsonRecord{
+		Results: zetascan.JsonResults{result},
+		Status:  "success",
+	}
+}
+
+// ServeDNS answers A and TXT queries for items, or reversed IPs, optionally
+// followed by {key}.api.zetascan.com. DNS queries do not require the key.
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	item, err := dnsItem(q.Name, s.Key)
+
+	if err != nil {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	if fault, ok := s.nextFault("dns", item); ok {
+
+		if fault.Latency > 0 {
+			time.Sleep(fault.Latency)
+		}
+
+		// No reply, the client times out
+		if fault.Drop {
+			return
+		}
+
+		if fault.Status == http.StatusForbidden {
+			m.Rcode = dns.RcodeRefused
+			w.WriteMsg(m)
+			return
+		}
+
+		if fault.Status != 0 || fault.InvalidJSON {
+			m.Rcode = dns.RcodeServerFailure
+			w.WriteMsg(m)
+			return
+		}
+
+		// Truncated reply without answers, as if too large for UDP
+		if fault.Truncate {
+			m.Truncated = true
+			w.WriteMsg(m)
+			return
+		}
+	}
+
+	fixture, ok := s.lookup(item)
+
+	if !ok || (!fixture.Found && !fixture.Wl) {
+		m.Rcode = dns.RcodeNameError
+		w.WriteMsg(m)
+		return
+	}
+
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: 60}
+
+	switch q.Qtype {
+	case dns.TypeA:
+		hdr.Rrtype = dns.TypeA
+		for _, addr := range dnsAnswers(fixture) {
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP(addr).To4()})
+		}
+	case dns.TypeTXT:
+		hdr.Rrtype = dns.TypeTXT
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: hdr, Txt: []string{TextFormat(item, fixture)}})
+	}
+
+	w.WriteMsg(m)
+}
+
+// dnsAnswers return the A records for a fixture
+func dnsAnswers(fixture Fixture) []string {
+
+	if len(fixture.DNS) > 0 {
+		return fixture.DNS
+	}
+
+	if fixture.Wl {
+		return []string{"127.8.0.1"}
+	}
+
+	return []string{"127.0.0.2"}
+}
+
+// dnsItem converts a query name back into a normalised item
+func dnsItem(name, key string) (string, error) {
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	name = strings.TrimSuffix(name, ".api.zetascan.com")
+
+	// v2 format includes the key, domain.com.{key}.api.zetascan.com
+	if key != "" {
+		name = strings.TrimSuffix(name, "."+strings.ToLower(key))
+	}
+
+	// Reversed IPv4 and IPv6 nibbles, or a domain
+	item, err := zetascan.ParseDNSName(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	return item.Host, nil
+}
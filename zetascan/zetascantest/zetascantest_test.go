@@ -0,0 +1,112 @@
+package zetascantest_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/zetascan/go-zetascan/zetascan"
+	"github.com/zetascan/go-zetascan/zetascan/zetascantest"
+)
+
+// Every method parses the emulator's answer for every fixture
+func TestMethods(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	for item, fixture := range zetascantest.DefaultFixtures() {
+		for _, method := range []string{"http", "text", "json", "jsonx", "dns"} {
+			t.Run(method+"/"+item, func(t *testing.T) {
+
+				myzetascan := emulator.Api("")
+				myzetascan.ApiMethod = method
+
+				m, err := myzetascan.QueryContext(context.Background(), item)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(m.Results) != 1 {
+					t.Fatalf("%d results, want 1", len(m.Results))
+				}
+
+				result := m.Results[0]
+
+				if m.Method != method {
+					t.Errorf("Method = %q, want %q", m.Method, method)
+				}
+
+				if result.Found != fixture.Found || result.Wl != fixture.Wl {
+					t.Errorf("found, wl = %v, %v, want %v, %v", result.Found, result.Wl, fixture.Found, fixture.Wl)
+				}
+
+				// DNS answers only carry the listing
+				if method == "dns" {
+					return
+				}
+
+				// Items in no list have no score via http
+				if method == "http" && !fixture.Found && !fixture.Wl {
+					return
+				}
+
+				if result.Score != fixture.Score || result.WebScore != fixture.WebScore {
+					t.Errorf("score, webscore = %v, %v, want %v, %v", result.Score, result.WebScore, fixture.Score, fixture.WebScore)
+				}
+
+				if !slices.Equal(result.Sources, fixture.Sources) {
+					t.Errorf("sources = %q, want %q", result.Sources, fixture.Sources)
+				}
+
+				if method == "jsonx" && result.Extended != fixture.Extended {
+					t.Errorf("extended = %+v, want %+v", result.Extended, fixture.Extended)
+				}
+			})
+		}
+	}
+}
+
+// Items without a fixture are not listed via any method
+func TestMethodsUnknownItem(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	for _, method := range []string{"http", "text", "json", "jsonx", "dns"} {
+
+		myzetascan := emulator.Api("")
+		myzetascan.ApiMethod = method
+
+		m, err := myzetascan.QueryContext(context.Background(), "192.0.2.1")
+
+		if err != nil {
+			t.Errorf("%s: %v", method, err)
+			continue
+		}
+
+		if myzetascan.IsMatch(&m) {
+			t.Errorf("%s: 192.0.2.1 listed", method)
+		}
+	}
+}
+
+// The DNS TXT answer parses like the text method
+func TestDNSTXT(t *testing.T) {
+
+	emulator := zetascantest.NewServer(nil)
+	defer emulator.Close()
+
+	client := zetascan.NewClient(emulator.Api(""), zetascan.DefaultClientConfig)
+
+	m, err := client.Query(context.Background(), "127.9.9.1", zetascan.WithMethod("dns"), zetascan.WithRecordType(zetascan.RecordTXT))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Results) != 1 || !m.Results[0].Found || m.Results[0].Score != 0.95 {
+		t.Errorf("results = %+v", m.Results)
+	}
+}